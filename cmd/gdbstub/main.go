@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/yaroze"
+	"github.com/spf13/cobra"
+)
+
+type gdbStubOpts struct {
+	BaudRate   int
+	DeviceName string
+	Listen     string
+	Load       bool
+}
+
+func NewGDBStubCommand() *cobra.Command {
+	o := &gdbStubOpts{}
+	cmd := &cobra.Command{
+		Use:   "gdbstub [flags] [file]",
+		Short: "Debug a program on the console with GDB",
+		Long: `Debug a program on the console with GDB.
+
+GDB connects with "target remote" to the address given by --listen. If an
+ECOFF executable is given, its symbols are used to describe where the program
+stops, and with --load it is uploaded before waiting for GDB. Any other monitor
+command can be run from GDB with "monitor <command>".`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			if o.Load && len(args) == 0 {
+				log.Fatal("--load requires an executable")
+			}
+			var symbols *ecoff.File
+			if len(args) > 0 {
+				ft, err := format.DetectFile(args[0])
+				if err != nil {
+					log.Fatal(err)
+				}
+				if ft == format.ECOFF {
+					symbols, err = ecoff.Open(args[0])
+					if err != nil {
+						log.Fatal(err)
+					}
+					defer symbols.Close()
+				}
+			}
+			if o.DeviceName == "" {
+				name, rate, err := yaroze.FindDevice(o.BaudRate)
+				if err != nil {
+					log.Fatal(err)
+				}
+				o.DeviceName, o.BaudRate = name, rate
+			}
+			c, err := yaroze.OpenPort(&yaroze.PortConfig{
+				BaudRate:   o.BaudRate,
+				DeviceName: o.DeviceName,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer c.Close()
+
+			if o.Load {
+				if err := c.LoadFile(ctx, args[0]); err != nil {
+					log.Fatal(err)
+				}
+			}
+			s := yaroze.NewGDBStub(c)
+			s.Output = os.Stdout
+			s.Log = log.New(os.Stderr, "", log.LstdFlags)
+			if symbols != nil {
+				s.SetSymbols(symbols)
+			}
+
+			l, err := net.Listen("tcp", o.Listen)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("waiting for GDB on %s", l.Addr())
+			if err := s.Serve(l); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cmd.Flags().IntVarP(&o.BaudRate, "baud", "b", 0, "baud rate, detected if not set")
+	cmd.Flags().StringVarP(&o.DeviceName, "device-name", "d", "", "serial device name (e.g. /dev/ttyUSB0)")
+	cmd.Flags().StringVarP(&o.Listen, "listen", "l", "localhost:2345", "address to listen on")
+	cmd.Flags().BoolVar(&o.Load, "load", false, "upload the executable before waiting for GDB")
+	return cmd
+}
+
+func main() {
+	if err := NewGDBStubCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
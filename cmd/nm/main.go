@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	UndefinedOnly bool
+	DefinedOnly   bool
+	NumericSort   bool
+	NoSort        bool
+	Symbols       string
+}
+
+func NewNmCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nm [flags] <file>...",
+		Short: "List the symbols of ECOFF files, archives and PSX-EXE executables",
+		Long: `List the symbols of ECOFF files, archives and PSX-EXE executables.
+
+Each symbol is listed with its value and a letter giving its type, as with GNU
+nm: A (absolute), B (bss), C (common), D (data), G (small data), R (read-only
+data), S (small bss), T (text) or U (undefined). The letter is lower case for
+local symbols. A PSX-EXE has no symbols of its own, so they must be read from
+an ECOFF, .SYM or map file with --symbols.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.UndefinedOnly && opts.DefinedOnly {
+				log.Fatal("--undefined-only and --defined-only can't be used together")
+			}
+			var symbols disasm.Symbols
+			if opts.Symbols != "" {
+				var err error
+				symbols, err = binutils.LoadSymbols(opts.Symbols)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			w := bufio.NewWriter(os.Stdout)
+			defer w.Flush()
+			for _, name := range args {
+				objects, err := binutils.OpenObjects(name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				for _, o := range objects {
+					switch {
+					case o.Member != "":
+						fmt.Fprintf(w, "\n%s:\n", o.Member)
+					case len(args) > 1:
+						fmt.Fprintf(w, "\n%s:\n", o.Name)
+					}
+					var list []*binutils.Symbol
+					if o.PSX != nil {
+						list = binutils.PSXNameList(o.PSX, symbols)
+					} else {
+						list = binutils.ECOFFNameList(o.ECOFF)
+					}
+					writeSymbols(w, list)
+					o.Close()
+				}
+			}
+		},
+	}
+
+	cmd.PersistentFlags().BoolVarP(&opts.UndefinedOnly, "undefined-only", "u", false, "only list undefined symbols")
+	cmd.PersistentFlags().BoolVar(&opts.DefinedOnly, "defined-only", false, "only list defined symbols")
+	cmd.PersistentFlags().BoolVarP(&opts.NumericSort, "numeric-sort", "n", false, "sort symbols by address instead of by name")
+	cmd.PersistentFlags().BoolVarP(&opts.NoSort, "no-sort", "p", false, "list symbols in the order they are found")
+	cmd.PersistentFlags().StringVar(&opts.Symbols, "symbols", "", "read the symbols of a PSX-EXE from an ECOFF, .SYM or map file")
+	return cmd
+}
+
+// writeSymbols writes the symbols selected by the flags, in the order they
+// select. Undefined symbols have no value, so it is left blank.
+func writeSymbols(w io.Writer, symbols []*binutils.Symbol) {
+	var selected []*binutils.Symbol
+	for _, s := range symbols {
+		if opts.UndefinedOnly && !s.Undefined() || opts.DefinedOnly && s.Undefined() {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	switch {
+	case opts.NoSort:
+	case opts.NumericSort:
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].Value < selected[j].Value })
+	default:
+		sort.SliceStable(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	}
+	for _, s := range selected {
+		if s.Undefined() {
+			fmt.Fprintf(w, "%8s %c %s\n", "", s.Type, s.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%08x %c %s\n", s.Value, s.Type, s.Name)
+	}
+}
+
+func main() {
+	if err := NewNmCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
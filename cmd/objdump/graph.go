@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/pkg/errors"
+)
+
+// A graph is the JSON form of the functions of a file. External holds the
+// procedures that are called but aren't among Functions.
+type graph struct {
+	Functions []*disasm.Function `json:"functions"`
+	External  []*disasm.Function `json:"external,omitempty"`
+}
+
+// writeGraph writes the control-flow graphs of the functions in the executable
+// sections, or their call graph, as selected by --graph and --graph-format.
+func writeGraph(w io.Writer, sections []*binutils.Section, symbols disasm.Symbols) error {
+	if opts.Graph != "cfg" && opts.Graph != "calls" {
+		return errors.Errorf("unknown graph %q, expected cfg or calls", opts.Graph)
+	}
+	if opts.GraphFormat != "dot" && opts.GraphFormat != "json" {
+		return errors.Errorf("unknown graph format %q, expected dot or json", opts.GraphFormat)
+	}
+
+	var functions []*disasm.Function
+	for _, s := range sections {
+		if !s.Executable {
+			continue
+		}
+		insts := disasm.Disassemble(s.Data[:len(s.Data)&^3], s.Addr)
+		p := disasm.NewPrinter(insts, symbols)
+		for _, fn := range disasm.Functions(insts, symbols) {
+			if fn.Addr < opts.StartAddress || opts.StopAddress != 0 && fn.Addr >= opts.StopAddress {
+				continue
+			}
+			if opts.Graph == "cfg" && opts.GraphFormat == "dot" {
+				if err := p.WriteCFG(w, fn); err != nil {
+					return err
+				}
+			}
+			functions = append(functions, fn)
+		}
+	}
+
+	switch {
+	case opts.GraphFormat == "dot" && opts.Graph == "calls":
+		return disasm.WriteCallGraph(w, functions, symbols)
+	case opts.GraphFormat == "json":
+		g := &graph{Functions: functions}
+		if opts.Graph == "calls" {
+			g = callGraph(functions, symbols)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	}
+	return nil
+}
+
+// callGraph returns functions without their basic blocks, along with the
+// procedures they call that are outside of them.
+func callGraph(functions []*disasm.Function, symbols disasm.Symbols) *graph {
+	g := &graph{}
+	defined := make(map[uint32]bool)
+	for _, fn := range functions {
+		defined[fn.Addr] = true
+		f := *fn
+		f.Blocks = nil
+		g.Functions = append(g.Functions, &f)
+	}
+	for _, fn := range functions {
+		for _, addr := range fn.Calls {
+			if defined[addr] {
+				continue
+			}
+			defined[addr] = true
+			name, ok := symbols[addr]
+			if !ok {
+				name = disasm.ProcedureName(addr)
+			}
+			g.External = append(g.External, &disasm.Function{Name: name, Addr: addr})
+		}
+	}
+	return g
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+)
+
+// jsonOutput is everything objdump displays, for --format json. The headers
+// of the file are encoded by the ecoff and psx packages.
+type jsonOutput struct {
+	File        string             `json:"file"`
+	Format      format.Format      `json:"format"`
+	ECOFF       *ecoff.File        `json:"ecoff,omitempty"`
+	PSX         *psx.File          `json:"psx,omitempty"`
+	Contents    []*jsonContents    `json:"contents,omitempty"`
+	Disassembly []*jsonDisassembly `json:"disassembly,omitempty"`
+}
+
+// jsonContents is the contents of a section as hex, as with -s.
+type jsonContents struct {
+	Section string `json:"section"`
+	Addr    uint32 `json:"addr"`
+	Data    string `json:"data"`
+}
+
+// jsonDisassembly is the disassembly of a section, as with -d.
+type jsonDisassembly struct {
+	Section      string      `json:"section"`
+	Instructions []*jsonInst `json:"instructions"`
+}
+
+// jsonInst is a disassembled instruction. Name is the symbol or label at its
+// address, and Target and Ref are the destination of a branch and the address
+// built up with lui, if any.
+type jsonInst struct {
+	Addr    uint32  `json:"addr"`
+	Word    uint32  `json:"word"`
+	Text    string  `json:"text"`
+	Comment string  `json:"comment,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Target  *uint32 `json:"target,omitempty"`
+	Ref     *uint32 `json:"ref,omitempty"`
+}
+
+// writeJSON writes out, adding the contents and disassembly of the sections
+// as selected by the flags.
+func writeJSON(w io.Writer, out *jsonOutput, sections []*binutils.Section, symbols disasm.Symbols) error {
+	for _, s := range sections {
+		if opts.FullContents {
+			start, end := addressRange(s)
+			if start < end {
+				out.Contents = append(out.Contents, &jsonContents{
+					Section: s.Name,
+					Addr:    s.Addr + uint32(start),
+					Data:    hex.EncodeToString(s.Data[start:end]),
+				})
+			}
+		}
+		if opts.Disassemble && s.Executable {
+			if insts := disassemble(s); len(insts) > 0 {
+				out.Disassembly = append(out.Disassembly, disassemblyJSON(s, insts, symbols))
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// disassemblyJSON returns the instructions of the section as formatted by
+// objdump -d.
+func disassemblyJSON(s *binutils.Section, insts []*disasm.Inst, symbols disasm.Symbols) *jsonDisassembly {
+	p := disasm.NewPrinter(insts, symbols)
+	d := &jsonDisassembly{Section: s.Name, Instructions: make([]*jsonInst, 0, len(insts))}
+	for _, inst := range insts {
+		text, comment := p.Format(inst)
+		ji := &jsonInst{Addr: inst.Addr, Word: inst.Word, Text: text, Comment: comment}
+		ji.Name, _ = p.Name(inst.Addr)
+		if inst.HasTarget() {
+			target := inst.Target
+			ji.Target = &target
+		}
+		if inst.HasRef {
+			ref := inst.Ref
+			ji.Ref = &ref
+		}
+		d.Instructions = append(d.Instructions, ji)
+	}
+	return d
+}
@@ -3,68 +3,167 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/format"
 	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
-	"github.com/mewmew/mips"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
 	"github.com/spf13/cobra"
 )
 
 var opts struct {
-	Disassemble bool
+	Disassemble  bool
+	FullContents bool
+	Sections     []string
+	StartAddress uint32
+	StopAddress  uint32
+	Symbols      string
+	Graph        string
+	GraphFormat  string
+	Format       string
 }
 
 func NewObjdumpCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:  "objdump [flags] <file>",
+		Use:   "objdump [flags] <file>",
+		Short: "Display information from ECOFF and PSX-EXE files",
+		Long: `Display information from ECOFF and PSX-EXE files.
+
+The format of the file is detected from its magic number. Symbols from another
+file can be used to name addresses with --symbols, which accepts an ECOFF file
+(such as the one a PSX-EXE was created from with eco2exe), a no$psx .SYM file
+or a linker map file.
+
+With --format json, the headers, sections, symbols and relocations of the
+file, along with any contents and disassembly asked for, are written as JSON
+with a stable schema instead.
+
+With --graph, the control-flow graph of each function (cfg) or the call graph
+of the whole program (calls) is written instead, as Graphviz DOT or JSON.`,
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			f, err := ecoff.Open(args[0])
+			if opts.Format != "text" && opts.Format != "json" {
+				log.Fatalf("unknown format %q, expected text or json", opts.Format)
+			}
+			ft, err := format.DetectFile(args[0])
 			if err != nil {
 				log.Fatal(err)
 			}
-			defer f.Close()
-
-			fmt.Printf("%+v\n\nSections:\n", f)
-			for i, s := range f.Sections {
-				fmt.Printf("%2d %+v\n", i, s)
+			text := opts.Graph == "" && opts.Format == "text"
+			out := &jsonOutput{File: args[0], Format: ft}
+			var img *binutils.Image
+			switch ft {
+			case format.ECOFF:
+				f, err := ecoff.Open(args[0])
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+				if text {
+					printECOFF(f)
+				}
+				out.ECOFF = f
+				img, err = binutils.NewECOFFImage(f)
+				if err != nil {
+					log.Fatal(err)
+				}
+			case format.PSXEXE:
+				f, err := psx.Open(args[0])
+				if err != nil {
+					log.Fatal(err)
+				}
+				if text {
+					if err := psx.Print(f); err != nil {
+						log.Fatal(err)
+					}
+				}
+				out.PSX = f
+				img = binutils.NewPSXImage(f)
+			default:
+				log.Fatalf("%s: unsupported format %s", args[0], ft)
 			}
-			fmt.Print("\n")
-
-			fmt.Print("Symbols:\n")
-			i := 0
-			for _, s := range f.ExternalSymbols {
-				fmt.Printf("[%3d] e %+v\n", i, s)
-				i++
+			if opts.Symbols != "" {
+				sidecar, err := binutils.LoadSymbols(opts.Symbols)
+				if err != nil {
+					log.Fatal(err)
+				}
+				img.AddSymbols(sidecar)
 			}
-			for _, s := range f.LocalSymbols {
-				fmt.Printf("[%3d] l %+v\n", i, s)
-				i++
+			symbols := img.Symbols
+			if _, ok := symbols[img.Entry]; !ok && ft == format.PSXEXE {
+				symbols[img.Entry] = "entry"
 			}
-			fmt.Print("\n")
 
-			if opts.Disassemble {
-				data := f.Data()
-				procs := f.SymbolsByType(ecoff.ST_PROC)
-				for i := 0; i < len(data); i += 4 {
-					addr := f.Entry + uint32(i)
-					if s, ok := procs[addr]; ok {
-						fmt.Printf("%s:\n", s.Name)
+			sections, err := selectSections(img.Sections)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if opts.Graph != "" {
+				if err := writeGraph(os.Stdout, sections, symbols); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			if opts.Format == "json" {
+				if err := writeJSON(os.Stdout, out, sections, symbols); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			if opts.FullContents {
+				for _, s := range sections {
+					if err := dumpSection(os.Stdout, s); err != nil {
+						log.Fatal(err)
 					}
-					inst, err := mips.Decode(data[i:])
-					if err != nil {
-						log.Printf("error decoding addr 0x%08X; %v", addr, err)
+				}
+			}
+			if opts.Disassemble {
+				for _, s := range sections {
+					if !s.Executable {
 						continue
 					}
-					fmt.Printf("\t%s\n", inst)
+					if err := disassembleSection(os.Stdout, s, symbols); err != nil {
+						log.Fatal(err)
+					}
 				}
 			}
 		},
 	}
 
 	cmd.PersistentFlags().BoolVarP(&opts.Disassemble, "disassemble", "d", false, "disassemble executable sections")
+	cmd.PersistentFlags().BoolVarP(&opts.FullContents, "full-contents", "s", false, "display the contents of sections as hex")
+	cmd.PersistentFlags().StringSliceVarP(&opts.Sections, "section", "j", nil, "only display the named sections (repeatable)")
+	cmd.PersistentFlags().Uint32Var(&opts.StartAddress, "start-address", 0, "only display data at or after this address")
+	cmd.PersistentFlags().Uint32Var(&opts.StopAddress, "stop-address", 0, "only display data before this address")
+	cmd.PersistentFlags().StringVar(&opts.Symbols, "symbols", "", "read additional symbols from an ECOFF, .SYM or map file")
+	cmd.PersistentFlags().StringVar(&opts.Graph, "graph", "", "write the control-flow graphs of functions (cfg) or the call graph (calls)")
+	cmd.PersistentFlags().StringVar(&opts.GraphFormat, "graph-format", "dot", "format of --graph: dot or json")
+	cmd.PersistentFlags().StringVar(&opts.Format, "format", "text", "output format: text or json")
 	return cmd
 }
 
+// printECOFF writes the headers, sections and symbols of f.
+func printECOFF(f *ecoff.File) {
+	fmt.Printf("%+v\n\nSections:\n", f)
+	for i, s := range f.Sections {
+		fmt.Printf("%2d %+v\n", i, s)
+	}
+	fmt.Print("\n")
+
+	fmt.Print("Symbols:\n")
+	i := 0
+	for _, s := range f.ExternalSymbols {
+		fmt.Printf("[%3d] e %+v\n", i, s)
+		i++
+	}
+	for _, s := range f.LocalSymbols {
+		fmt.Printf("[%3d] l %+v\n", i, s)
+		i++
+	}
+	fmt.Print("\n")
+}
+
 func main() {
 	if err := NewObjdumpCommand().Execute(); err != nil {
 		log.Fatal(err)
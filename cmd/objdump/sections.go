@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/pkg/errors"
+)
+
+// selectSections returns the sections named by --section, or all of them.
+func selectSections(all []*binutils.Section) ([]*binutils.Section, error) {
+	if len(opts.Sections) == 0 {
+		return all, nil
+	}
+	var sections []*binutils.Section
+	for _, name := range opts.Sections {
+		found := false
+		for _, s := range all {
+			if s.Name == name {
+				sections = append(sections, s)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("section %q not found", name)
+		}
+	}
+	return sections, nil
+}
+
+// addressRange returns the part of the section's contents that falls within
+// --start-address and --stop-address, as offsets into the section.
+func addressRange(s *binutils.Section) (int, int) {
+	start, end := 0, len(s.Data)
+	if opts.StartAddress > s.Addr {
+		start = int(opts.StartAddress - s.Addr)
+	}
+	if opts.StopAddress != 0 {
+		if opts.StopAddress <= s.Addr {
+			return 0, 0
+		}
+		if n := int(opts.StopAddress - s.Addr); n < end {
+			end = n
+		}
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// dumpSection writes the contents of the section as hex, in the style of
+// objdump -s.
+func dumpSection(w io.Writer, s *binutils.Section) error {
+	data := s.Data
+	start, end := addressRange(s)
+	if start == end {
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "Contents of section %s:\n", s.Name)
+	for i := start &^ 15; i < end; i += 16 {
+		fmt.Fprintf(bw, " %08x ", s.Addr+uint32(i))
+		var text [16]byte
+		for j := 0; j < 16; j++ {
+			if j%4 == 0 {
+				bw.WriteByte(' ')
+			}
+			k := i + j
+			if k < start || k >= end {
+				bw.WriteString("  ")
+				text[j] = ' '
+				continue
+			}
+			fmt.Fprintf(bw, "%02x", data[k])
+			text[j] = '.'
+			if data[k] >= 0x20 && data[k] < 0x7f {
+				text[j] = data[k]
+			}
+		}
+		fmt.Fprintf(bw, "  %s\n", text[:])
+	}
+	fmt.Fprint(bw, "\n")
+	return bw.Flush()
+}
+
+// disassembleSection writes the disassembly of the section, addressed from
+// where it is loaded.
+func disassembleSection(w io.Writer, s *binutils.Section, symbols disasm.Symbols) error {
+	insts := disassemble(s)
+	if len(insts) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "Disassembly of section %s:\n", s.Name); err != nil {
+		return err
+	}
+	if err := disasm.NewPrinter(insts, symbols).Fprint(w, insts); err != nil {
+		return err
+	}
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
+
+// disassemble returns the instructions of the section within --start-address
+// and --stop-address.
+func disassemble(s *binutils.Section) []*disasm.Inst {
+	data := s.Data
+	start, end := addressRange(s)
+	start, end = start&^3, end&^3
+	if start >= end {
+		return nil
+	}
+
+	// The whole section is decoded so that addresses composed before
+	// --start-address are still followed.
+	return disasm.Disassemble(data[:len(data)&^3], s.Addr)[start/4 : end/4]
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/diff"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	OldSymbols  string
+	NewSymbols  string
+	Disassemble bool
+	All         bool
+	Context     int
+	Format      string
+}
+
+func NewPsxdiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "psxdiff [flags] <old-file> <new-file>",
+		Short: "Compare the functions of two ECOFF or PSX-EXE files",
+		Long: `Compare the functions of two ECOFF or PSX-EXE files.
+
+Functions are aligned by their symbols and listed as added, removed, resized
+(their size changed), changed (their code changed) or moved (the same code at
+another address). Instructions are compared ignoring differences that only
+come from relocation, such as the targets of calls and the addresses of data
+moving. An address is only taken to be relocated if it is at a symbol or
+within a section of its file, and it must be at the same symbol and offset in
+both, while constants and hardware addresses must be identical. The
+relocation entries of ECOFF objects are used where there are any. With -d, the
+instructions that differ are shown for resized and changed functions.
+
+Symbols for a PSX-EXE can be read from another file with --old-symbols and
+--new-symbols, as with objdump. Functions without symbols are named by their
+address, so they only align if they didn't move.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.Format != "text" && opts.Format != "json" {
+				log.Fatalf("unknown format %q, expected text or json", opts.Format)
+			}
+			old, err := open(args[0], opts.OldSymbols)
+			if err != nil {
+				log.Fatal(err)
+			}
+			new, err := open(args[1], opts.NewSymbols)
+			if err != nil {
+				log.Fatal(err)
+			}
+			diffs := diff.Compare(old, new)
+			if !opts.All {
+				diffs = diff.Changes(diffs)
+			}
+			if opts.Format == "json" {
+				err = writeJSON(os.Stdout, diffs, old.Symbols, new.Symbols)
+			} else {
+				err = writeText(os.Stdout, args, diffs, old.Symbols, new.Symbols)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&opts.OldSymbols, "old-symbols", "", "read additional symbols for the old file from an ECOFF, .SYM or map file")
+	cmd.PersistentFlags().StringVar(&opts.NewSymbols, "new-symbols", "", "read additional symbols for the new file from an ECOFF, .SYM or map file")
+	cmd.PersistentFlags().BoolVarP(&opts.Disassemble, "disassemble", "d", false, "show the instructions that differ in resized and changed functions")
+	cmd.PersistentFlags().BoolVarP(&opts.All, "all", "a", false, "list unchanged functions as well")
+	cmd.PersistentFlags().IntVarP(&opts.Context, "context", "U", 3, "number of unchanged instructions shown around those that differ")
+	cmd.PersistentFlags().StringVar(&opts.Format, "format", "text", "output format: text or json")
+	return cmd
+}
+
+// open loads the named file, adding the symbols read from the file named by
+// symbols, if any.
+func open(name, symbols string) (*binutils.Image, error) {
+	img, err := binutils.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if symbols != "" {
+		s, err := binutils.LoadSymbols(symbols)
+		if err != nil {
+			return nil, err
+		}
+		img.AddSymbols(s)
+	}
+	if _, ok := img.Symbols[img.Entry]; !ok && img.Format == format.PSXEXE {
+		img.Symbols[img.Entry] = "entry"
+	}
+	return img, nil
+}
+
+// location describes where the function is in each build.
+func location(d *diff.Function) string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("%08x size 0x%x", d.New.Addr, d.New.Size)
+	case d.New == nil:
+		return fmt.Sprintf("%08x size 0x%x", d.Old.Addr, d.Old.Size)
+	}
+	s := fmt.Sprintf("%08x", d.Old.Addr)
+	if d.New.Addr != d.Old.Addr {
+		s += fmt.Sprintf(" -> %08x", d.New.Addr)
+	}
+	s += fmt.Sprintf(" size 0x%x", d.Old.Size)
+	if d.New.Size != d.Old.Size {
+		s += fmt.Sprintf(" -> 0x%x", d.New.Size)
+	}
+	return s
+}
+
+// writeText writes a line for each function, followed by a summary. With -d,
+// the instructions that differ are shown in the style of a unified diff,
+// addressed in both builds.
+func writeText(w io.Writer, names []string, diffs []*diff.Function, old, new disasm.Symbols) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %s\n+++ %s\n", names[0], names[1])
+	for _, d := range diffs {
+		fmt.Fprintf(bw, "%-10s%-32s %s\n", d.Status, d.Name, location(d))
+	}
+	if opts.Disassemble {
+		for _, d := range diffs {
+			if len(d.Lines) > 0 {
+				writeLines(bw, d, old, new)
+			}
+		}
+	}
+	counts := diff.Count(diffs)
+	fmt.Fprintf(bw, "\n%d added, %d removed, %d resized, %d changed, %d moved\n",
+		counts[diff.Added], counts[diff.Removed], counts[diff.Resized], counts[diff.Changed], counts[diff.Moved])
+	return bw.Flush()
+}
+
+// writeLines writes the lines of d that differ, with --context unchanged
+// lines around them.
+func writeLines(w io.Writer, d *diff.Function, old, new disasm.Symbols) {
+	po, pn := printers(d, old, new)
+	fmt.Fprintf(w, "\n@@ %s @@\n", d.Name)
+	last := -1
+	for i, l := range d.Lines {
+		if !nearChange(d.Lines, i) {
+			continue
+		}
+		if last >= 0 && i > last+1 {
+			fmt.Fprint(w, "  ...\n")
+		}
+		last = i
+		switch l.Op {
+		case diff.Equal:
+			text, _ := pn.Format(l.New)
+			fmt.Fprintf(w, " %08x %08x  %s\n", l.Old.Addr, l.New.Addr, text)
+		case diff.Delete:
+			text, _ := po.Format(l.Old)
+			fmt.Fprintf(w, "-%08x %8s  %s\n", l.Old.Addr, "", text)
+		case diff.Insert:
+			text, _ := pn.Format(l.New)
+			fmt.Fprintf(w, "+%8s %08x  %s\n", "", l.New.Addr, text)
+		}
+	}
+}
+
+// nearChange reports whether the line at i differs, or is within --context
+// lines of one that does.
+func nearChange(lines []diff.Line, i int) bool {
+	for j := i - opts.Context; j <= i+opts.Context; j++ {
+		if j >= 0 && j < len(lines) && lines[j].Op != diff.Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// printers returns printers for the old and new instructions of d.
+func printers(d *diff.Function, old, new disasm.Symbols) (*disasm.Printer, *disasm.Printer) {
+	var x, y []*disasm.Inst
+	for _, l := range d.Lines {
+		if l.Old != nil {
+			x = append(x, l.Old)
+		}
+		if l.New != nil {
+			y = append(y, l.New)
+		}
+	}
+	return disasm.NewPrinter(x, old), disasm.NewPrinter(y, new)
+}
+
+// A jsonFunction is the difference between the builds of a function.
+type jsonFunction struct {
+	Name    string      `json:"name"`
+	Status  diff.Status `json:"status"`
+	OldAddr *uint32     `json:"old_addr,omitempty"`
+	OldSize *uint32     `json:"old_size,omitempty"`
+	NewAddr *uint32     `json:"new_addr,omitempty"`
+	NewSize *uint32     `json:"new_size,omitempty"`
+	Lines   []*jsonLine `json:"lines,omitempty"`
+}
+
+// A jsonLine is an instruction of the old build (-), the new build (+) or
+// both.
+type jsonLine struct {
+	Op      string  `json:"op"`
+	OldAddr *uint32 `json:"old_addr,omitempty"`
+	NewAddr *uint32 `json:"new_addr,omitempty"`
+	Text    string  `json:"text"`
+}
+
+// writeJSON writes the functions as a JSON array, including the lines of
+// their difference with -d.
+func writeJSON(w io.Writer, diffs []*diff.Function, old, new disasm.Symbols) error {
+	out := make([]*jsonFunction, 0, len(diffs))
+	for _, d := range diffs {
+		jf := &jsonFunction{Name: d.Name, Status: d.Status}
+		if d.Old != nil {
+			jf.OldAddr, jf.OldSize = &d.Old.Addr, &d.Old.Size
+		}
+		if d.New != nil {
+			jf.NewAddr, jf.NewSize = &d.New.Addr, &d.New.Size
+		}
+		if opts.Disassemble && len(d.Lines) > 0 {
+			po, pn := printers(d, old, new)
+			for _, l := range d.Lines {
+				jl := &jsonLine{}
+				switch l.Op {
+				case diff.Equal:
+					jl.Op = " "
+					jl.OldAddr, jl.NewAddr = &l.Old.Addr, &l.New.Addr
+					jl.Text, _ = pn.Format(l.New)
+				case diff.Delete:
+					jl.Op = "-"
+					jl.OldAddr = &l.Old.Addr
+					jl.Text, _ = po.Format(l.Old)
+				case diff.Insert:
+					jl.Op = "+"
+					jl.NewAddr = &l.New.Addr
+					jl.Text, _ = pn.Format(l.New)
+				}
+				jf.Lines = append(jf.Lines, jl)
+			}
+		}
+		out = append(out, jf)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func main() {
+	if err := NewPsxdiffCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+// An action is the result of feeding a key to the lineEditor.
+type action int
+
+const (
+	actionNone action = iota
+	actionSubmit
+	actionInterrupt
+	actionEOF
+)
+
+// lineEditor implements a minimal line editor for a terminal in raw mode,
+// supporting cursor movement, the usual readline control keys and history.
+type lineEditor struct {
+	buf     []byte
+	pos     int
+	history []string
+	hpos    int
+	esc     []byte
+}
+
+// Feed processes a single byte of keyboard input. When the returned action is
+// actionSubmit, the completed line is also returned.
+func (e *lineEditor) Feed(b byte) (action, string) {
+	if len(e.esc) > 0 {
+		e.escape(b)
+		return actionNone, ""
+	}
+	switch b {
+	case 0x03: // Ctrl-C
+		e.reset()
+		return actionInterrupt, ""
+	case 0x04: // Ctrl-D
+		if len(e.buf) == 0 {
+			return actionEOF, ""
+		}
+		e.delete()
+	case '\r', '\n':
+		line := string(e.buf)
+		if line != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+			e.history = append(e.history, line)
+		}
+		e.reset()
+		return actionSubmit, line
+	case 0x7f, 0x08: // Backspace
+		if e.pos > 0 {
+			e.pos--
+			e.delete()
+		}
+	case 0x01: // Ctrl-A
+		e.pos = 0
+	case 0x05: // Ctrl-E
+		e.pos = len(e.buf)
+	case 0x02: // Ctrl-B
+		e.left()
+	case 0x06: // Ctrl-F
+		e.right()
+	case 0x0b: // Ctrl-K
+		e.buf = e.buf[:e.pos]
+	case 0x15: // Ctrl-U
+		e.buf = append(e.buf[:0], e.buf[e.pos:]...)
+		e.pos = 0
+	case 0x10: // Ctrl-P
+		e.prev()
+	case 0x0e: // Ctrl-N
+		e.next()
+	case 0x1b:
+		e.esc = append(e.esc, b)
+	default:
+		if b >= 0x20 && b < 0x7f {
+			e.buf = append(e.buf, 0)
+			copy(e.buf[e.pos+1:], e.buf[e.pos:])
+			e.buf[e.pos] = b
+			e.pos++
+		}
+	}
+	return actionNone, ""
+}
+
+// escape handles ANSI escape sequences for the arrow, home, end and delete
+// keys.
+func (e *lineEditor) escape(b byte) {
+	e.esc = append(e.esc, b)
+	if len(e.esc) == 2 {
+		if b != '[' && b != 'O' {
+			e.esc = e.esc[:0]
+		}
+		return
+	}
+	if b >= '0' && b <= '9' {
+		return
+	}
+	switch string(e.esc[2:]) {
+	case "A":
+		e.prev()
+	case "B":
+		e.next()
+	case "C":
+		e.right()
+	case "D":
+		e.left()
+	case "H", "1~":
+		e.pos = 0
+	case "F", "4~":
+		e.pos = len(e.buf)
+	case "3~":
+		e.delete()
+	}
+	e.esc = e.esc[:0]
+}
+
+func (e *lineEditor) Line() string {
+	return string(e.buf)
+}
+
+// Cursor returns the position of the cursor within the line.
+func (e *lineEditor) Cursor() int {
+	return e.pos
+}
+
+func (e *lineEditor) reset() {
+	e.buf = e.buf[:0]
+	e.pos = 0
+	e.hpos = len(e.history)
+}
+
+func (e *lineEditor) left() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEditor) right() {
+	if e.pos < len(e.buf) {
+		e.pos++
+	}
+}
+
+func (e *lineEditor) delete() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+func (e *lineEditor) prev() {
+	if e.hpos > 0 {
+		e.hpos--
+		e.set(e.history[e.hpos])
+	}
+}
+
+func (e *lineEditor) next() {
+	if e.hpos < len(e.history) {
+		e.hpos++
+	}
+	if e.hpos == len(e.history) {
+		e.set("")
+		return
+	}
+	e.set(e.history[e.hpos])
+}
+
+func (e *lineEditor) set(s string) {
+	e.buf = append(e.buf[:0], s...)
+	e.pos = len(e.buf)
+}
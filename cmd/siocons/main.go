@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze"
+	"github.com/spf13/cobra"
+)
+
+// pollInterval is how long to wait for output from the console before
+// checking for keyboard input.
+const pollInterval = 10 * time.Millisecond
+
+// maxTail limits how much of the current output line is kept for redrawing
+// the line being edited.
+const maxTail = 256
+
+const help = `local commands:
+  :load <file>  upload an ECOFF or PSX-EXE executable
+  :go           execute the uploaded executable
+  :reset        interrupt the console and return to the monitor prompt
+  :baud <rate>  switch the console to a different baud rate
+  :help         show this help
+  :quit         exit siocons (also Ctrl-D)
+`
+
+type sioConsOpts struct {
+	BaudRate   int
+	DeviceName string
+	Serve      string
+	Timeout    time.Duration
+	Transcript string
+}
+
+func NewSIOConsCommand() *cobra.Command {
+	o := &sioConsOpts{}
+	cmd := &cobra.Command{
+		Use:   "siocons [flags] [file]",
+		Short: "Interactive terminal for the Net Yaroze monitor",
+		Long: `Interactive terminal for the Net Yaroze monitor.
+
+Lines are edited locally and sent to the console when enter is pressed, and
+Ctrl-C is forwarded to the console. If a file is provided it is uploaded and
+executed before the terminal is attached. With --serve, file requests made by
+the running program are answered from the given directory.
+
+` + help,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := run(o, args); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cmd.Flags().IntVarP(&o.BaudRate, "baud", "b", 0, "baud rate, detected if not set")
+	cmd.Flags().StringVarP(&o.DeviceName, "device-name", "d", "", "serial device name (e.g. /dev/ttyUSB0), or tcp://host:port for a console shared with sioserve")
+	cmd.Flags().StringVar(&o.Serve, "serve", "", "answer file requests from the running program using this directory")
+	cmd.Flags().DurationVar(&o.Timeout, "timeout", yaroze.DefaultTimeout, "time to wait for each response from the console")
+	cmd.Flags().StringVar(&o.Transcript, "transcript", "", "record all traffic with the console to this file")
+	return cmd
+}
+
+func run(o *sioConsOpts, args []string) error {
+	if o.DeviceName == "" {
+		name, rate, err := yaroze.FindDevice(o.BaudRate)
+		if err != nil {
+			return err
+		}
+		o.DeviceName, o.BaudRate = name, rate
+	}
+	var transcript io.Writer
+	if o.Transcript != "" {
+		f, err := os.Create(o.Transcript)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		transcript = f
+	}
+	p, err := yaroze.OpenPort(&yaroze.PortConfig{
+		BaudRate:   o.BaudRate,
+		DeviceName: o.DeviceName,
+		Timeout:    o.Timeout,
+		Transcript: transcript,
+	})
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	c := &console{port: p, out: os.Stdout}
+	if o.Serve != "" {
+		fs, err := yaroze.NewFileServer(o.Serve)
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+		c.files = fs
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// the keyboard is read until the session ends and, where the read can
+	// be interrupted, stops before the terminal is restored so nothing
+	// typed afterwards is taken. Elsewhere, waiting would hang until a key
+	// is pressed, so the reader is left behind.
+	done := make(chan struct{})
+	keys := readKeys(os.Stdin, done)
+	defer func() {
+		close(done)
+		if canWaitInput {
+			for range keys {
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	if len(args) > 0 {
+		if err := c.command(ctx, "load "+args[0]); err != nil {
+			return err
+		}
+		if err := c.command(ctx, "go"); err != nil {
+			return err
+		}
+	}
+	return c.Run(ctx, keys)
+}
+
+// readKeys delivers keyboard input from f until it is closed or done is.
+func readKeys(f *os.File, done <-chan struct{}) <-chan []byte {
+	keys := make(chan []byte)
+	go func() {
+		defer close(keys)
+		for {
+			ok, err := waitInput(int(f.Fd()), done)
+			if !ok || err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			n, err := f.Read(buf)
+			if n > 0 {
+				select {
+				case keys <- buf[:n]:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return keys
+}
+
+// errQuit is returned by local commands to exit the terminal.
+var errQuit = fmt.Errorf("quit")
+
+// A console connects the terminal to the Net Yaroze monitor, drawing the line
+// being edited after any partial line of output received from the console.
+type console struct {
+	port   *yaroze.Port
+	files  *yaroze.FileServer
+	out    io.Writer
+	tail   []byte
+	editor lineEditor
+}
+
+// Run streams output from the console to the terminal and handles keyboard
+// input until the user quits or keys is closed.
+func (c *console) Run(ctx context.Context, keys <-chan []byte) error {
+	for {
+		rctx, cancel := context.WithTimeout(ctx, pollInterval)
+		data, err := c.recv(rctx)
+		cancel()
+		if err != nil && !yaroze.IsTimeout(err) {
+			return err
+		}
+		if len(data) > 0 {
+			c.output(data)
+		}
+		for pending := true; pending; {
+			select {
+			case buf, ok := <-keys:
+				if !ok {
+					return nil
+				}
+				for _, b := range buf {
+					if err := c.key(ctx, b); err != nil {
+						if err == errQuit {
+							fmt.Fprint(c.out, "\r\n")
+							return nil
+						}
+						return err
+					}
+				}
+			default:
+				pending = false
+			}
+		}
+	}
+}
+
+// recv returns output from the console, answering any file requests when
+// serving files.
+func (c *console) recv(ctx context.Context) ([]byte, error) {
+	if c.files != nil {
+		return c.files.Recv(ctx, c.port)
+	}
+	return c.port.Recv(ctx)
+}
+
+func (c *console) key(ctx context.Context, b byte) error {
+	act, line := c.editor.Feed(b)
+	switch act {
+	case actionInterrupt:
+		c.redraw()
+		return c.port.Clear(ctx)
+	case actionEOF:
+		return errQuit
+	case actionSubmit:
+		c.clearLine()
+		if strings.HasPrefix(line, ":") {
+			if err := c.command(ctx, line[1:]); err != nil {
+				if err == errQuit {
+					return err
+				}
+				c.printf("error: %v", err)
+			}
+			return nil
+		}
+		return c.port.Write(ctx, []byte(line+"\r"))
+	}
+	c.redraw()
+	return nil
+}
+
+// command runs a local command.
+func (c *console) command(ctx context.Context, line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "load", "l":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: :load <file>")
+		}
+		c.printf("loading %s", args[1])
+		start := time.Now()
+		if err := c.port.LoadFile(ctx, args[1]); err != nil {
+			return err
+		}
+		c.printf("loaded %s in %s", args[1], time.Since(start).Round(time.Millisecond))
+		c.tail = []byte(yaroze.Prompt)
+		c.redraw()
+	case "go", "g":
+		c.tail = c.tail[:0]
+		return c.port.Go(ctx)
+	case "reset", "r":
+		if err := c.port.Clear(ctx); err != nil {
+			return err
+		}
+		if err := c.port.ClearScreen(ctx); err != nil {
+			return err
+		}
+		c.tail = []byte(yaroze.Prompt)
+		c.redraw()
+	case "baud", "b":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: :baud <rate>")
+		}
+		rate, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid baud rate %q", args[1])
+		}
+		if err := c.port.SetBaudRate(ctx, rate); err != nil {
+			return err
+		}
+		c.printf("switched to %d baud", rate)
+		c.tail = []byte(yaroze.Prompt)
+		c.redraw()
+	case "help", "h":
+		for _, s := range strings.Split(strings.TrimSpace(help), "\n") {
+			c.printf("%s", s)
+		}
+	case "quit", "q":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command %q, see :help", args[0])
+	}
+	return nil
+}
+
+// output writes data received from the console, keeping the line being edited
+// after it.
+func (c *console) output(data []byte) {
+	if len(c.editor.Line()) == 0 {
+		c.out.Write(data)
+	} else {
+		fmt.Fprintf(c.out, "\r\x1b[K%s%s", c.tail, data)
+	}
+	c.tail = append(c.tail, data...)
+	if i := bytes.LastIndexAny(c.tail, "\r\n"); i >= 0 {
+		c.tail = c.tail[i+1:]
+	}
+	if len(c.tail) > maxTail {
+		c.tail = c.tail[len(c.tail)-maxTail:]
+	}
+	if len(c.editor.Line()) > 0 {
+		c.drawLine()
+	}
+}
+
+// printf writes a message from siocons itself on its own line.
+func (c *console) printf(format string, args ...interface{}) {
+	fmt.Fprintf(c.out, "\r\x1b[K%s\r\n", fmt.Sprintf(format, args...))
+	c.redraw()
+}
+
+func (c *console) clearLine() {
+	fmt.Fprintf(c.out, "\r\x1b[K%s", c.tail)
+}
+
+func (c *console) redraw() {
+	c.clearLine()
+	c.drawLine()
+}
+
+func (c *console) drawLine() {
+	line := c.editor.Line()
+	c.out.Write([]byte(line))
+	if n := len(line) - c.editor.Cursor(); n > 0 {
+		fmt.Fprintf(c.out, "\x1b[%dD", n)
+	}
+}
+
+func main() {
+	if err := NewSIOConsCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
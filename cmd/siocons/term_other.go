@@ -0,0 +1,27 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// makeRaw is not supported on this platform, so the terminal is left in line
+// mode. Lines are still sent to the console, but editing is handled by the
+// terminal and Ctrl-C will exit instead of being forwarded.
+func makeRaw(fd int) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// canWaitInput reports whether waitInput returns when done is closed, even
+// if no key is pressed.
+const canWaitInput = false
+
+// waitInput can't wait for input on this platform, so it reports that there is
+// some straight away, unless done is closed. Reading the keyboard then blocks
+// until a key is pressed, even after the session ends.
+func waitInput(fd int, done <-chan struct{}) (bool, error) {
+	select {
+	case <-done:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
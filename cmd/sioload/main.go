@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
 
-	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format"
 	"github.com/ChrisRx/psxsdk/pkg/yaroze"
 	"github.com/spf13/cobra"
-	"go.bug.st/serial"
 )
 
 type sioLoadOpts struct {
@@ -16,65 +22,226 @@ type sioLoadOpts struct {
 	DeviceName string
 	Exec       bool
 	Stdout     bool
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	Progress   bool
+	Serve      string
+	Data       []string
+	Manifest   string
+	Transcript string
+	List       bool
+	UploadBaud int
 }
 
 func NewSIOLoadCommand() *cobra.Command {
 	o := &sioLoadOpts{}
 	cmd := &cobra.Command{
-		Use:  "sioload [flags] <file>",
-		Args: cobra.ExactArgs(1),
+		Use: "sioload [flags] <file>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if o.List {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
+			if o.List {
+				if err := listDevices(os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt)
+			go func() {
+				<-sig
+				cancel()
+			}()
 
-			f, err := ecoff.Open(args[0])
+			if _, err := format.DetectFile(args[0]); err != nil {
+				log.Fatal(err)
+			}
+			files, err := dataFiles(o)
 			if err != nil {
 				log.Fatal(err)
 			}
+			if err := checkOverlap(args[0], files); err != nil {
+				log.Fatal(err)
+			}
+			var fs *yaroze.FileServer
+			if o.Serve != "" {
+				var err error
+				fs, err = yaroze.NewFileServer(o.Serve)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer fs.Close()
+			}
 			w := ioutil.Discard
 			if o.Stdout {
 				w = os.Stdout
 			}
 			if o.DeviceName == "" {
-				ports, err := serial.GetPortsList()
+				name, rate, err := yaroze.FindDevice(o.BaudRate)
 				if err != nil {
 					log.Fatal(err)
 				}
-				if len(ports) == 0 {
-					log.Fatal("cannot find serial devices")
+				o.DeviceName, o.BaudRate = name, rate
+			}
+			var transcript io.Writer
+			if o.Transcript != "" {
+				f, err := os.Create(o.Transcript)
+				if err != nil {
+					log.Fatal(err)
 				}
-				o.DeviceName = ports[0]
+				defer f.Close()
+				transcript = f
+			}
+			var bar *progressBar
+			var progress func(yaroze.Progress)
+			if o.Progress {
+				bar = newProgressBar(os.Stderr)
+				progress = bar.Update
 			}
 			c, err := yaroze.OpenPort(&yaroze.PortConfig{
-				BaudRate:   o.BaudRate,
-				DeviceName: o.DeviceName,
-				Output:     w,
+				BaudRate:     o.BaudRate,
+				DeviceName:   o.DeviceName,
+				Output:       w,
+				Timeout:      o.Timeout,
+				Retries:      o.Retries,
+				RetryBackoff: o.Backoff,
+				Progress:     progress,
+				Transcript:   transcript,
 			})
 			if err != nil {
 				log.Fatal(err)
 			}
 			defer c.Close()
 
-			if err := c.ClearScreen(); err != nil {
+			if err := c.ClearScreen(ctx); err != nil {
 				log.Fatal(err)
 			}
 
-			if err := c.Load(f); err != nil {
+			load := func() error {
+				if err := c.LoadFile(ctx, args[0]); err != nil {
+					return err
+				}
+				return c.LoadData(ctx, files)
+			}
+			if o.UploadBaud != 0 {
+				err = c.WithBaudRate(ctx, o.UploadBaud, load)
+			} else {
+				err = load()
+			}
+			if err != nil {
 				log.Fatal(err)
 			}
+			if bar != nil {
+				log.Print(bar.Summary())
+			}
 
 			if o.Exec {
-				if err := c.Go(); err != nil {
+				if err := c.Go(ctx); err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			if fs != nil {
+				log.Printf("serving files from %s, press Ctrl-C to stop", o.Serve)
+				if err := fs.Serve(ctx, c, os.Stdout); err != nil && ctx.Err() == nil {
 					log.Fatal(err)
 				}
 			}
 		},
 	}
-	cmd.Flags().IntVarP(&o.BaudRate, "baud", "b", 115200, "baud rate")
-	cmd.Flags().StringVarP(&o.DeviceName, "device-name", "d", "", "serial device name (e.g. /dev/ttyUSB0)")
+	cmd.Flags().IntVarP(&o.BaudRate, "baud", "b", 0, "baud rate, detected if not set")
+	cmd.Flags().StringArrayVar(&o.Data, "data", nil, "upload a data file to an address after the executable, as file@0x80090000 (repeatable)")
+	cmd.Flags().StringVarP(&o.DeviceName, "device-name", "d", "", "serial device name (e.g. /dev/ttyUSB0), or tcp://host:port for a console shared with sioserve")
 	cmd.Flags().BoolVar(&o.Exec, "exec", false, "execute uploaded file")
+	cmd.Flags().BoolVar(&o.List, "list", false, "list serial devices, in the order they are searched for the console")
+	cmd.Flags().StringVar(&o.Manifest, "manifest", "", "upload the data files listed in a manifest, one file and address per line")
+	cmd.Flags().BoolVar(&o.Progress, "progress", true, "show upload progress")
+	cmd.Flags().StringVar(&o.Serve, "serve", "", "answer file requests from the running program using this directory until interrupted")
 	cmd.Flags().BoolVar(&o.Stdout, "stdout", false, "output response to stdout")
+	cmd.Flags().IntVar(&o.UploadBaud, "upload-baud", 0, "switch the console to this baud rate while uploading")
+	cmd.Flags().StringVar(&o.Transcript, "transcript", "", "record all traffic with the console to this file")
+	cmd.Flags().DurationVar(&o.Timeout, "timeout", yaroze.DefaultTimeout, "time to wait for each response from the console")
+	cmd.Flags().IntVar(&o.Retries, "retries", 3, "number of times to retransmit a rejected block")
+	cmd.Flags().DurationVar(&o.Backoff, "retry-backoff", 100*time.Millisecond, "delay before retransmitting a rejected block (doubled for each attempt)")
 	return cmd
 }
 
+// listDevices prints the serial devices of the system, marking the device
+// last found to be connected to the console.
+func listDevices(w io.Writer) error {
+	devices, err := yaroze.ListDevices()
+	if err != nil {
+		return err
+	}
+	cfg, err := yaroze.ReadConfig()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tUSB ID\tADAPTER\tSERIAL")
+	for _, d := range devices {
+		id, adapter := "-", "-"
+		if d.USB {
+			id = strings.ToLower(d.VID + ":" + d.PID)
+		}
+		if d.Adapter != "" {
+			adapter = d.Adapter
+		}
+		name := d.Name
+		if name == cfg.Device {
+			name += " (last used)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, id, adapter, d.SerialNumber)
+	}
+	return tw.Flush()
+}
+
+// dataFiles returns the data files listed in the manifest followed by those
+// given with --data.
+func dataFiles(o *sioLoadOpts) ([]*yaroze.DataFile, error) {
+	var files []*yaroze.DataFile
+	if o.Manifest != "" {
+		var err error
+		files, err = yaroze.ReadManifest(o.Manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range o.Data {
+		f, err := yaroze.ParseDataFile(s)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// checkOverlap ensures that none of the data files overwrite the executable or
+// each other.
+func checkOverlap(name string, files []*yaroze.DataFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	regions, err := yaroze.ExecutableRegions(name)
+	if err != nil {
+		return err
+	}
+	data, err := yaroze.DataRegions(files)
+	if err != nil {
+		return err
+	}
+	return yaroze.CheckOverlap(append(regions, data...))
+}
+
 func main() {
 	if err := NewSIOLoadCommand().Execute(); err != nil {
 		log.Fatal(err)
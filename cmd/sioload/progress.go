@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze"
+)
+
+const progressWidth = 40
+
+// progressBar renders the progress of each transfer on a single line and
+// keeps the totals needed for the summary once the upload is complete.
+type progressBar struct {
+	w       io.Writer
+	start   time.Time
+	sent    int
+	retries int
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w, start: time.Now()}
+}
+
+func (b *progressBar) Update(p yaroze.Progress) {
+	name := p.Section
+	if name == "" {
+		name = fmt.Sprintf("%08x", p.Addr)
+	}
+	frac := float64(p.Sent) / float64(p.Total)
+	n := int(frac * progressWidth)
+	fmt.Fprintf(b.w, "\r%-8s [%s%s] %3.0f%% block %d/%d %7.1f KiB/s",
+		name,
+		strings.Repeat("#", n),
+		strings.Repeat(" ", progressWidth-n),
+		frac*100,
+		p.Block+1,
+		p.Blocks,
+		kibPerSecond(p),
+	)
+	if p.Retries > 0 {
+		fmt.Fprintf(b.w, " (%d retries)", p.Retries)
+	}
+	if p.Done() {
+		fmt.Fprint(b.w, "\n")
+		b.sent += p.Total
+		b.retries += p.Retries
+	}
+}
+
+func (b *progressBar) Summary() string {
+	total := yaroze.Progress{Sent: b.sent, Total: b.sent, Elapsed: time.Since(b.start)}
+	return fmt.Sprintf("sent %d bytes in %s (%.1f KiB/s, effective baud %.0f, %d retries)",
+		b.sent,
+		total.Elapsed.Round(time.Millisecond),
+		kibPerSecond(total),
+		total.BaudRate(),
+		b.retries,
+	)
+}
+
+// kibPerSecond converts the effective baud rate of a transfer back into the
+// payload bytes sent per second, which is zero before any time has elapsed.
+func kibPerSecond(p yaroze.Progress) float64 {
+	return p.BaudRate() / 10 / 1024
+}
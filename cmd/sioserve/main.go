@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze"
+	"github.com/spf13/cobra"
+	"go.bug.st/serial"
+)
+
+type sioServeOpts struct {
+	BaudRate   int
+	DeviceName string
+	Listen     string
+}
+
+func NewSIOServeCommand() *cobra.Command {
+	o := &sioServeOpts{}
+	cmd := &cobra.Command{
+		Use:   "sioserve [flags]",
+		Short: "Share a serial port over TCP",
+		Long: `Share a serial port over TCP.
+
+The console can then be used from another machine by passing a device name of
+tcp://host:port to sioload or siocons. Only one client is connected at a time.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if o.DeviceName == "" {
+				name, rate, err := yaroze.FindDevice(o.BaudRate)
+				if err != nil {
+					log.Fatal(err)
+				}
+				o.DeviceName, o.BaudRate = name, rate
+			}
+			if o.BaudRate == 0 {
+				rate, err := yaroze.DetectBaudRate(o.DeviceName)
+				if err != nil {
+					log.Fatal(err)
+				}
+				o.BaudRate = rate
+			}
+			port, err := serial.Open(o.DeviceName, &serial.Mode{BaudRate: o.BaudRate})
+			if err != nil {
+				log.Fatal(err)
+			}
+			s := yaroze.NewServer(port)
+			s.Log = log.New(os.Stderr, "", log.LstdFlags)
+			defer s.Close()
+
+			l, err := net.Listen("tcp", o.Listen)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("sharing %s at %d baud on %s", o.DeviceName, o.BaudRate, l.Addr())
+			if err := s.Serve(l); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cmd.Flags().IntVarP(&o.BaudRate, "baud", "b", 0, "baud rate, detected if not set")
+	cmd.Flags().StringVarP(&o.DeviceName, "device-name", "d", "", "serial device name (e.g. /dev/ttyUSB0)")
+	cmd.Flags().StringVarP(&o.Listen, "listen", "l", ":3050", "address to listen on")
+	return cmd
+}
+
+func main() {
+	if err := NewSIOServeCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	Totals bool
+}
+
+func NewSizeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "size [flags] <file>...",
+		Short: "List the segment sizes of ECOFF files, archives and PSX-EXE executables",
+		Long: `List the segment sizes of ECOFF files, archives and PSX-EXE executables.
+
+The sizes of text, data and bss are listed for each file, or for each member
+of an archive, in the Berkeley format of GNU size: read-only data is counted
+as text.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+			defer w.Flush()
+			fmt.Fprintln(w, "text\tdata\tbss\tdec\thex\t filename")
+			var total binutils.Sizes
+			for _, name := range args {
+				objects, err := binutils.OpenObjects(name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				for _, o := range objects {
+					var sizes binutils.Sizes
+					if o.PSX != nil {
+						sizes = binutils.PSXSizes(o.PSX)
+					} else {
+						sizes = binutils.ECOFFSizes(o.ECOFF)
+					}
+					o.Close()
+					filename := o.Name
+					if o.Member != "" {
+						filename = fmt.Sprintf("%s (ex %s)", o.Member, o.Name)
+					}
+					fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%x\t %s\n", sizes.Text, sizes.Data, sizes.BSS, sizes.Total(), sizes.Total(), filename)
+					total.Text += sizes.Text
+					total.Data += sizes.Data
+					total.BSS += sizes.BSS
+				}
+			}
+			if opts.Totals {
+				fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%x\t %s\n", total.Text, total.Data, total.BSS, total.Total(), total.Total(), "(TOTALS)")
+			}
+		},
+	}
+
+	cmd.PersistentFlags().BoolVarP(&opts.Totals, "totals", "t", false, "list the total sizes of all files")
+	return cmd
+}
+
+func main() {
+	if err := NewSizeCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
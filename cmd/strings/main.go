@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	MinLength int
+}
+
+func NewStringsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "strings [flags] <file>...",
+		Short: "List the printable strings in ECOFF files, archives and PSX-EXE executables",
+		Long: `List the printable strings in ECOFF files, archives and PSX-EXE executables.
+
+The sections of each file, or of each member of an archive, are searched for
+runs of printable characters, which are listed with the address they are
+loaded at.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.MinLength < 1 {
+				log.Fatalf("invalid minimum length %d", opts.MinLength)
+			}
+			w := bufio.NewWriter(os.Stdout)
+			defer w.Flush()
+			for _, name := range args {
+				objects, err := binutils.OpenObjects(name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				for _, o := range objects {
+					img, err := o.Image()
+					if err != nil {
+						log.Fatal(err)
+					}
+					o.Close()
+					prefix := ""
+					switch {
+					case o.Member != "":
+						prefix = fmt.Sprintf("%s(%s): ", o.Name, o.Member)
+					case len(args) > 1:
+						prefix = o.Name + ": "
+					}
+					for _, s := range img.Sections {
+						for _, str := range binutils.Strings(s.Data, s.Addr, opts.MinLength) {
+							fmt.Fprintf(w, "%s%08x %s\n", prefix, str.Addr, str.Text)
+						}
+					}
+				}
+			}
+		},
+	}
+
+	cmd.PersistentFlags().IntVarP(&opts.MinLength, "bytes", "n", 4, "minimum length of the strings listed")
+	return cmd
+}
+
+func main() {
+	if err := NewStringsCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
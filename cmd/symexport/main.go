@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/sym"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	Format  string
+	Output  string
+	NoLibps bool
+	Symbols string
+}
+
+func NewSymexportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "symexport [flags] <file>",
+		Short: "Export the symbols of an ECOFF or PSX-EXE file for other debuggers and disassemblers",
+		Long: `Export the symbols of an ECOFF or PSX-EXE file for other debuggers and
+disassemblers.
+
+Procedures are exported as functions, sized by their procedure descriptors and
+noted with the source file they were compiled from, along with global and
+static data. The symbols of the Net Yaroze library, which the program is
+linked against at absolute addresses, are included unless --no-libps is set.
+Since eco2exe doesn't move the program, the symbols also apply to the
+executable it creates.
+
+A PSX-EXE has no symbols of its own, so they are read from the file given
+with --symbols. Ideally this is the ECOFF file the executable was created from,
+which is exported as above. The symbols of a .SYM or map file don't say what
+they name, so only those called by the program are exported as functions,
+without sizes, and the rest as labels.
+
+Symbols are written as a no$psx .SYM file (sym), an IDA script (idc), a Ghidra
+Python script (ghidra) or CSV (csv).`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			symbols, err := load(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var write func(*sym.File, io.Writer) error
+			switch opts.Format {
+			case "sym":
+				write = (*sym.File).Write
+			case "idc":
+				write = (*sym.File).WriteIDC
+			case "ghidra":
+				write = (*sym.File).WriteGhidra
+			case "csv":
+				write = (*sym.File).WriteCSV
+			default:
+				log.Fatalf("unknown format %q, expected sym, idc, ghidra or csv", opts.Format)
+			}
+
+			w := os.Stdout
+			if opts.Output != "" {
+				w, err = os.Create(opts.Output)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer w.Close()
+			}
+			if err := write(symbols, w); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&opts.Format, "format", "f", "sym", "output format: sym, idc, ghidra or csv")
+	cmd.PersistentFlags().StringVarP(&opts.Output, "output", "o", "", "write to this file instead of stdout")
+	cmd.PersistentFlags().BoolVar(&opts.NoLibps, "no-libps", false, "leave out the absolute symbols of the Net Yaroze library")
+	cmd.PersistentFlags().StringVar(&opts.Symbols, "symbols", "", "read the symbols of a PSX-EXE from an ECOFF, .SYM or map file")
+	return cmd
+}
+
+// load returns the symbols to export for the named file.
+func load(name string) (*sym.File, error) {
+	ft, err := format.DetectFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	switch {
+	case ft == format.ECOFF:
+		return loadECOFF(name)
+	case ft != format.PSXEXE:
+		return nil, errors.Errorf("%s: expected an ECOFF or PSX-EXE file, found %s", name, ft)
+	case opts.Symbols == "":
+		return nil, errors.Errorf("%s: a PSX-EXE has no symbols, read them from another file with --symbols", name)
+	}
+	if ft, err := format.DetectFile(opts.Symbols); err == nil && ft == format.ECOFF {
+		return loadECOFF(opts.Symbols)
+	}
+	img, err := binutils.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := binutils.LoadSymbols(opts.Symbols)
+	if err != nil {
+		return nil, err
+	}
+	return binutils.SymbolFile(img, symbols), nil
+}
+
+func loadECOFF(name string) (*sym.File, error) {
+	f, err := ecoff.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return binutils.ECOFFSymbolFile(f, !opts.NoLibps), nil
+}
+
+func main() {
+	if err := NewSymexportCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/xref"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	To      []string
+	From    []string
+	Kinds   []string
+	Symbols string
+	Format  string
+}
+
+func NewXrefCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "xref [flags] <file>",
+		Short: "List the cross-references in the code of ECOFF and PSX-EXE files",
+		Long: `List the cross-references in the code of ECOFF and PSX-EXE files.
+
+Every call, jump to another function, and load, store or address of data that
+can be found in the code is listed, or only those to the symbols or addresses
+given with --to (who calls X) or made by the functions given with --from (what
+does Y touch). A name shared by several symbols, such as static functions in
+different files, is reported as ambiguous, so the address must be given
+instead. Symbols can be read from another file with --symbols, as with
+objdump.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if opts.Format != "text" && opts.Format != "json" {
+				log.Fatalf("unknown format %q, expected text or json", opts.Format)
+			}
+			img, err := binutils.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			if opts.Symbols != "" {
+				symbols, err := binutils.LoadSymbols(opts.Symbols)
+				if err != nil {
+					log.Fatal(err)
+				}
+				img.AddSymbols(symbols)
+			}
+			if _, ok := img.Symbols[img.Entry]; !ok && img.Format == format.PSXEXE {
+				img.Symbols[img.Entry] = "entry"
+			}
+			db := xref.Build(img)
+			refs, err := query(db)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if opts.Format == "json" {
+				err = writeJSON(os.Stdout, db, refs)
+			} else {
+				err = writeText(os.Stdout, db, refs)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.PersistentFlags().StringSliceVar(&opts.To, "to", nil, "only list references to this symbol or address (repeatable)")
+	cmd.PersistentFlags().StringSliceVar(&opts.From, "from", nil, "only list references made by this function (repeatable)")
+	cmd.PersistentFlags().StringSliceVarP(&opts.Kinds, "kind", "k", nil, "only list references of this kind: call, jump, read, write or address (repeatable)")
+	cmd.PersistentFlags().StringVar(&opts.Symbols, "symbols", "", "read additional symbols from an ECOFF, .SYM or map file")
+	cmd.PersistentFlags().StringVar(&opts.Format, "format", "text", "output format: text or json")
+	return cmd
+}
+
+// query returns the references selected by --to, --from and --kind.
+func query(db *xref.Database) ([]*xref.Ref, error) {
+	refs := db.Refs
+	if len(opts.To) > 0 || len(opts.From) > 0 {
+		refs = nil
+		for _, s := range opts.To {
+			addr, err := lookup(db, s)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, db.To(addr)...)
+		}
+		for _, s := range opts.From {
+			addr, err := lookup(db, s)
+			if err != nil {
+				return nil, err
+			}
+			if db.Function(addr) == nil {
+				return nil, errors.Errorf("no function at %s", s)
+			}
+			refs = append(refs, db.From(addr)...)
+		}
+		sort.SliceStable(refs, func(i, j int) bool { return refs[i].From < refs[j].From })
+	}
+	if len(opts.Kinds) == 0 {
+		return refs, nil
+	}
+	kinds := make(map[string]bool)
+	for _, k := range opts.Kinds {
+		kinds[k] = true
+	}
+	var selected []*xref.Ref
+	for _, r := range refs {
+		if kinds[r.Kind.String()] {
+			selected = append(selected, r)
+		}
+	}
+	return selected, nil
+}
+
+// lookup returns the address given as a number or named by a symbol.
+func lookup(db *xref.Database, s string) (uint32, error) {
+	if v, err := strconv.ParseUint(s, 0, 32); err == nil {
+		return uint32(v), nil
+	}
+	return db.Lookup(s)
+}
+
+// describe names addr by its symbol or, within a function, by its offset from
+// the start of the function.
+func describe(db *xref.Database, addr uint32) string {
+	if name, ok := db.Name(addr); ok {
+		return name
+	}
+	if fn := db.Function(addr); fn != nil {
+		return fmt.Sprintf("%s+0x%x", fn.Name, addr-fn.Addr)
+	}
+	return ""
+}
+
+// writeText writes a line for each reference, in the style of objdump.
+func writeText(w io.Writer, db *xref.Database, refs []*xref.Ref) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range refs {
+		to := fmt.Sprintf("%08x", r.To)
+		if name := describe(db, r.To); name != "" {
+			to += " <" + name + ">"
+		}
+		from := fmt.Sprintf("%08x <%s>", r.From, describe(db, r.From))
+		fmt.Fprintf(bw, "%-40s%-8s %s\n", from, r.Kind, to)
+	}
+	return bw.Flush()
+}
+
+// A jsonRef is a reference along with the names of the addresses involved.
+type jsonRef struct {
+	*xref.Ref
+	FuncName string `json:"func_name"`
+	ToName   string `json:"to_name,omitempty"`
+}
+
+// writeJSON writes the references as a JSON array.
+func writeJSON(w io.Writer, db *xref.Database, refs []*xref.Ref) error {
+	out := make([]*jsonRef, 0, len(refs))
+	for _, r := range refs {
+		out = append(out, &jsonRef{
+			Ref:      r,
+			FuncName: describe(db, r.Func),
+			ToName:   describe(db, r.To),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func main() {
+	if err := NewXrefCommand().Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
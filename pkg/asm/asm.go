@@ -0,0 +1,161 @@
+// Package asm assembles MIPS R3000A code for the Playstation, including GTE
+// (COP2) commands, from source written in the syntax printed by the disasm
+// package.
+//
+// Source is a sequence of lines, each holding an optional label followed by
+// an instruction or directive, with comments starting at '#':
+//
+//	loop:	lbu	$a0, 0($s0)
+//		bnez	$a0, loop
+//		addiu	$s0, $s0, 1	# delay slot
+//
+// Instructions are assembled exactly as written: delay slots are never
+// filled and nothing is reordered. The pseudo-instructions nop, move, li, la,
+// b, bal, beqz and bnez are provided, and immediates may be expressions of
+// numbers and symbols, optionally taking the %hi or %lo half of an address.
+// The directives .word, .half, .byte, .ascii, .asciiz, .space, .align and
+// .equ are supported.
+//
+// Symbols that aren't defined by the source are left as relocations, to be
+// resolved with Program.Link.
+package asm
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A RelocationType describes how a symbol's address is stored in code. The
+// values are the same as those used in ECOFF relocation entries.
+type RelocationType int
+
+const (
+	R_REFWORD RelocationType = 2 /* 32-bit address */
+	R_JMPADDR RelocationType = 3 /* 26-bit jump target */
+	R_REFHI   RelocationType = 4 /* high 16 bits, adjusted for R_REFLO */
+	R_REFLO   RelocationType = 5 /* low 16 bits */
+)
+
+func (t RelocationType) String() string {
+	switch t {
+	case R_REFWORD:
+		return "R_REFWORD"
+	case R_JMPADDR:
+		return "R_JMPADDR"
+	case R_REFHI:
+		return "R_REFHI"
+	case R_REFLO:
+		return "R_REFLO"
+	default:
+		return "unknown"
+	}
+}
+
+// A Relocation is a reference to a symbol that wasn't defined when assembling.
+type Relocation struct {
+	// Offset is the offset into Code of the word to be relocated.
+	Offset uint32
+	Type   RelocationType
+	Symbol string
+	Addend int32
+}
+
+// A Program is assembled code.
+type Program struct {
+	// Addr is the address the code is assembled to be loaded at.
+	Addr uint32
+	Code []byte
+
+	// Symbols holds the labels and .equ definitions of the source.
+	Symbols map[string]uint32
+
+	// Relocations are the references to symbols not defined by the source.
+	Relocations []*Relocation
+}
+
+// Link resolves the relocations of the program with symbols, leaving any that
+// are still undefined in Relocations and returning an error naming them.
+func (p *Program) Link(symbols map[string]uint32) error {
+	var remaining []*Relocation
+	undefined := make(map[string]bool)
+	for _, r := range p.Relocations {
+		v, ok := symbols[r.Symbol]
+		if !ok {
+			remaining = append(remaining, r)
+			undefined[r.Symbol] = true
+			continue
+		}
+		word := binary.LittleEndian.Uint32(p.Code[r.Offset:])
+		word, err := relocate(word, r.Type, v+uint32(r.Addend), p.Addr+r.Offset)
+		if err != nil {
+			return errors.Wrapf(err, "cannot relocate %s", r.Symbol)
+		}
+		binary.LittleEndian.PutUint32(p.Code[r.Offset:], word)
+	}
+	p.Relocations = remaining
+	if len(undefined) > 0 {
+		names := make([]string, 0, len(undefined))
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return errors.Errorf("undefined symbols: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// relocate stores v in word, which is at addr, as described by t.
+func relocate(word uint32, t RelocationType, v, addr uint32) (uint32, error) {
+	switch t {
+	case R_REFWORD:
+		return v, nil
+	case R_JMPADDR:
+		if (addr+4)&0xf0000000 != v&0xf0000000 {
+			return 0, errors.Errorf("jump target 0x%08X out of range", v)
+		}
+		return word&^0x03ffffff | v>>2&0x03ffffff, nil
+	case R_REFHI:
+		return word&^0xffff | hi(v), nil
+	case R_REFLO:
+		return word&^0xffff | v&0xffff, nil
+	}
+	return 0, errors.Errorf("unknown relocation type %d", t)
+}
+
+// hi returns the upper half of v, adjusted for the sign extension of the
+// lower half when they are added back together.
+func hi(v uint32) uint32 {
+	return (v + 0x8000) >> 16 & 0xffff
+}
+
+// Assemble assembles src for loading at addr.
+func Assemble(src string, addr uint32) (*Program, error) {
+	a := &assembler{
+		addr:    addr,
+		symbols: make(map[string]uint32),
+	}
+	lines := strings.Split(src, "\n")
+
+	// The first pass sizes each line to find the address of every label,
+	// and the second encodes them.
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.pc = addr
+		a.code = a.code[:0]
+		a.relocations = nil
+		a.defined = make(map[string]bool)
+		for i, line := range lines {
+			if err := a.line(line); err != nil {
+				return nil, errors.Wrapf(err, "line %d", i+1)
+			}
+		}
+	}
+	return &Program{
+		Addr:        addr,
+		Code:        a.code,
+		Symbols:     a.symbols,
+		Relocations: a.relocations,
+	}, nil
+}
@@ -0,0 +1,162 @@
+package asm
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+)
+
+// TestDisassembly assembles every instruction of a Net Yaroze executable, as
+// printed by the disassembler, and checks that it is encoded the same.
+func TestDisassembly(t *testing.T) {
+	f, err := psx.Open(filepath.Join("..", "format", "psx", "testdata", "psx.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := f.Section("text")
+	p := disasm.NewPrinter(nil, nil)
+	failures := 0
+	for _, inst := range disasm.Disassemble(text.Data, text.Addr) {
+		if !inst.Valid() {
+			continue
+		}
+		line, _ := p.Format(inst)
+		prog, err := Assemble(line, inst.Addr)
+		if err != nil {
+			t.Errorf("0x%08x: %s: %v", inst.Addr, line, err)
+		} else if word := binary.LittleEndian.Uint32(prog.Code); word != inst.Word {
+			t.Errorf("0x%08x: %s: expected 0x%08x, received 0x%08x", inst.Addr, line, inst.Word, word)
+		} else {
+			continue
+		}
+		if failures++; failures == 10 {
+			t.Fatal("too many failures")
+		}
+	}
+}
+
+func words(data []byte) []uint32 {
+	w := make([]uint32, len(data)/4)
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return w
+}
+
+func TestAssemble(t *testing.T) {
+	src := `
+	.equ	SIZE, 0x20
+	.set	noreorder
+
+puts:	addiu	$sp, $sp, -24		# entry
+	sw	$ra, 0x14($sp)
+	la	$s0, message
+	li	$a1, SIZE
+	li	$a2, 0x12345678
+loop:	lbu	$a0, 0($s0)
+	beqz	$a0, done
+	addiu	$s0, $s0, 1
+	jal	putchar
+	nop
+	b	loop
+	nop
+done:	lw	$ra, 0x14($sp)
+	jr	$ra
+	addiu	$sp, $sp, 24
+message:
+	.asciiz	"hi # there"
+	.align	2
+	.word	message+4, putchar
+`
+	p, err := Assemble(src, 0x80010000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{
+		0x27bdffe8, // addiu   $sp, $sp, 0xFFE8
+		0xafbf0014, // sw      $ra, 0x14($sp)
+		0x3c108001, // lui     $s0, 0x8001
+		0x26100044, // addiu   $s0, $s0, 0x44
+		0x24050020, // addiu   $a1, $zero, 0x20
+		0x3c061234, // lui     $a2, 0x1234
+		0x34c65678, // ori     $a2, $a2, 0x5678
+		0x92040000, // lbu     $a0, 0($s0)
+		0x10800005, // beq     $a0, $zero, done
+		0x26100001, // addiu   $s0, $s0, 0x1
+		0x0c000000, // jal     putchar
+		0x00000000, // nop
+		0x1000fffa, // beq     $zero, $zero, loop
+		0x00000000, // nop
+		0x8fbf0014, // lw      $ra, 0x14($sp)
+		0x03e00008, // jr      $ra
+		0x27bd0018, // addiu   $sp, $sp, 0x18
+		0x23206968, // "hi # there"
+		0x65687420,
+		0x00006572,
+		0x80010048, // message+4
+		0x00000000, // putchar
+	}
+	got := words(p.Code)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d words, received %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("word %d: expected 0x%08x, received 0x%08x", i, expected[i], got[i])
+		}
+	}
+	if p.Symbols["loop"] != 0x8001001c || p.Symbols["message"] != 0x80010044 {
+		t.Errorf("unexpected symbols %v", p.Symbols)
+	}
+	if len(p.Relocations) != 2 {
+		t.Fatalf("expected 2 relocations, received %d", len(p.Relocations))
+	}
+	if err := p.Link(map[string]uint32{"putchar": 0x80043790}); err != nil {
+		t.Fatal(err)
+	}
+	if w := words(p.Code); w[10] != 0x0c010de4 || w[21] != 0x80043790 {
+		t.Errorf("unexpected relocated words 0x%08x and 0x%08x", w[10], w[21])
+	}
+}
+
+func TestLink(t *testing.T) {
+	p, err := Assemble("la $a0, buffer+0x10\n", 0x80010000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Link(nil); err == nil || err.Error() != "undefined symbols: buffer" {
+		t.Fatalf("expected buffer to be undefined, received %v", err)
+	}
+	if err := p.Link(map[string]uint32{"buffer": 0x80017ff8}); err != nil {
+		t.Fatal(err)
+	}
+	// the lower half is negative, so the upper half is rounded up
+	if w := words(p.Code); w[0] != 0x3c048002 || w[1] != 0x24848008 {
+		t.Fatalf("unexpected words 0x%08x 0x%08x", w[0], w[1])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{"frob $t0", "line 1: unknown instruction frob"},
+		{"addu $t0, $t1", "line 1: addu: expected 3 operands, received 2"},
+		{"addiu $t0, $t1, 0x10000", "line 1: addiu: immediate 0x10000 out of range"},
+		{"lw $t0, 0($q0)", "line 1: lw: invalid register $q0"},
+		{"a:\na: nop", "line 2: symbol a redefined"},
+		{"beq $t0, $t1, elsewhere", "line 1: beq: branch to undefined symbol elsewhere"},
+		{"j 0x90000000", "line 1: j: jump target 0x90000000 out of range"},
+		{"mvmva rt, v3, tr", "line 1: invalid mvmva operand v3"},
+	}
+	for _, c := range cases {
+		_, err := Assemble(c.src, 0x80010000)
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("%q: expected error %q, received %v", c.src, c.expected, err)
+		}
+	}
+}
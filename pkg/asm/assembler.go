@@ -0,0 +1,236 @@
+package asm
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An assembler holds the state of a pass over the source.
+type assembler struct {
+	pass        int
+	addr, pc    uint32
+	code        []byte
+	symbols     map[string]uint32
+	relocations []*Relocation
+
+	// defined holds the symbols defined so far in this pass.
+	defined map[string]bool
+}
+
+// line assembles a single line of source.
+func (a *assembler) line(line string) error {
+	line = strings.TrimSpace(stripComment(line))
+	for {
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !isSymbol(strings.TrimSpace(line[:i])) {
+			break
+		}
+		if err := a.define(strings.TrimSpace(line[:i]), a.pc); err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line[i+1:])
+	}
+	if line == "" {
+		return nil
+	}
+	name, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, rest = line[:i], strings.TrimSpace(line[i:])
+	}
+	name = strings.ToLower(name)
+	args := splitOperands(rest)
+	if strings.HasPrefix(name, ".") {
+		return a.directive(name, args)
+	}
+	return a.instruction(name, args)
+}
+
+// define sets the value of a symbol, which can only be defined once.
+func (a *assembler) define(name string, v uint32) error {
+	a.defined[name] = true
+	if prev, ok := a.symbols[name]; ok {
+		if a.pass == 1 || prev != v {
+			return errors.Errorf("symbol %s redefined", name)
+		}
+		return nil
+	}
+	a.symbols[name] = v
+	return nil
+}
+
+// emit appends words to the code.
+func (a *assembler) emit(words ...uint32) {
+	for _, w := range words {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], w)
+		a.code = append(a.code, b[:]...)
+		a.pc += 4
+	}
+}
+
+// emitBytes appends data to the code.
+func (a *assembler) emitBytes(data []byte) {
+	a.code = append(a.code, data...)
+	a.pc += uint32(len(data))
+}
+
+// relocate records a relocation of the word at pc against an undefined
+// symbol. Only relocations from the final pass are kept.
+func (a *assembler) relocate(pc uint32, t RelocationType, e expr) {
+	a.relocations = append(a.relocations, &Relocation{
+		Offset: pc - a.addr,
+		Type:   t,
+		Symbol: e.symbol,
+		Addend: int32(e.value),
+	})
+}
+
+// directive assembles a directive.
+func (a *assembler) directive(name string, args []string) error {
+	switch name {
+	case ".set":
+		// e.g. .set noreorder, which is always the case
+		return nil
+	case ".equ":
+		if len(args) != 2 || !isSymbol(args[0]) {
+			return errors.New(".equ requires a name and a value")
+		}
+		v, err := a.constant(args[1])
+		if err != nil {
+			return err
+		}
+		return a.define(args[0], v)
+	case ".word":
+		for _, arg := range args {
+			e, err := a.eval(arg)
+			if err != nil {
+				return err
+			}
+			if e.symbol != "" {
+				a.relocate(a.pc, R_REFWORD, e)
+			}
+			a.emit(e.value)
+		}
+		return nil
+	case ".half", ".byte":
+		size := 2
+		if name == ".byte" {
+			size = 1
+		}
+		for _, arg := range args {
+			v, err := a.constant(arg)
+			if err != nil {
+				return err
+			}
+			if int32(v) < -(1<<(8*size-1)) || int32(v) >= 1<<(8*size) {
+				return errors.Errorf("value %s out of range for %s", arg, name)
+			}
+			b := []byte{byte(v), byte(v >> 8)}
+			a.emitBytes(b[:size])
+		}
+		return nil
+	case ".ascii", ".asciiz":
+		for _, arg := range args {
+			s, err := strconv.Unquote(arg)
+			if err != nil {
+				return errors.Errorf("invalid string %s", arg)
+			}
+			a.emitBytes([]byte(s))
+			if name == ".asciiz" {
+				a.emitBytes([]byte{0})
+			}
+		}
+		return nil
+	case ".space":
+		if len(args) != 1 {
+			return errors.New(".space requires a size")
+		}
+		n, err := a.constant(args[0])
+		if err != nil {
+			return err
+		}
+		a.emitBytes(make([]byte, n))
+		return nil
+	case ".align":
+		if len(args) != 1 {
+			return errors.New(".align requires a power of 2")
+		}
+		n, err := a.constant(args[0])
+		if err != nil {
+			return err
+		}
+		if n > 16 {
+			return errors.Errorf("alignment %d too large", n)
+		}
+		align := uint32(1) << n
+		a.emitBytes(make([]byte, (align-a.pc%align)%align))
+		return nil
+	}
+	return errors.Errorf("unknown directive %s", name)
+}
+
+// stripComment removes a comment from line, ignoring '#' in strings.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// splitOperands splits s at commas that aren't in strings or parentheses.
+func splitOperands(s string) []string {
+	var args []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(args) > 0 {
+		args = append(args, rest)
+	}
+	return args
+}
+
+// isSymbol reports whether s is a valid symbol name.
+func isSymbol(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || c == '.' || c == '$':
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// An expr is the value of an expression. If it refers to a symbol that isn't
+// defined, symbol is set and value is the amount added to it.
+type expr struct {
+	value  uint32
+	symbol string
+}
+
+// eval evaluates an expression of numbers and symbols added or subtracted
+// together, e.g. "message+4". At most one undefined symbol may be added.
+// During the first pass undefined symbols may yet be defined as labels, so
+// they are given a placeholder value of zero.
+func (a *assembler) eval(s string) (expr, error) {
+	var e expr
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return e, errors.New("missing expression")
+	}
+	for s != "" {
+		neg := false
+		switch s[0] {
+		case '+':
+			s = strings.TrimSpace(s[1:])
+		case '-':
+			neg = true
+			s = strings.TrimSpace(s[1:])
+		}
+		i := strings.IndexAny(s, "+-")
+		if i == 0 {
+			return e, errors.Errorf("invalid expression")
+		}
+		term := s
+		if i > 0 {
+			term, s = strings.TrimSpace(s[:i]), s[i:]
+		} else {
+			s = ""
+		}
+		v, err := strconv.ParseInt(term, 0, 64)
+		switch {
+		case err == nil:
+			if v < -0x80000000 || v > 0xffffffff {
+				return e, errors.Errorf("value %s out of range", term)
+			}
+		case isSymbol(term):
+			sv, ok := a.symbols[term]
+			if !ok && a.pass > 1 {
+				if neg || e.symbol != "" {
+					return e, errors.Errorf("invalid use of undefined symbol %s", term)
+				}
+				e.symbol = term
+			}
+			v = int64(sv)
+		default:
+			return e, errors.Errorf("invalid expression %q", term)
+		}
+		if neg {
+			v = -v
+		}
+		e.value += uint32(v)
+	}
+	return e, nil
+}
+
+// constant evaluates an expression that can't refer to undefined symbols.
+func (a *assembler) constant(s string) (uint32, error) {
+	e, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if e.symbol != "" {
+		return 0, errors.Errorf("undefined symbol %s", e.symbol)
+	}
+	return e.value, nil
+}
+
+// known reports whether the value of s is known on reaching it in the first
+// pass, as it is made up of numbers and symbols defined on earlier lines.
+func (a *assembler) known(s string) bool {
+	for _, term := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == '-' }) {
+		term = strings.TrimSpace(term)
+		if _, err := strconv.ParseInt(term, 0, 64); err != nil && !a.defined[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// immediate evaluates a 16-bit immediate operand of the instruction at pc,
+// which may be the %hi or %lo half of an expression. Other expressions must
+// fit in 16 bits, either signed or unsigned as the instruction expects.
+func (a *assembler) immediate(s string, pc uint32, signed bool) (uint32, error) {
+	for _, half := range []struct {
+		prefix string
+		t      RelocationType
+	}{{"%hi(", R_REFHI}, {"%lo(", R_REFLO}} {
+		if !strings.HasPrefix(s, half.prefix) || !strings.HasSuffix(s, ")") {
+			continue
+		}
+		e, err := a.eval(s[len(half.prefix) : len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		if e.symbol != "" {
+			a.relocate(pc, half.t, e)
+			return 0, nil
+		}
+		if half.t == R_REFHI {
+			return hi(e.value), nil
+		}
+		return e.value & 0xffff, nil
+	}
+	v, err := a.constant(s)
+	if err != nil {
+		return 0, err
+	}
+	// Immediates are accepted as either signed or unsigned 16-bit values,
+	// since the disassembler prints them unsigned.
+	if int32(v) < -0x8000 || int32(v) > 0xffff || !signed && int32(v) < 0 {
+		return 0, errors.Errorf("immediate %s out of range", s)
+	}
+	return v & 0xffff, nil
+}
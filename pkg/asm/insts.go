@@ -0,0 +1,603 @@
+package asm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/mewmew/mips"
+	"github.com/pkg/errors"
+)
+
+// A format is the syntax of an instruction's operands.
+type format int
+
+const (
+	fmtNone       format = iota // rfe
+	fmtRdRsRt                   // addu rd, rs, rt
+	fmtRdRtSa                   // sll rd, rt, sa
+	fmtRdRtRs                   // sllv rd, rt, rs
+	fmtRsRt                     // mult rs, rt
+	fmtRd                       // mfhi rd
+	fmtRs                       // jr rs
+	fmtJalr                     // jalr [rd,] rs
+	fmtCode                     // syscall [code]
+	fmtRtRsImm                  // addiu rt, rs, imm
+	fmtRtRsUimm                 // ori rt, rs, imm
+	fmtRtImm                    // lui rt, imm
+	fmtRtMem                    // lw rt, offset(rs)
+	fmtRsRtBranch               // beq rs, rt, target
+	fmtRsBranch                 // bgez rs, target
+	fmtJump                     // j target
+	fmtCOP0Move                 // mfc0 rt, cop0 register
+	fmtGTEData                  // mfc2 rt, GTE data register
+	fmtGTEControl               // cfc2 rt, GTE control register
+	fmtGTEMem                   // lwc2 GTE data register, offset(rs)
+	fmtCopBranch                // bc2f target
+)
+
+// An instruction is the encoding of an instruction with its operands zeroed.
+type instruction struct {
+	format
+	enc uint32
+}
+
+// op returns the opcode field of an instruction. Instructions with an opcode
+// of zero (SPECIAL) are selected by the function field instead.
+func op(op uint32) uint32 { return op << 26 }
+
+var instructions = map[string]instruction{
+	"sll":     {fmtRdRtSa, 0x00},
+	"srl":     {fmtRdRtSa, 0x02},
+	"sra":     {fmtRdRtSa, 0x03},
+	"sllv":    {fmtRdRtRs, 0x04},
+	"srlv":    {fmtRdRtRs, 0x06},
+	"srav":    {fmtRdRtRs, 0x07},
+	"jr":      {fmtRs, 0x08},
+	"jalr":    {fmtJalr, 0x09},
+	"syscall": {fmtCode, 0x0c},
+	"break":   {fmtCode, 0x0d},
+	"mfhi":    {fmtRd, 0x10},
+	"mthi":    {fmtRs, 0x11},
+	"mflo":    {fmtRd, 0x12},
+	"mtlo":    {fmtRs, 0x13},
+	"mult":    {fmtRsRt, 0x18},
+	"multu":   {fmtRsRt, 0x19},
+	"div":     {fmtRsRt, 0x1a},
+	"divu":    {fmtRsRt, 0x1b},
+	"add":     {fmtRdRsRt, 0x20},
+	"addu":    {fmtRdRsRt, 0x21},
+	"sub":     {fmtRdRsRt, 0x22},
+	"subu":    {fmtRdRsRt, 0x23},
+	"and":     {fmtRdRsRt, 0x24},
+	"or":      {fmtRdRsRt, 0x25},
+	"xor":     {fmtRdRsRt, 0x26},
+	"nor":     {fmtRdRsRt, 0x27},
+	"slt":     {fmtRdRsRt, 0x2a},
+	"sltu":    {fmtRdRsRt, 0x2b},
+
+	"bltz":   {fmtRsBranch, op(0x01) | 0x00<<16},
+	"bgez":   {fmtRsBranch, op(0x01) | 0x01<<16},
+	"bltzal": {fmtRsBranch, op(0x01) | 0x10<<16},
+	"bgezal": {fmtRsBranch, op(0x01) | 0x11<<16},
+	"j":      {fmtJump, op(0x02)},
+	"jal":    {fmtJump, op(0x03)},
+	"beq":    {fmtRsRtBranch, op(0x04)},
+	"bne":    {fmtRsRtBranch, op(0x05)},
+	"blez":   {fmtRsBranch, op(0x06)},
+	"bgtz":   {fmtRsBranch, op(0x07)},
+	"addi":   {fmtRtRsImm, op(0x08)},
+	"addiu":  {fmtRtRsImm, op(0x09)},
+	"slti":   {fmtRtRsImm, op(0x0a)},
+	"sltiu":  {fmtRtRsImm, op(0x0b)},
+	"andi":   {fmtRtRsUimm, op(0x0c)},
+	"ori":    {fmtRtRsUimm, op(0x0d)},
+	"xori":   {fmtRtRsUimm, op(0x0e)},
+	"lui":    {fmtRtImm, op(0x0f)},
+	"lb":     {fmtRtMem, op(0x20)},
+	"lh":     {fmtRtMem, op(0x21)},
+	"lwl":    {fmtRtMem, op(0x22)},
+	"lw":     {fmtRtMem, op(0x23)},
+	"lbu":    {fmtRtMem, op(0x24)},
+	"lhu":    {fmtRtMem, op(0x25)},
+	"lwr":    {fmtRtMem, op(0x26)},
+	"sb":     {fmtRtMem, op(0x28)},
+	"sh":     {fmtRtMem, op(0x29)},
+	"swl":    {fmtRtMem, op(0x2a)},
+	"sw":     {fmtRtMem, op(0x2b)},
+	"swr":    {fmtRtMem, op(0x2e)},
+
+	"mfc0": {fmtCOP0Move, op(0x10) | 0x00<<21},
+	"mtc0": {fmtCOP0Move, op(0x10) | 0x04<<21},
+	"bc0f": {fmtCopBranch, op(0x10) | 0x08<<21 | 0<<16},
+	"bc0t": {fmtCopBranch, op(0x10) | 0x08<<21 | 1<<16},
+	"rfe":  {fmtNone, op(0x10) | 0x10<<21 | 0x10},
+	"mfc2": {fmtGTEData, op(0x12) | 0x00<<21},
+	"cfc2": {fmtGTEControl, op(0x12) | 0x02<<21},
+	"mtc2": {fmtGTEData, op(0x12) | 0x04<<21},
+	"ctc2": {fmtGTEControl, op(0x12) | 0x06<<21},
+	"bc2f": {fmtCopBranch, op(0x12) | 0x08<<21 | 0<<16},
+	"bc2t": {fmtCopBranch, op(0x12) | 0x08<<21 | 1<<16},
+	"lwc2": {fmtGTEMem, op(0x32)},
+	"swc2": {fmtGTEMem, op(0x3a)},
+}
+
+// gteCommands are the GTE commands, encoded as Sony's tools do. Bits 20-24
+// hold a command number ignored by the GTE, which is included so that code
+// assembles to the same words as the libraries. The sf and lm fields are
+// set to their usual values, used unless given as operands.
+var gteCommands = map[string]uint32{
+	"rtps":  0x0180001,
+	"rtpt":  0x0280030,
+	"mvmva": 0x0480012,
+	"dcpl":  0x0680029,
+	"dpcs":  0x0780010,
+	"intpl": 0x0980011,
+	"sqr":   0x0a80428,
+	"ncs":   0x0c8041e,
+	"nct":   0x0d80420,
+	"ncds":  0x0e80413,
+	"ncdt":  0x0f80416,
+	"dpct":  0x0f8002a,
+	"nccs":  0x108041b,
+	"ncct":  0x118043f,
+	"cdp":   0x1280414,
+	"cc":    0x138041c,
+	"nclip": 0x1400006,
+	"avsz3": 0x158002d,
+	"avsz4": 0x168002e,
+	"op":    0x178000c,
+	"gpf":   0x198003d,
+	"gpl":   0x1a8003e,
+}
+
+// registers are the general purpose registers by name.
+var registers = func() map[string]uint32 {
+	m := map[string]uint32{"$s8": 30}
+	for i := uint32(0); i < 32; i++ {
+		m[mips.Reg(i).String()] = i
+		m["$"+strconv.Itoa(int(i))] = i
+	}
+	return m
+}()
+
+// register parses a register from names, which maps them to their numbers.
+func register(s string, names map[string]uint32) (uint32, error) {
+	if r, ok := names[strings.ToLower(s)]; ok {
+		return r, nil
+	}
+	return 0, errors.Errorf("invalid register %s", s)
+}
+
+// namedRegisters returns the register numbers of a coprocessor by name,
+// which may also be given by number (e.g. $12).
+func namedRegisters(names [32]string) map[string]uint32 {
+	m := make(map[string]uint32)
+	for i, name := range names {
+		m["$"+strconv.Itoa(i)] = uint32(i)
+		if name != "" {
+			m[name] = uint32(i)
+		}
+	}
+	return m
+}
+
+var (
+	cop0Registers       = namedRegisters(disasm.COP0Registers)
+	gteDataRegisters    = namedRegisters(disasm.GTEDataRegisters)
+	gteControlRegisters = namedRegisters(disasm.GTEControlRegisters)
+)
+
+// instruction assembles an instruction or pseudo-instruction.
+func (a *assembler) instruction(name string, args []string) error {
+	if ok, err := a.pseudo(name, args); ok {
+		return err
+	}
+	if base, ok := gteCommands[name]; ok {
+		word, err := gteCommand(name, base, args)
+		if err != nil {
+			return err
+		}
+		a.emit(word)
+		return nil
+	}
+	if name == "cop2" {
+		if len(args) != 1 {
+			return errors.New("cop2 requires a command")
+		}
+		v, err := a.constant(args[0])
+		if err != nil {
+			return err
+		}
+		a.emit(op(0x12) | 1<<25 | v&0x1ffffff)
+		return nil
+	}
+	inst, ok := instructions[name]
+	if !ok {
+		return errors.Errorf("unknown instruction %s", name)
+	}
+	word, err := a.encode(inst, args)
+	if err != nil {
+		return errors.Wrap(err, name)
+	}
+	a.emit(word)
+	return nil
+}
+
+// expected is the number of operands for each format.
+var expected = map[format]int{
+	fmtNone:       0,
+	fmtRdRsRt:     3,
+	fmtRdRtSa:     3,
+	fmtRdRtRs:     3,
+	fmtRsRt:       2,
+	fmtRd:         1,
+	fmtRs:         1,
+	fmtRtRsImm:    3,
+	fmtRtRsUimm:   3,
+	fmtRtImm:      2,
+	fmtRtMem:      2,
+	fmtRsRtBranch: 3,
+	fmtRsBranch:   2,
+	fmtJump:       1,
+	fmtCOP0Move:   2,
+	fmtGTEData:    2,
+	fmtGTEControl: 2,
+	fmtGTEMem:     2,
+	fmtCopBranch:  1,
+}
+
+// encode encodes an instruction at the current address.
+func (a *assembler) encode(inst instruction, args []string) (uint32, error) {
+	if n, ok := expected[inst.format]; ok && len(args) != n {
+		return 0, errors.Errorf("expected %d operands, received %d", n, len(args))
+	}
+	// regs parses the operands as registers of the given kinds.
+	regs := func(names ...map[string]uint32) ([]uint32, error) {
+		r := make([]uint32, len(names))
+		for i, m := range names {
+			v, err := register(args[i], m)
+			if err != nil {
+				return nil, err
+			}
+			r[i] = v
+		}
+		return r, nil
+	}
+	word := inst.enc
+	switch inst.format {
+	case fmtNone:
+	case fmtRdRsRt, fmtRdRtRs:
+		r, err := regs(registers, registers, registers)
+		if err != nil {
+			return 0, err
+		}
+		if inst.format == fmtRdRtRs {
+			r[1], r[2] = r[2], r[1]
+		}
+		word |= r[0]<<11 | r[1]<<21 | r[2]<<16
+	case fmtRdRtSa:
+		r, err := regs(registers, registers)
+		if err != nil {
+			return 0, err
+		}
+		sa, err := a.constant(args[2])
+		if err != nil {
+			return 0, err
+		}
+		if sa > 31 {
+			return 0, errors.Errorf("shift amount %s out of range", args[2])
+		}
+		word |= r[0]<<11 | r[1]<<16 | sa<<6
+	case fmtRsRt:
+		r, err := regs(registers, registers)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0]<<21 | r[1]<<16
+	case fmtRd:
+		r, err := regs(registers)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0] << 11
+	case fmtRs:
+		r, err := regs(registers)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0] << 21
+	case fmtJalr:
+		switch len(args) {
+		case 1:
+			r, err := regs(registers)
+			if err != nil {
+				return 0, err
+			}
+			word |= r[0]<<21 | uint32(mips.RA)<<11
+		case 2:
+			r, err := regs(registers, registers)
+			if err != nil {
+				return 0, err
+			}
+			word |= r[0]<<11 | r[1]<<21
+		default:
+			return 0, errors.Errorf("expected 1 or 2 operands, received %d", len(args))
+		}
+	case fmtCode:
+		if len(args) > 1 {
+			return 0, errors.Errorf("expected at most 1 operand, received %d", len(args))
+		}
+		if len(args) == 1 {
+			code, err := a.constant(args[0])
+			if err != nil {
+				return 0, err
+			}
+			if code > 0xfffff {
+				return 0, errors.Errorf("code %s out of range", args[0])
+			}
+			word |= code << 6
+		}
+	case fmtRtRsImm, fmtRtRsUimm:
+		r, err := regs(registers, registers)
+		if err != nil {
+			return 0, err
+		}
+		imm, err := a.immediate(args[2], a.pc, inst.format == fmtRtRsImm)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0]<<16 | r[1]<<21 | imm
+	case fmtRtImm:
+		r, err := regs(registers)
+		if err != nil {
+			return 0, err
+		}
+		imm, err := a.immediate(args[1], a.pc, false)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0]<<16 | imm
+	case fmtRtMem, fmtGTEMem:
+		names := registers
+		if inst.format == fmtGTEMem {
+			names = gteDataRegisters
+		}
+		r, err := regs(names)
+		if err != nil {
+			return 0, err
+		}
+		base, offset, err := a.memory(args[1])
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0]<<16 | base<<21 | offset
+	case fmtRsRtBranch, fmtRsBranch, fmtCopBranch:
+		var r []uint32
+		var err error
+		switch inst.format {
+		case fmtRsRtBranch:
+			r, err = regs(registers, registers)
+			if err == nil {
+				word |= r[0]<<21 | r[1]<<16
+			}
+		case fmtRsBranch:
+			r, err = regs(registers)
+			if err == nil {
+				word |= r[0] << 21
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset, err := a.branch(args[len(args)-1])
+		if err != nil {
+			return 0, err
+		}
+		word |= offset
+	case fmtJump:
+		target, err := a.jump(args[0])
+		if err != nil {
+			return 0, err
+		}
+		word |= target
+	case fmtCOP0Move, fmtGTEData, fmtGTEControl:
+		names := map[format]map[string]uint32{
+			fmtCOP0Move:   cop0Registers,
+			fmtGTEData:    gteDataRegisters,
+			fmtGTEControl: gteControlRegisters,
+		}[inst.format]
+		r, err := regs(registers, names)
+		if err != nil {
+			return 0, err
+		}
+		word |= r[0]<<16 | r[1]<<11
+	}
+	return word, nil
+}
+
+// memory parses a memory operand, offset(base), where the offset may be
+// omitted.
+func (a *assembler) memory(s string) (uint32, uint32, error) {
+	i := strings.LastIndexByte(s, '(')
+	if i < 0 || !strings.HasSuffix(s, ")") {
+		return 0, 0, errors.Errorf("invalid memory operand %s", s)
+	}
+	base, err := register(s[i+1:len(s)-1], registers)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := uint32(0)
+	if off := strings.TrimSpace(s[:i]); off != "" {
+		if offset, err = a.immediate(off, a.pc, true); err != nil {
+			return 0, 0, err
+		}
+	}
+	return base, offset, nil
+}
+
+// branch returns the offset field of a branch at the current address to the
+// target, which must be defined.
+func (a *assembler) branch(s string) (uint32, error) {
+	e, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if e.symbol != "" {
+		return 0, errors.Errorf("branch to undefined symbol %s", e.symbol)
+	}
+	if a.pass == 1 {
+		return 0, nil
+	}
+	offset := int32(e.value-(a.pc+4)) >> 2
+	if e.value&3 != 0 || offset < -0x8000 || offset > 0x7fff {
+		return 0, errors.Errorf("branch target %s out of range", s)
+	}
+	return uint32(offset) & 0xffff, nil
+}
+
+// jump returns the target field of a jump at the current address.
+func (a *assembler) jump(s string) (uint32, error) {
+	e, err := a.eval(s)
+	if err != nil {
+		return 0, err
+	}
+	if e.symbol != "" {
+		a.relocate(a.pc, R_JMPADDR, e)
+		return 0, nil
+	}
+	if a.pass == 1 {
+		return 0, nil
+	}
+	if e.value&3 != 0 || (a.pc+4)&0xf0000000 != e.value&0xf0000000 {
+		return 0, errors.Errorf("jump target %s out of range", s)
+	}
+	return e.value >> 2 & 0x03ffffff, nil
+}
+
+// gteCommand encodes a GTE command. The sf and lm fields may be given as
+// operands (e.g. "sf=0"), while mvmva also takes its matrix, vector and
+// translation, as printed by the disassembler (e.g. "rt, v0, tr").
+func gteCommand(name string, word uint32, args []string) (uint32, error) {
+	if name == "mvmva" {
+		if len(args) < 3 {
+			return 0, errors.New("mvmva requires a matrix, vector and translation")
+		}
+		fields := []struct {
+			names [4]string
+			shift uint
+		}{
+			{disasm.MVMVAMatrices, 17},
+			{disasm.MVMVAVectors, 15},
+			{disasm.MVMVATranslations, 13},
+		}
+		for i, f := range fields {
+			v := -1
+			for j, n := range f.names {
+				if strings.ToLower(args[i]) == n {
+					v = j
+				}
+			}
+			if v < 0 {
+				return 0, errors.Errorf("invalid mvmva operand %s", args[i])
+			}
+			word |= uint32(v) << f.shift
+		}
+		args = args[3:]
+	}
+	for _, arg := range args {
+		kv := strings.SplitN(strings.ToLower(arg), "=", 2)
+		if len(kv) != 2 || kv[1] != "0" && kv[1] != "1" {
+			return 0, errors.Errorf("invalid %s operand %s", name, arg)
+		}
+		var bit uint32
+		switch kv[0] {
+		case "sf":
+			bit = 1 << 19
+		case "lm":
+			bit = 1 << 10
+		default:
+			return 0, errors.Errorf("invalid %s operand %s", name, arg)
+		}
+		word &^= bit
+		if kv[1] == "1" {
+			word |= bit
+		}
+	}
+	return op(0x12) | 1<<25 | word, nil
+}
+
+// pseudo assembles a pseudo-instruction, reporting whether name was one.
+func (a *assembler) pseudo(name string, args []string) (bool, error) {
+	want := func(n int) error {
+		if len(args) != n {
+			return errors.Errorf("%s: expected %d operands, received %d", name, n, len(args))
+		}
+		return nil
+	}
+	switch name {
+	case "nop":
+		if err := want(0); err != nil {
+			return true, err
+		}
+		a.emit(0)
+	case "move":
+		if err := want(2); err != nil {
+			return true, err
+		}
+		return true, a.instruction("addu", []string{args[0], args[1], "$zero"})
+	case "b":
+		if err := want(1); err != nil {
+			return true, err
+		}
+		return true, a.instruction("beq", []string{"$zero", "$zero", args[0]})
+	case "bal":
+		if err := want(1); err != nil {
+			return true, err
+		}
+		return true, a.instruction("bgezal", []string{"$zero", args[0]})
+	case "beqz", "bnez":
+		if err := want(2); err != nil {
+			return true, err
+		}
+		return true, a.instruction(name[:3], []string{args[0], "$zero", args[1]})
+	case "li", "la":
+		if err := want(2); err != nil {
+			return true, err
+		}
+		if name == "li" && a.known(args[1]) {
+			return true, a.loadConstant(args[0], args[1])
+		}
+		// Addresses are always loaded with a pair of instructions, since
+		// their value may not be known yet.
+		if err := a.instruction("lui", []string{args[0], "%hi(" + args[1] + ")"}); err != nil {
+			return true, err
+		}
+		return true, a.instruction("addiu", []string{args[0], args[0], "%lo(" + args[1] + ")"})
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// loadConstant loads a constant into a register with as few instructions as
+// possible.
+func (a *assembler) loadConstant(reg, s string) error {
+	v, err := a.constant(s)
+	if err != nil {
+		return err
+	}
+	hex := func(v uint32) string { return "0x" + strconv.FormatUint(uint64(v), 16) }
+	switch {
+	case int32(v) >= -0x8000 && int32(v) < 0x8000:
+		return a.instruction("addiu", []string{reg, "$zero", strconv.Itoa(int(int32(v)))})
+	case v <= 0xffff:
+		return a.instruction("ori", []string{reg, "$zero", hex(v)})
+	case v&0xffff == 0:
+		return a.instruction("lui", []string{reg, hex(v >> 16)})
+	}
+	if err := a.instruction("lui", []string{reg, hex(v >> 16)}); err != nil {
+		return err
+	}
+	return a.instruction("ori", []string{reg, reg, hex(v & 0xffff)})
+}
@@ -0,0 +1,125 @@
+package binutils
+
+import (
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+)
+
+func TestECOFFNameList(t *testing.T) {
+	f, err := ecoff.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	types := make(map[string]byte)
+	for _, s := range ECOFFNameList(f) {
+		types[s.Name] = s.Type
+	}
+	expected := map[string]byte{
+		"main":    'T',
+		"__main":  'T',
+		"putchar": 'A',
+	}
+	for name, typ := range expected {
+		if types[name] != typ {
+			t.Errorf("%s: expected type %c, received %c", name, typ, types[name])
+		}
+	}
+
+	f, err = ecoff.Open("../format/ecoff/testdata/video.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	symbols := ECOFFNameList(f)
+	if len(symbols) != 2 {
+		t.Fatalf("expected 2 symbols, received %d", len(symbols))
+	}
+	if s := symbols[1]; s.Name != "SsSetTickMode" || !s.Undefined() {
+		t.Errorf("expected undefined SsSetTickMode, received %s %c", s.Name, s.Type)
+	}
+}
+
+func TestPSXNameList(t *testing.T) {
+	f, err := psx.Open("../format/psx/testdata/psx.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := disasm.Symbols{
+		0x801412f0: "main",
+		0x80010000: "start",
+		0x80200000: "stack",
+		0x80100000: "printf",
+	}
+	list := PSXNameList(f, symbols)
+	expected := []Symbol{
+		{Name: "start", Value: 0x80010000, Type: 'T'},
+		{Name: "printf", Value: 0x80100000, Type: 'T'},
+		{Name: "main", Value: 0x801412f0, Type: 'T'},
+		{Name: "stack", Value: 0x80200000, Type: 'A'},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("expected %d symbols, received %d", len(expected), len(list))
+	}
+	for i, s := range list {
+		if *s != expected[i] {
+			t.Errorf("%d: expected %+v, received %+v", i, expected[i], *s)
+		}
+	}
+}
+
+func TestECOFFSizes(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected Sizes
+	}{
+		{"../format/ecoff/testdata/main-ecoff", Sizes{Text: 3072, Data: 1776, BSS: 124032}},
+		{"../format/ecoff/testdata/video.o", Sizes{Text: 64}},
+	}
+	for _, c := range cases {
+		f, err := ecoff.Open(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sizes := ECOFFSizes(f); sizes != c.expected {
+			t.Errorf("%s: expected %+v, received %+v", c.name, c.expected, sizes)
+		}
+		f.Close()
+	}
+}
+
+func TestStrings(t *testing.T) {
+	data := []byte("\x00\x01abc\x00hello\tworld\x00\xffgoodbye")
+	expected := []String{
+		{Addr: 0x80010006, Text: "hello\tworld"},
+		{Addr: 0x80010013, Text: "goodbye"},
+	}
+	strings := Strings(data, 0x80010000, 4)
+	if len(strings) != len(expected) {
+		t.Fatalf("expected %d strings, received %d", len(expected), len(strings))
+	}
+	for i, s := range strings {
+		if *s != expected[i] {
+			t.Errorf("expected %+v, received %+v", expected[i], *s)
+		}
+	}
+}
+
+func TestOpenObjects(t *testing.T) {
+	objects, err := OpenObjects("../format/ar/testdata/libtest.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"puts.o", "video.o"}
+	if len(objects) != len(expected) {
+		t.Fatalf("expected %d objects, received %d", len(expected), len(objects))
+	}
+	for i, o := range objects {
+		if o.Member != expected[i] || o.ECOFF == nil {
+			t.Errorf("expected ECOFF member %s, received %s", expected[i], o.Member)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package binutils
+
+import (
+	"sort"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+	"github.com/ChrisRx/psxsdk/pkg/format/sym"
+	"github.com/mewmew/mips"
+	"github.com/pkg/errors"
+)
+
+// An Image is the code and data of an ECOFF or PSX-EXE file as loaded into
+// memory, along with any symbols naming it.
+type Image struct {
+	Format   format.Format
+	Sections []*Section
+	Symbols  disasm.Symbols
+
+	// Entry and GP are the initial values of the program counter and of the
+	// global pointer register, which is zero if it isn't used.
+	Entry uint32
+	GP    uint32
+
+	// Relocations holds the relocation entries of an ECOFF object by the
+	// address of the word they relocate. It is empty for linked programs.
+	Relocations map[uint32]*ecoff.Relocation
+}
+
+// A Section is the contents of an ECOFF or PSX-EXE section. Data is empty for
+// sections that aren't stored in the file (e.g. .bss), which still have a Size.
+type Section struct {
+	Name       string
+	Addr       uint32
+	Size       uint32
+	Data       []byte
+	Executable bool
+}
+
+// Open loads the named ECOFF or PSX-EXE file, detecting its format from its
+// magic number.
+func Open(name string) (*Image, error) {
+	ft, err := format.DetectFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	switch ft {
+	case format.ECOFF:
+		f, err := ecoff.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return NewECOFFImage(f)
+	case format.PSXEXE:
+		f, err := psx.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return NewPSXImage(f), nil
+	}
+	return nil, errors.Errorf("%s: unsupported format %s", name, ft)
+}
+
+// NewECOFFImage returns the image of f, named by its symbols.
+func NewECOFFImage(f *ecoff.File) (*Image, error) {
+	img := &Image{
+		Format:  format.ECOFF,
+		Symbols: ECOFFSymbols(f),
+		Entry:   f.Entry,
+		GP:      f.GpValue,
+	}
+	for _, s := range f.Sections {
+		sec := &Section{
+			Name:       s.SectionName(),
+			Addr:       s.VirtualAddress,
+			Executable: s.Executable(),
+		}
+		if s.Size > 0 && s.Offset != 0 {
+			data, err := s.Data()
+			if err != nil {
+				return nil, errors.Wrapf(err, "cannot read section %s", sec.Name)
+			}
+			sec.Data = data
+			sec.Size = uint32(len(data))
+		}
+		for _, r := range s.Relocations {
+			if img.Relocations == nil {
+				img.Relocations = make(map[uint32]*ecoff.Relocation)
+			}
+			img.Relocations[r.Addr] = r
+		}
+		img.Sections = append(img.Sections, sec)
+	}
+
+	// The sizes of sections that aren't stored aren't kept in their headers,
+	// so they are taken to extend to the next section or the end of bss. The
+	// start of bss in the object header is rounded down to a page, so bss is
+	// taken to start with the first of these sections instead.
+	var bssStart, bssEnd uint32
+	for i, s := range img.Sections {
+		if s.Data != nil {
+			continue
+		}
+		if bssEnd == 0 {
+			bssStart, bssEnd = s.Addr, s.Addr+uint32(f.BssSize)
+		}
+		if s.Addr < bssStart || s.Addr >= bssEnd {
+			continue
+		}
+		end := bssEnd
+		if i+1 < len(img.Sections) {
+			if next := img.Sections[i+1].Addr; next > s.Addr && next < end {
+				end = next
+			}
+		}
+		s.Size = end - s.Addr
+	}
+	return img, nil
+}
+
+// NewPSXImage returns the image of f. A PSX-EXE has a single text section
+// holding both code and data, so it is treated as executable, followed by bss
+// if it has one, and has no symbols of its own.
+func NewPSXImage(f *psx.File) *Image {
+	img := &Image{
+		Format:  format.PSXEXE,
+		Symbols: make(disasm.Symbols),
+		Entry:   f.PC0,
+		GP:      f.GP0,
+	}
+	for _, s := range f.Sections {
+		img.Sections = append(img.Sections, &Section{
+			Name:       s.Name,
+			Addr:       s.Addr,
+			Size:       uint32(len(s.Data)),
+			Data:       s.Data,
+			Executable: s.Name == "text",
+		})
+	}
+	if f.BSSSize > 0 {
+		img.Sections = append(img.Sections, &Section{Name: "bss", Addr: f.BSSAddr, Size: f.BSSSize})
+	}
+	return img
+}
+
+// Section returns the named section, or nil if there isn't one.
+func (img *Image) Section(name string) *Section {
+	for _, s := range img.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// AddSymbols adds symbols at addresses that don't already have one.
+func (img *Image) AddSymbols(symbols disasm.Symbols) {
+	for addr, name := range symbols {
+		if _, ok := img.Symbols[addr]; !ok {
+			img.Symbols[addr] = name
+		}
+	}
+}
+
+// ECOFFSymbols returns the procedures, global symbols and static data of f by
+// address. Where several share an address, procedures are preferred, followed
+// by global and then static symbols.
+func ECOFFSymbols(f *ecoff.File) disasm.Symbols {
+	rank := func(s *ecoff.Symbol) int {
+		switch ecoff.StorageClass(s.StorageClass) {
+		case ecoff.SC_NIL, ecoff.SC_UNDEFINED, ecoff.SC_SUNDEFINED:
+			return -1
+		}
+		switch s.Type {
+		case ecoff.ST_PROC, ecoff.ST_STATIC_PROC:
+			return 0
+		case ecoff.ST_GLOBAL:
+			return 1
+		case ecoff.ST_STATIC:
+			return 2
+		}
+		return -1
+	}
+	symbols := make(disasm.Symbols)
+	ranks := make(map[uint32]int)
+	for _, s := range f.Symbols() {
+		r := rank(s)
+		if r < 0 {
+			continue
+		}
+		if prev, ok := ranks[s.Value]; ok && (prev < r || prev == r && symbols[s.Value] <= s.Name) {
+			continue
+		}
+		symbols[s.Value] = s.Name
+		ranks[s.Value] = r
+	}
+	return symbols
+}
+
+// LoadSymbols reads symbols from the named ECOFF file, or from a no$psx .SYM
+// or linker map file.
+func LoadSymbols(name string) (disasm.Symbols, error) {
+	if ft, err := format.DetectFile(name); err == nil && ft == format.ECOFF {
+		f, err := ecoff.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ECOFFSymbols(f), nil
+	}
+	f, err := sym.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Symbols) == 0 {
+		return nil, errors.Errorf("no symbols found in %s", name)
+	}
+	symbols := make(disasm.Symbols)
+	for _, s := range f.Symbols {
+		if _, ok := symbols[s.Addr]; !ok {
+			symbols[s.Addr] = s.Name
+		}
+	}
+	return symbols, nil
+}
+
+// ECOFFSymbolFile returns the symbols of f for export: procedures as functions
+// sized by their procedure descriptors, and global and static symbols as data
+// or labels. Absolute symbols, such as those of the Net Yaroze library that
+// the program is linked against, are included if abs is set.
+func ECOFFSymbolFile(f *ecoff.File, abs bool) *sym.File {
+	names := ECOFFSymbols(f)
+	procedures := make(map[uint32]*ecoff.Procedure)
+	for _, p := range f.Procedures {
+		procedures[p.Addr] = p
+	}
+	kinds := make(map[uint32]sym.Kind)
+	absolute := make(map[uint32]bool)
+	for _, s := range f.Symbols() {
+		if names[s.Value] != s.Name {
+			continue
+		}
+		switch ecoff.StorageClass(s.StorageClass) {
+		case ecoff.SC_ABS:
+			absolute[s.Value] = true
+		case ecoff.SC_DATA, ecoff.SC_BSS, ecoff.SC_SDATA, ecoff.SC_SBSS, ecoff.SC_RDATA,
+			ecoff.SC_COMMON, ecoff.SC_SCOMMON:
+			kinds[s.Value] = sym.Data
+		}
+		if s.Type == ecoff.ST_PROC || s.Type == ecoff.ST_STATIC_PROC {
+			kinds[s.Value] = sym.Function
+		}
+	}
+
+	out := &sym.File{}
+	for addr, name := range names {
+		if absolute[addr] && !abs {
+			continue
+		}
+		s := &sym.Symbol{Addr: addr, Name: name, Kind: kinds[addr]}
+		if p, ok := procedures[addr]; ok {
+			s.Kind = sym.Function
+			s.Size = p.Size
+			s.File = p.File.Name
+		}
+		out.Symbols = append(out.Symbols, s)
+	}
+	sort.Slice(out.Symbols, func(i, j int) bool { return out.Symbols[i].Addr < out.Symbols[j].Addr })
+	return out
+}
+
+// SymbolFile returns symbols naming img for export, such as those read from a
+// .SYM or map file for a PSX-EXE, which don't say what they name. As a PSX-EXE
+// holds code and data in the same section, symbols are only taken to name
+// functions if they are called with jal, and the rest are labels.
+func SymbolFile(img *Image, symbols disasm.Symbols) *sym.File {
+	called := make(map[uint32]bool)
+	for _, s := range img.Sections {
+		if !s.Executable {
+			continue
+		}
+		for _, inst := range disasm.Disassemble(s.Data[:len(s.Data)&^3], s.Addr) {
+			if inst.Valid() && inst.Op == mips.JAL {
+				called[inst.Target] = true
+			}
+		}
+	}
+	out := &sym.File{}
+	for addr, name := range symbols {
+		s := &sym.Symbol{Addr: addr, Name: name}
+		if called[addr] {
+			s.Kind = sym.Function
+		}
+		out.Symbols = append(out.Symbols, s)
+	}
+	sort.Slice(out.Symbols, func(i, j int) bool { return out.Symbols[i].Addr < out.Symbols[j].Addr })
+	return out
+}
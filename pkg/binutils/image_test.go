@@ -0,0 +1,133 @@
+package binutils
+
+import (
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/sym"
+)
+
+func TestOpen(t *testing.T) {
+	cases := []struct {
+		name     string
+		format   format.Format
+		sections []string
+		entry    uint32
+		symbol   string
+	}{
+		{"../format/ecoff/testdata/main-ecoff", format.ECOFF, []string{".text", ".rdata", ".data", ".sdata", ".sbss", ".bss"}, 0x80140000, "main"},
+		{"../format/psx/testdata/psx.exe", format.PSXEXE, []string{"text"}, 0x801412f0, ""},
+	}
+	for _, c := range cases {
+		img, err := Open(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Format != c.format || img.Entry != c.entry {
+			t.Errorf("%s: expected %s entry 0x%08x, received %s entry 0x%08x", c.name, c.format, c.entry, img.Format, img.Entry)
+		}
+		if len(img.Sections) != len(c.sections) {
+			t.Fatalf("%s: expected %d sections, received %d", c.name, len(c.sections), len(img.Sections))
+		}
+		for i, name := range c.sections {
+			if s := img.Sections[i]; s.Name != name || s.Executable != (i == 0) {
+				t.Errorf("%s: expected section %s, received %s (executable %v)", c.name, name, s.Name, s.Executable)
+			}
+		}
+		if c.symbol != "" && img.Symbols[img.Section(".text").Addr+0x1c0] != c.symbol {
+			t.Errorf("%s: expected symbol %s", c.name, c.symbol)
+		}
+	}
+}
+
+func TestECOFFSymbolFile(t *testing.T) {
+	f, err := ecoff.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	all := ECOFFSymbolFile(f, true)
+	program := ECOFFSymbolFile(f, false)
+	if len(program.Symbols) == 0 || len(all.Symbols) <= len(program.Symbols) {
+		t.Fatalf("expected library symbols to be added to %d symbols, received %d", len(program.Symbols), len(all.Symbols))
+	}
+	expected := map[string]sym.Symbol{
+		"main":      {Addr: 0x801401c0, Name: "main", Kind: sym.Function, Size: 0x394, File: "main.c"},
+		"ballcolor": {Addr: 0x80140e70, Name: "ballcolor", Kind: sym.Data},
+		"InitHeap":  {Addr: 0x80010700, Name: "InitHeap", Kind: sym.Label},
+	}
+	for _, s := range all.Symbols {
+		if e, ok := expected[s.Name]; ok {
+			if *s != e {
+				t.Errorf("expected %+v, received %+v", e, *s)
+			}
+			delete(expected, s.Name)
+		}
+	}
+	for name := range expected {
+		t.Errorf("symbol %s not found", name)
+	}
+	for _, s := range program.Symbols {
+		if s.Name == "InitHeap" {
+			t.Errorf("expected library symbol InitHeap to be left out")
+		}
+	}
+}
+
+func TestSymbolFile(t *testing.T) {
+	img, err := Open("../format/psx/testdata/psx.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := disasm.Symbols{
+		0x801401c0: "main",
+		0x80141330: "GpuPacketArea",
+		0x1f801814: "GPUSTAT",
+	}
+	expected := []sym.Symbol{
+		{Addr: 0x1f801814, Name: "GPUSTAT", Kind: sym.Label},
+		{Addr: 0x801401c0, Name: "main", Kind: sym.Function},
+		{Addr: 0x80141330, Name: "GpuPacketArea", Kind: sym.Label},
+	}
+	f := SymbolFile(img, symbols)
+	if len(f.Symbols) != len(expected) {
+		t.Fatalf("expected %d symbols, received %d", len(expected), len(f.Symbols))
+	}
+	for i, s := range f.Symbols {
+		if *s != expected[i] {
+			t.Errorf("expected %+v, received %+v", expected[i], *s)
+		}
+	}
+}
+
+func TestSectionSizes(t *testing.T) {
+	img, err := Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint32{
+		".text":  2800,
+		".rdata": 272,
+		".sbss":  0x10,
+		".bss":   124032 - 0x10,
+	}
+	for name, size := range expected {
+		if s := img.Section(name); s == nil || s.Size != size {
+			t.Errorf("%s: expected size %d, received %+v", name, size, s)
+		}
+	}
+	if len(img.Relocations) != 0 {
+		t.Errorf("expected no relocations, received %d", len(img.Relocations))
+	}
+
+	img, err = Open("../format/ecoff/testdata/puts.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := img.Relocations[0x20]; r == nil || r.Symbol != "putchar" {
+		t.Errorf("expected a relocation for putchar at 0x20, received %v", r)
+	}
+}
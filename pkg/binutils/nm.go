@@ -0,0 +1,88 @@
+package binutils
+
+import (
+	"sort"
+
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+)
+
+// A Symbol is an entry in the listing of symbols written by nm. Type is a
+// letter in the style of GNU nm giving the section of the symbol (e.g. 'T' for
+// text or 'U' for undefined), which is lower case for local symbols.
+type Symbol struct {
+	Name  string
+	Value uint32
+	Type  byte
+}
+
+// Undefined reports whether the symbol is referred to, but not defined.
+func (s *Symbol) Undefined() bool {
+	return s.Type == 'U'
+}
+
+// symbolTypes are the nm letters of ECOFF storage classes.
+var symbolTypes = map[ecoff.StorageClass]byte{
+	ecoff.SC_TEXT:       'T',
+	ecoff.SC_INIT:       'T',
+	ecoff.SC_FINI:       'T',
+	ecoff.SC_DATA:       'D',
+	ecoff.SC_BSS:        'B',
+	ecoff.SC_RDATA:      'R',
+	ecoff.SC_SDATA:      'G',
+	ecoff.SC_SBSS:       'S',
+	ecoff.SC_ABS:        'A',
+	ecoff.SC_COMMON:     'C',
+	ecoff.SC_SCOMMON:    'C',
+	ecoff.SC_UNDEFINED:  'U',
+	ecoff.SC_SUNDEFINED: 'U',
+}
+
+// ECOFFNameList returns the external symbols of f, followed by its static
+// symbols, in the order of the symbol tables. Debugging symbols are left out.
+func ECOFFNameList(f *ecoff.File) []*Symbol {
+	var symbols []*Symbol
+	for _, s := range f.ExternalSymbols {
+		t, ok := symbolTypes[ecoff.StorageClass(s.StorageClass)]
+		if !ok || s.Type == ecoff.ST_NIL {
+			continue
+		}
+		symbols = append(symbols, &Symbol{Name: s.Name, Value: s.Value, Type: t})
+	}
+	for _, s := range f.LocalSymbols {
+		if s.Type != ecoff.ST_STATIC && s.Type != ecoff.ST_STATIC_PROC {
+			continue
+		}
+		t, ok := symbolTypes[ecoff.StorageClass(s.StorageClass)]
+		if !ok || t == 'U' {
+			continue
+		}
+		symbols = append(symbols, &Symbol{Name: s.Name, Value: s.Value, Type: t + 'a' - 'A'})
+	}
+	return symbols
+}
+
+// PSXNameList returns symbols, which name addresses in f, with the type of
+// the segment they fall in, ordered by address. A PSX-EXE has no symbols of its
+// own, so they must come from another file.
+func PSXNameList(f *psx.File, symbols disasm.Symbols) []*Symbol {
+	within := func(addr, start, size uint32) bool {
+		return size > 0 && addr >= start && addr-start < size
+	}
+	var list []*Symbol
+	for addr, name := range symbols {
+		t := byte('A')
+		switch {
+		case within(addr, f.TextAddr, f.TextSize):
+			t = 'T'
+		case within(addr, f.DataAddr, f.DataSize):
+			t = 'D'
+		case within(addr, f.BSSAddr, f.BSSSize):
+			t = 'B'
+		}
+		list = append(list, &Symbol{Name: name, Value: addr, Type: t})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Value < list[j].Value })
+	return list
+}
@@ -0,0 +1,78 @@
+package binutils
+
+import (
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ar"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+	"github.com/pkg/errors"
+)
+
+// An Object is an ECOFF or PSX-EXE file, or an ECOFF object that is a member
+// of an archive. Exactly one of ECOFF and PSX is set.
+type Object struct {
+	// Name is the name of the file and Member the name of the archive
+	// member, if the object is one.
+	Name   string
+	Member string
+
+	ECOFF *ecoff.File
+	PSX   *psx.File
+}
+
+// Image returns the image of the object.
+func (o *Object) Image() (*Image, error) {
+	if o.PSX != nil {
+		return NewPSXImage(o.PSX), nil
+	}
+	return NewECOFFImage(o.ECOFF)
+}
+
+// Close closes the underlying file, if any.
+func (o *Object) Close() error {
+	if o.ECOFF != nil {
+		return o.ECOFF.Close()
+	}
+	return nil
+}
+
+// OpenObjects opens the named ECOFF or PSX-EXE file, or the members of the
+// named archive, detecting the format from its magic number.
+func OpenObjects(name string) ([]*Object, error) {
+	ft, err := format.DetectFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	switch ft {
+	case format.ECOFF:
+		f, err := ecoff.Open(name)
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		return []*Object{{Name: name, ECOFF: f}}, nil
+	case format.PSXEXE:
+		f, err := psx.Open(name)
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		return []*Object{{Name: name, PSX: f}}, nil
+	case format.Archive:
+		a, err := ar.Open(name)
+		if err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		var objects []*Object
+		for _, m := range a.Members {
+			if ft, _ := format.Detect(m.Open()); ft != format.ECOFF {
+				continue
+			}
+			f, err := ecoff.NewFile(m.Open())
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s(%s)", name, m.Name)
+			}
+			objects = append(objects, &Object{Name: name, Member: m.Name, ECOFF: f})
+		}
+		return objects, nil
+	}
+	return nil, errors.Errorf("%s: unsupported format %s", name, ft)
+}
@@ -0,0 +1,42 @@
+package binutils
+
+import (
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+)
+
+// Sizes are the sizes of the segments of a program, in the style of GNU size:
+// read-only data is counted as text.
+type Sizes struct {
+	Text uint32
+	Data uint32
+	BSS  uint32
+}
+
+// Total returns the sum of the sizes.
+func (s Sizes) Total() uint32 {
+	return s.Text + s.Data + s.BSS
+}
+
+// ECOFFSizes returns the sizes of the segments of f. Sections that aren't
+// stored in the file have no size, so the size of bss is taken from the
+// object header.
+func ECOFFSizes(f *ecoff.File) Sizes {
+	var sizes Sizes
+	for _, s := range f.Sections {
+		flags := uint32(s.Flags)
+		switch {
+		case flags&(ecoff.STYP_TEXT|ecoff.STYP_RDATA|ecoff.STYP_INIT|ecoff.STYP_FINI|ecoff.STYP_LIT4|ecoff.STYP_LIT8) != 0:
+			sizes.Text += uint32(s.Size)
+		case flags&(ecoff.STYP_DATA|ecoff.STYP_SDATA) != 0:
+			sizes.Data += uint32(s.Size)
+		}
+	}
+	sizes.BSS = uint32(f.BssSize)
+	return sizes
+}
+
+// PSXSizes returns the sizes of the segments of f, as given by its header.
+func PSXSizes(f *psx.File) Sizes {
+	return Sizes{Text: f.TextSize, Data: f.DataSize, BSS: f.BSSSize}
+}
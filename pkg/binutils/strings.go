@@ -0,0 +1,27 @@
+package binutils
+
+// A String is a run of printable characters found in a file.
+type String struct {
+	Addr uint32
+	Text string
+}
+
+// Strings returns the runs of at least min printable ASCII characters (and
+// tabs) in data, which is loaded at addr.
+func Strings(data []byte, addr uint32, min int) []*String {
+	var strings []*String
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && (data[i] >= 0x20 && data[i] < 0x7f || data[i] == '\t') {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= min {
+			strings = append(strings, &String{Addr: addr + uint32(start), Text: string(data[start:i])})
+		}
+		start = -1
+	}
+	return strings
+}
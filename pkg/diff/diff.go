@@ -0,0 +1,480 @@
+// Package diff compares the code of two builds of a program function by
+// function. Functions are aligned by their symbols, and their instructions are
+// compared ignoring the differences that come only from relocation, such as
+// the addresses of calls and data changing when code before them grows. An
+// address is only taken to be relocated if it is at a symbol or within a
+// section of its build, and it must be at the same symbol and offset, or the
+// same offset into the same section, in both builds. Everything else, such as
+// constants and the addresses of hardware registers, must be identical.
+package diff
+
+import (
+	"sort"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/mewmew/mips"
+)
+
+// A Status describes how a function differs between builds.
+type Status int
+
+const (
+	// Unchanged is a function with the same size and code at the same
+	// address.
+	Unchanged Status = iota
+
+	// Moved is a function with the same size and code at another address.
+	Moved
+
+	// Resized is a function whose size changed.
+	Resized
+
+	// Changed is a function of the same size whose code changed.
+	Changed
+
+	// Added is a function only in the new build.
+	Added
+
+	// Removed is a function only in the old build.
+	Removed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Unchanged:
+		return "unchanged"
+	case Moved:
+		return "moved"
+	case Resized:
+		return "resized"
+	case Changed:
+		return "changed"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the status by name.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// An Op describes a line of the difference between two functions.
+type Op int
+
+const (
+	// Equal is an instruction in both functions.
+	Equal Op = iota
+
+	// Delete is an instruction only in the old function.
+	Delete
+
+	// Insert is an instruction only in the new function.
+	Insert
+)
+
+// A Line is an instruction of the old function, the new function, or of both
+// if they are equal.
+type Line struct {
+	Op  Op
+	Old *disasm.Inst
+	New *disasm.Inst
+}
+
+// A Function is the difference between the builds of a function. Old is nil
+// for added functions and New for removed ones.
+type Function struct {
+	Name   string
+	Status Status
+	Old    *disasm.Function
+	New    *disasm.Function
+
+	// Lines holds the difference between the instructions of resized and
+	// changed functions.
+	Lines []Line
+}
+
+// A build is the functions of an image and what is needed to tell which of
+// their instructions are relocated.
+type build struct {
+	functions []*disasm.Function
+	symbols   disasm.Symbols
+	sections  []*binutils.Section
+	gp        uint32
+
+	// addrs holds the addresses of the symbols, sorted.
+	addrs []uint32
+
+	// relocations are the relocation entries of an ECOFF object, which
+	// say exactly which instructions are relocated.
+	relocations map[uint32]*ecoff.Relocation
+}
+
+func newBuild(img *binutils.Image) *build {
+	b := &build{
+		symbols:     img.Symbols,
+		sections:    img.Sections,
+		gp:          img.GP,
+		relocations: img.Relocations,
+	}
+	for _, s := range img.Sections {
+		if s.Executable {
+			insts := disasm.Disassemble(s.Data[:len(s.Data)&^3], s.Addr)
+			b.functions = append(b.functions, disasm.Functions(insts, img.Symbols)...)
+		}
+	}
+	for addr := range img.Symbols {
+		b.addrs = append(b.addrs, addr)
+	}
+	sort.Slice(b.addrs, func(i, j int) bool { return b.addrs[i] < b.addrs[j] })
+	return b
+}
+
+// Compare returns the differences between the functions of old and new.
+// Functions present in both are listed first, along with removed functions,
+// in the order of the old build, followed by added functions in the order of
+// the new build. Functions without symbols are named by their address, so
+// they only align if they didn't move.
+func Compare(old, new *binutils.Image) []*Function {
+	a, b := newBuild(old), newBuild(new)
+
+	// Functions are paired by name, in order, so static functions with the
+	// same name in different files are still aligned.
+	byName := make(map[string][]*disasm.Function)
+	for _, fn := range b.functions {
+		byName[fn.Name] = append(byName[fn.Name], fn)
+	}
+	paired := make(map[*disasm.Function]bool)
+	var diffs []*Function
+	for _, fn := range a.functions {
+		d := &Function{Name: fn.Name, Old: fn, Status: Removed}
+		if fns := byName[fn.Name]; len(fns) > 0 {
+			d.New = fns[0]
+			byName[fn.Name] = fns[1:]
+			paired[d.New] = true
+			compare(d, a, b)
+		}
+		diffs = append(diffs, d)
+	}
+	for _, fn := range b.functions {
+		if !paired[fn] {
+			diffs = append(diffs, &Function{Name: fn.Name, New: fn, Status: Added})
+		}
+	}
+	return diffs
+}
+
+// compare sets the status of d, and the lines of its difference if the code
+// changed.
+func compare(d *Function, a, b *build) {
+	x, y := instructions(d.Old), instructions(d.New)
+	kx, ky := a.keys(x), b.keys(y)
+	same := len(x) == len(y)
+	for i := 0; same && i < len(x); i++ {
+		same = kx[i].matches(ky[i])
+	}
+	switch {
+	case d.Old.Size != d.New.Size:
+		d.Status = Resized
+	case !same:
+		d.Status = Changed
+	case d.Old.Addr != d.New.Addr:
+		d.Status = Moved
+	default:
+		d.Status = Unchanged
+	}
+	if d.Status == Resized || d.Status == Changed {
+		d.Lines = diffLines(x, y, func(i, j int) bool {
+			return kx[i].matches(ky[j])
+		})
+	}
+}
+
+// instructions returns the instructions of fn in order.
+func instructions(fn *disasm.Function) []*disasm.Inst {
+	var insts []*disasm.Inst
+	for _, b := range fn.Blocks {
+		insts = append(insts, b.Insts...)
+	}
+	return insts
+}
+
+// A location is a relocated address, as an offset from a symbol or, where
+// there is no symbol before it in its section, from the start of the section.
+type location struct {
+	base   string
+	offset uint32
+}
+
+// hiLocation is the location of a lui whose address is completed by another
+// instruction, which holds the location of the whole address.
+var hiLocation = &location{base: "%hi"}
+
+// A key is an instruction as compared between builds. The fields of a
+// relocated instruction that hold its address are masked out of word, and
+// loc is where the address is, which is nil if it isn't relocated. raw is
+// the instruction as it is.
+type key struct {
+	word, raw uint32
+	loc       *location
+}
+
+// matches reports whether the instructions are the same but for relocation.
+// Relocated instructions match if they refer to the same location, while
+// others, or one of each, such as where only one build has a global pointer,
+// must be identical.
+func (k key) matches(o key) bool {
+	if k.loc == nil || o.loc == nil {
+		return k.raw == o.raw
+	}
+	return k.word == o.word && *k.loc == *o.loc
+}
+
+// locate returns the location of addr, reporting whether it is at a symbol
+// or within a section of the build. Other addresses, such as those of
+// hardware registers, aren't relocated.
+func (b *build) locate(addr uint32) (*location, bool) {
+	if name, ok := b.symbols[addr]; ok {
+		return &location{name, 0}, true
+	}
+	for _, s := range b.sections {
+		if addr < s.Addr || addr-s.Addr >= s.Size {
+			continue
+		}
+		i := sort.Search(len(b.addrs), func(i int) bool { return b.addrs[i] > addr })
+		if i > 0 && b.addrs[i-1] >= s.Addr {
+			sym := b.addrs[i-1]
+			return &location{b.symbols[sym], addr - sym}, true
+		}
+		return &location{s.Name, addr - s.Addr}, true
+	}
+	return nil, false
+}
+
+// reference returns the address inst refers to and the mask of the field
+// holding it, reporting whether it refers to one: the target of a jump, an
+// address completed with a lui, or an address relative to the global pointer.
+func (b *build) reference(inst *disasm.Inst) (uint32, uint32, bool) {
+	switch {
+	case !inst.Valid():
+		return 0, 0, false
+	case inst.Op == mips.J || inst.Op == mips.JAL:
+		return inst.Target, 0x03ffffff, true
+	case inst.HasRef:
+		return inst.Ref, 0xffff, true
+	case inst.Word>>26 > 3 && inst.Word>>21&0x1f == uint32(mips.GP) && b.gp != 0:
+		// an I-type instruction, such as a load or store, relative to $gp
+		return b.gp + uint32(int32(int16(inst.Word))), 0xffff, true
+	}
+	return 0, 0, false
+}
+
+// keys returns the keys of insts. Where the build has relocation entries,
+// exactly the instructions they relocate are masked. Otherwise, references
+// are masked if they are to a symbol or section, along with the lui they were
+// completed from.
+func (b *build) keys(insts []*disasm.Inst) []key {
+	keys := make([]key, len(insts))
+	if len(b.relocations) > 0 {
+		for i, inst := range insts {
+			keys[i] = b.relocationKey(inst)
+		}
+		return keys
+	}
+	hi := make(map[uint32]bool)
+	for i, inst := range insts {
+		keys[i] = key{word: inst.Word, raw: inst.Word}
+		if addr, mask, ok := b.reference(inst); ok {
+			if loc, ok := b.locate(addr); ok {
+				keys[i] = key{inst.Word &^ mask, inst.Word, loc}
+				if inst.HasRef {
+					hi[inst.RefHi] = true
+				}
+			}
+		}
+	}
+	for i, inst := range insts {
+		if hi[inst.Addr] && inst.Op == mips.LUI {
+			keys[i] = key{inst.Word &^ 0xffff, inst.Word, hiLocation}
+		}
+	}
+	return keys
+}
+
+// relocationKey returns the key of inst as given by its relocation entry, if
+// it has one. The location of a reference to a section is its offset into
+// the section, and of one to an external symbol its offset from the symbol.
+func (b *build) relocationKey(inst *disasm.Inst) key {
+	r, ok := b.relocations[inst.Addr]
+	if !ok {
+		return key{word: inst.Word, raw: inst.Word}
+	}
+	var mask uint32
+	switch r.Type {
+	case ecoff.R_REFHI:
+		return key{inst.Word &^ 0xffff, inst.Word, hiLocation}
+	case ecoff.R_JMPADDR:
+		mask = 0x03ffffff
+	case ecoff.R_REFWORD:
+		mask = 0xffffffff
+	default:
+		mask = 0xffff
+	}
+	addr, _, ok := b.reference(inst)
+	if !ok {
+		addr = inst.Word & mask
+	}
+	loc := &location{base: r.Symbol, offset: addr}
+	if !r.External {
+		for _, s := range b.sections {
+			if s.Name == r.Symbol {
+				loc.offset -= s.Addr
+				break
+			}
+		}
+	}
+	return key{inst.Word &^ mask, inst.Word, loc}
+}
+
+// diffLines returns the shortest edit from x to y, found from their longest
+// common subsequence. The common prefix and suffix are matched directly, and
+// the rest with Hirschberg's algorithm, which only needs space linear in the
+// length of the instructions, as functions without symbols can be very long.
+func diffLines(x, y []*disasm.Inst, equal func(i, j int) bool) []Line {
+	n, m := len(x), len(y)
+	var prefix, suffix int
+	for prefix < n && prefix < m && equal(prefix, prefix) {
+		prefix++
+	}
+	for suffix < n-prefix && suffix < m-prefix && equal(n-1-suffix, m-1-suffix) {
+		suffix++
+	}
+	d := &differ{x: x, y: y, equal: equal}
+	for i := 0; i < prefix; i++ {
+		d.lines = append(d.lines, Line{Equal, x[i], y[i]})
+	}
+	d.diff(prefix, n-suffix, prefix, m-suffix)
+	for i := suffix; i > 0; i-- {
+		d.lines = append(d.lines, Line{Equal, x[n-i], y[m-i]})
+	}
+	return d.lines
+}
+
+// A differ finds the longest common subsequence of two lists of instructions
+// with Hirschberg's algorithm, appending the edit to lines.
+type differ struct {
+	x, y  []*disasm.Inst
+	equal func(i, j int) bool
+	lines []Line
+}
+
+// diff appends the edit from x[i0:i1] to y[j0:j1].
+func (d *differ) diff(i0, i1, j0, j1 int) {
+	switch {
+	case i0 == i1:
+		for j := j0; j < j1; j++ {
+			d.lines = append(d.lines, Line{Op: Insert, New: d.y[j]})
+		}
+		return
+	case j0 == j1:
+		for i := i0; i < i1; i++ {
+			d.lines = append(d.lines, Line{Op: Delete, Old: d.x[i]})
+		}
+		return
+	case i1-i0 == 1:
+		for j := j0; j < j1; j++ {
+			if d.equal(i0, j) {
+				d.diff(i0, i0, j0, j)
+				d.lines = append(d.lines, Line{Equal, d.x[i0], d.y[j]})
+				d.diff(i1, i1, j+1, j1)
+				return
+			}
+		}
+		d.diff(i0, i1, j0, j0)
+		d.diff(i1, i1, j0, j1)
+		return
+	}
+
+	// split x in half, and y where the longest common subsequences of the
+	// halves with the parts of y either side add up to the most
+	mid := (i0 + i1) / 2
+	forward := d.forward(i0, mid, j0, j1)
+	backward := d.backward(mid, i1, j0, j1)
+	split, best := j0, -1
+	for k := 0; k <= j1-j0; k++ {
+		if v := forward[k] + backward[k]; v > best {
+			split, best = j0+k, v
+		}
+	}
+	d.diff(i0, mid, j0, split)
+	d.diff(mid, i1, split, j1)
+}
+
+// forward returns the lengths of the longest common subsequences of x[i0:i1]
+// and each y[j0:j0+k].
+func (d *differ) forward(i0, i1, j0, j1 int) []int {
+	prev := make([]int, j1-j0+1)
+	cur := make([]int, j1-j0+1)
+	for i := i0; i < i1; i++ {
+		for k := 1; k <= j1-j0; k++ {
+			switch {
+			case d.equal(i, j0+k-1):
+				cur[k] = prev[k-1] + 1
+			case prev[k] >= cur[k-1]:
+				cur[k] = prev[k]
+			default:
+				cur[k] = cur[k-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// backward returns the lengths of the longest common subsequences of
+// x[i0:i1] and each y[j0+k:j1].
+func (d *differ) backward(i0, i1, j0, j1 int) []int {
+	prev := make([]int, j1-j0+1)
+	cur := make([]int, j1-j0+1)
+	for i := i1 - 1; i >= i0; i-- {
+		cur[j1-j0] = 0
+		for k := j1 - j0 - 1; k >= 0; k-- {
+			switch {
+			case d.equal(i, j0+k):
+				cur[k] = prev[k+1] + 1
+			case prev[k] >= cur[k+1]:
+				cur[k] = prev[k]
+			default:
+				cur[k] = cur[k+1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// Changes returns the functions that differ, leaving out unchanged ones.
+func Changes(diffs []*Function) []*Function {
+	var changes []*Function
+	for _, d := range diffs {
+		if d.Status != Unchanged {
+			changes = append(changes, d)
+		}
+	}
+	return changes
+}
+
+// Count returns the number of functions with each status.
+func Count(diffs []*Function) map[Status]int {
+	counts := make(map[Status]int)
+	for _, d := range diffs {
+		counts[d.Status]++
+	}
+	return counts
+}
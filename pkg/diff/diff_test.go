@@ -0,0 +1,249 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/asm"
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+)
+
+const oldSource = `
+main:
+	addiu   $sp, $sp, -24
+	sw      $ra, 16($sp)
+	jal     helper
+	nop
+	lw      $ra, 16($sp)
+	jr      $ra
+	addiu   $sp, $sp, 24
+helper:
+	la      $v0, 0x80020000
+	jr      $ra
+	nop
+compute:
+	jr      $ra
+	addiu   $v0, $a0, 1
+constant:
+	li      $a0, 0x12345678
+	jr      $ra
+	nop
+hardware:
+	lui     $at, 0x1f80
+	lw      $v0, 0x1810($at)
+	jr      $ra
+	nop
+getdata:
+	la      $v0, table
+	jr      $ra
+	nop
+table:
+	.word   0
+unused:
+	jr      $ra
+	nop
+`
+
+// newSource inserts an instruction in main, which moves everything after it.
+// helper refers to an address outside the program, which isn't relocated, so
+// it changes, as do compute, constant and hardware. getdata only refers to
+// table, which has moved with it. unused is replaced with extra.
+const newSource = `
+main:
+	addiu   $sp, $sp, -24
+	sw      $ra, 16($sp)
+	jal     helper
+	nop
+	addu    $a0, $zero, $zero
+	lw      $ra, 16($sp)
+	jr      $ra
+	addiu   $sp, $sp, 24
+helper:
+	la      $v0, 0x80020010
+	jr      $ra
+	nop
+compute:
+	jr      $ra
+	addiu   $v0, $a0, 2
+constant:
+	li      $a0, 0x12345679
+	jr      $ra
+	nop
+hardware:
+	lui     $at, 0x1f80
+	lw      $v0, 0x1814($at)
+	jr      $ra
+	nop
+getdata:
+	la      $v0, table
+	jr      $ra
+	nop
+table:
+	.word   0
+extra:
+	jr      $ra
+	nop
+`
+
+func assemble(t *testing.T, src string) *binutils.Image {
+	p, err := asm.Assemble(src, 0x80010000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols := make(disasm.Symbols)
+	for name, addr := range p.Symbols {
+		symbols[addr] = name
+	}
+	return &binutils.Image{
+		Sections: []*binutils.Section{{Name: "text", Addr: p.Addr, Size: uint32(len(p.Code)), Data: p.Code, Executable: true}},
+		Symbols:  symbols,
+	}
+}
+
+func TestCompare(t *testing.T) {
+	diffs := Compare(assemble(t, oldSource), assemble(t, newSource))
+	expected := []struct {
+		name   string
+		status Status
+	}{
+		{"main", Resized},
+		{"helper", Changed},
+		{"compute", Changed},
+		{"constant", Changed},
+		{"hardware", Changed},
+		{"getdata", Moved},
+		{"table", Moved},
+		{"unused", Removed},
+		{"extra", Added},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d functions, received %d", len(expected), len(diffs))
+	}
+	for i, d := range diffs {
+		if d.Name != expected[i].name || d.Status != expected[i].status {
+			t.Errorf("expected %s %s, received %s %s", expected[i].name, expected[i].status, d.Name, d.Status)
+		}
+	}
+
+	ops := []Op{Equal, Equal, Equal, Equal, Insert, Equal, Equal, Equal}
+	lines := diffs[0].Lines
+	if len(lines) != len(ops) {
+		t.Fatalf("expected %d lines, received %d", len(ops), len(lines))
+	}
+	for i, l := range lines {
+		if l.Op != ops[i] {
+			t.Errorf("line %d: expected op %d, received %d", i, ops[i], l.Op)
+		}
+	}
+	if l := diffs[2].Lines; len(l) != 3 || l[1].Op != Delete || l[2].Op != Insert {
+		t.Errorf("expected compute to replace its second instruction, received %+v", l)
+	}
+	if l := diffs[4].Lines; len(l) != 5 || l[1].Op != Delete || l[2].Op != Insert {
+		t.Errorf("expected hardware to replace its load, received %+v", l)
+	}
+	if counts := Count(diffs); counts[Unchanged] != 0 || len(Changes(diffs)) != len(expected) {
+		t.Errorf("expected every function to differ, received %v", counts)
+	}
+}
+
+// relocated returns an image of src at 0x80010000, with its data at addr and
+// the instructions at the given offsets relocated against it.
+func relocated(t *testing.T, src string, addr uint32, relocs map[uint32]ecoff.RelocationType) *binutils.Image {
+	img := assemble(t, src)
+	img.Sections = append(img.Sections, &binutils.Section{Name: ".data", Addr: addr, Size: 0x100})
+	img.Relocations = make(map[uint32]*ecoff.Relocation)
+	for offset, typ := range relocs {
+		img.Relocations[0x80010000+offset] = &ecoff.Relocation{Addr: offset, Type: typ, Symbol: ".data"}
+	}
+	return img
+}
+
+func TestCompareRelocations(t *testing.T) {
+	const src = `
+load:
+	la      $v0, %#x
+	li      $v1, %#x
+	jr      $ra
+	nop
+`
+	relocs := map[uint32]ecoff.RelocationType{0: ecoff.R_REFHI, 4: ecoff.R_REFLO}
+	tests := []struct {
+		name               string
+		oldData, newData   uint32
+		oldValue, newValue uint32
+		status             Status
+	}{
+		// the data moved and only the relocated address follows it
+		{"moved", 0x80020000, 0x80020010, 0x80020004, 0x80020004, Unchanged},
+		// the second address isn't relocated, so it must not change
+		{"constant", 0x80020000, 0x80020010, 0x80020004, 0x80020014, Changed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := relocated(t, fmt.Sprintf(src, tt.oldData+4, tt.oldValue), tt.oldData, relocs)
+			new := relocated(t, fmt.Sprintf(src, tt.newData+4, tt.newValue), tt.newData, relocs)
+			diffs := Compare(old, new)
+			if len(diffs) != 1 || diffs[0].Status != tt.status {
+				t.Fatalf("expected %s, received %+v", tt.status, diffs)
+			}
+		})
+	}
+}
+
+// A PSX-EXE without symbols is divided into a few very long functions, which
+// must be compared without needing memory for every pair of instructions.
+func TestCompareSelf(t *testing.T) {
+	img, err := binutils.Open("../format/psx/testdata/psx.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs := Compare(img, img)
+	if len(diffs) == 0 {
+		t.Fatal("expected functions to be compared")
+	}
+	if changes := Changes(diffs); len(changes) != 0 {
+		t.Fatalf("expected no changes, received %d", len(changes))
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	words := func(s string) []*disasm.Inst {
+		insts := make([]*disasm.Inst, len(s))
+		for i := range s {
+			insts[i] = &disasm.Inst{Word: uint32(s[i])}
+		}
+		return insts
+	}
+	tests := []struct {
+		x, y string
+		ops  string
+	}{
+		{"abcabba", "cbabac", "--=+==-=+"},
+		{"abc", "abc", "==="},
+		{"", "ab", "++"},
+		{"ab", "", "--"},
+		{"abxcd", "abycd", "==-+=="},
+	}
+	symbols := map[Op]byte{Equal: '=', Delete: '-', Insert: '+'}
+	for _, tt := range tests {
+		x, y := words(tt.x), words(tt.y)
+		lines := diffLines(x, y, func(i, j int) bool { return x[i].Word == y[j].Word })
+		ops := make([]byte, len(lines))
+		equal := 0
+		for i, l := range lines {
+			ops[i] = symbols[l.Op]
+			if l.Op == Equal {
+				equal++
+			}
+		}
+		if expected := strings.Count(tt.ops, "="); equal != expected {
+			t.Errorf("%s -> %s: expected %d equal lines, received %s", tt.x, tt.y, expected, ops)
+		}
+		if len(ops) != len(tt.ops) {
+			t.Errorf("%s -> %s: expected %s, received %s", tt.x, tt.y, tt.ops, ops)
+		}
+	}
+}
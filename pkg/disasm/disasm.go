@@ -0,0 +1,221 @@
+// Package disasm disassembles MIPS R3000A code, as run by the Playstation,
+// following branches and the addresses built up in registers so they can be
+// named with symbols.
+package disasm
+
+import (
+	"encoding/binary"
+
+	"github.com/mewmew/mips"
+)
+
+// A Flow describes how an instruction affects the flow of execution. Every
+// kind other than FlowNone is followed by a delay slot.
+type Flow int
+
+const (
+	// FlowNone continues with the next instruction.
+	FlowNone Flow = iota
+
+	// FlowBranch continues at Target if a condition holds.
+	FlowBranch
+
+	// FlowJump always continues at Target (j, b).
+	FlowJump
+
+	// FlowCall calls the procedure at Target (jal, bal, bltzal, bgezal).
+	FlowCall
+
+	// FlowJumpRegister continues at the address in a register (jr), such as
+	// for a switch statement.
+	FlowJumpRegister
+
+	// FlowCallRegister calls the procedure at the address in a register
+	// (jalr).
+	FlowCallRegister
+
+	// FlowReturn returns from the procedure (jr $ra).
+	FlowReturn
+)
+
+// An Inst is a single disassembled instruction.
+type Inst struct {
+	Addr uint32
+	Word uint32
+
+	// Inst is the decoded instruction, which has an Op of zero if the word
+	// isn't a valid instruction.
+	mips.Inst
+
+	// Flow describes where execution continues, with Target holding the
+	// destination of branches, jumps and calls.
+	Flow   Flow
+	Target uint32
+
+	// Ref is the address composed by this instruction and a preceding lui of
+	// the same register (e.g. lui/addiu or lui/lw), if HasRef is set. RefHi is
+	// the address of the lui.
+	Ref    uint32
+	HasRef bool
+	RefHi  uint32
+
+	// DelaySlot is set for an instruction executed in the delay slot of a
+	// branch or jump.
+	DelaySlot bool
+
+	// Mnemonic and Operands are set for instructions that the mips package
+	// can't format for the Playstation, such as GTE commands, and are used
+	// instead of Op and Args when printing.
+	Mnemonic string
+	Operands []string
+}
+
+// Valid reports whether the instruction could be decoded.
+func (i *Inst) Valid() bool {
+	return i.Op != 0
+}
+
+// HasTarget reports whether Target holds the destination of the instruction.
+func (i *Inst) HasTarget() bool {
+	switch i.Flow {
+	case FlowBranch, FlowJump, FlowCall:
+		return true
+	}
+	return false
+}
+
+// Decode decodes a single instruction at addr.
+func Decode(addr, word uint32) *Inst {
+	inst := &Inst{Addr: addr, Word: word}
+	if !decodeR3000A(inst) && !unusedFieldsSet(word) {
+		inst.Inst = decode(word)
+	}
+	if !inst.Valid() {
+		return inst
+	}
+	rs := mips.Reg(word >> 21 & 0x1f)
+	rt := mips.Reg(word >> 16 & 0x1f)
+	branch := addr + 4 + uint32(int32(int16(word)))<<2
+	switch inst.Op {
+	case mips.J, mips.JAL:
+		inst.Flow = FlowJump
+		if inst.Op == mips.JAL {
+			inst.Flow = FlowCall
+		}
+		inst.Target = (addr+4)&0xf0000000 | (word&0x03ffffff)<<2
+	case mips.JR:
+		inst.Flow = FlowJumpRegister
+		if rs == mips.RA {
+			inst.Flow = FlowReturn
+		}
+	case mips.JALR:
+		inst.Flow = FlowCallRegister
+
+		// The mips package writes the operands of jalr in the wrong order.
+		rd := mips.Reg(word >> 11 & 0x1f)
+		inst.Mnemonic = "jalr"
+		inst.Operands = []string{rs.String()}
+		if rd != mips.RA {
+			inst.Operands = []string{rd.String(), rs.String()}
+		}
+	case mips.BEQ, mips.BGEZ:
+		inst.Flow = FlowBranch
+		if rs == mips.ZERO && (inst.Op == mips.BGEZ || rt == mips.ZERO) {
+			inst.Flow = FlowJump
+		}
+		inst.Target = branch
+	case mips.BLTZAL, mips.BGEZAL:
+		inst.Flow = FlowCall
+		inst.Target = branch
+	case mips.BNE, mips.BLEZ, mips.BGTZ, mips.BLTZ,
+		mips.BC0F, mips.BC2F, mips.BC0T, mips.BC2T:
+		inst.Flow = FlowBranch
+		inst.Target = branch
+	}
+	return inst
+}
+
+// decode decodes word with the mips package, which panics on some encodings it
+// doesn't support.
+func decode(word uint32) (inst mips.Inst) {
+	defer func() {
+		if recover() != nil {
+			inst = mips.Inst{}
+		}
+	}()
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], word)
+	inst, err := mips.Decode(buf[:])
+	if err != nil {
+		return mips.Inst{}
+	}
+	return inst
+}
+
+// Disassemble decodes code loaded at addr. Addresses composed with lui are
+// only followed within straight-line code, between branch targets.
+func Disassemble(code []byte, addr uint32) []*Inst {
+	insts := make([]*Inst, 0, len(code)/4)
+	targets := make(map[uint32]bool)
+	for i := 0; i+4 <= len(code); i += 4 {
+		inst := Decode(addr+uint32(i), binary.LittleEndian.Uint32(code[i:]))
+		if inst.HasTarget() {
+			targets[inst.Target] = true
+		}
+		insts = append(insts, inst)
+	}
+
+	// hi holds the lui that last set each register.
+	hi := make(map[mips.Reg]*Inst)
+	for i, inst := range insts {
+		if i > 0 && insts[i-1].Flow != FlowNone {
+			inst.DelaySlot = true
+		}
+		if targets[inst.Addr] || i > 1 && insts[i-2].Flow != FlowNone && insts[i-2].Flow != FlowBranch {
+			hi = make(map[mips.Reg]*Inst)
+		}
+		if !inst.Valid() {
+			continue
+		}
+		rs := mips.Reg(inst.Word >> 21 & 0x1f)
+		rt := mips.Reg(inst.Word >> 16 & 0x1f)
+		imm := uint32(int32(int16(inst.Word)))
+		if lui, ok := hi[rs]; ok {
+			v := lui.Word << 16
+			switch inst.Op {
+			case mips.ADDIU, mips.ADDI:
+				inst.Ref, inst.HasRef = v+imm, true
+			case mips.ORI:
+				inst.Ref, inst.HasRef = v|inst.Word&0xffff, true
+			case mips.LB, mips.LBU, mips.LH, mips.LHU, mips.LW, mips.LWL, mips.LWR,
+				mips.SB, mips.SH, mips.SW, mips.SWL, mips.SWR,
+				mips.LWC2, mips.SWC2:
+				inst.Ref, inst.HasRef = v+imm, true
+			}
+			if inst.HasRef {
+				inst.RefHi = lui.Addr
+			}
+		}
+		if r, ok := inst.Args[0].(mips.Reg); ok && writesFirstArg(inst.Op) {
+			delete(hi, r)
+		}
+		if inst.Op == mips.LUI {
+			hi[rt] = inst
+		}
+	}
+	return insts
+}
+
+// writesFirstArg reports whether the first argument of op is a register
+// written by the instruction.
+func writesFirstArg(op mips.Op) bool {
+	switch op {
+	case mips.SB, mips.SH, mips.SW, mips.SWL, mips.SWR,
+		mips.SWC2, mips.MTC0, mips.MTC2, mips.CTC2,
+		mips.MULT, mips.MULTU, mips.DIV, mips.DIVU, mips.MTHI, mips.MTLO,
+		mips.JR, mips.JALR, mips.BEQ, mips.BNE, mips.BLEZ, mips.BGTZ,
+		mips.BLTZ, mips.BGEZ, mips.BLTZAL, mips.BGEZAL:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package disasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func words(v ...uint32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, w := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], w)
+	}
+	return data
+}
+
+func TestDisassemble(t *testing.T) {
+	code := words(
+		0x3c048002, // lui     $a0, 0x8002
+		0x0c004008, // jal     callee
+		0x24841234, // addiu   $a0, $a0, 0x1234
+		0x14400002, // bne     $v0, $zero, .L80010018
+		0x00000000, // nop
+		0x24020001, // addiu   $v0, $zero, 0x1
+		0x03e00008, // jr      $ra
+		0x00000000, // nop
+		0x03e00008, // jr      $ra
+		0x00000000, // nop
+		0xffffffff, // not an instruction
+	)
+	insts := Disassemble(code, 0x80010000)
+	if len(insts) != 11 {
+		t.Fatalf("expected 11 instructions, received %d", len(insts))
+	}
+	flows := []Flow{FlowNone, FlowCall, FlowNone, FlowBranch, FlowNone, FlowNone, FlowReturn, FlowNone, FlowReturn, FlowNone, FlowNone}
+	for i, inst := range insts {
+		if inst.Flow != flows[i] {
+			t.Errorf("0x%08x: expected flow %d, received %d", inst.Addr, flows[i], inst.Flow)
+		}
+	}
+	if insts[1].Target != 0x80010020 || insts[3].Target != 0x80010018 {
+		t.Fatalf("unexpected targets 0x%08x and 0x%08x", insts[1].Target, insts[3].Target)
+	}
+	if !insts[2].HasRef || insts[2].Ref != 0x80021234 || insts[2].RefHi != 0x80010000 {
+		t.Fatalf("expected lui/addiu to compose 0x80021234 from 0x80010000, received %v 0x%08x from 0x%08x", insts[2].HasRef, insts[2].Ref, insts[2].RefHi)
+	}
+	if insts[10].Valid() {
+		t.Fatalf("expected 0x%08x to be invalid, decoded as %s", insts[10].Word, insts[10].Op)
+	}
+
+	symbols := Symbols{
+		0x80010000: "main",
+		0x80010020: "callee",
+		0x80021234: "message",
+	}
+	var b bytes.Buffer
+	if err := NewPrinter(insts, symbols).Fprint(&b, insts); err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+80010000 <main>:
+80010000:  3c048002  lui     $a0, 0x8002
+80010004:  0c004008  jal     callee
+80010008:  24841234  addiu   $a0, $a0, 0x1234   # 0x80021234 <message>; delay slot
+8001000c:  14400002  bne     $v0, $zero, .L80010018
+80010010:  00000000  nop                        # delay slot
+80010014:  24020001  addiu   $v0, $zero, 0x1
+.L80010018:
+80010018:  03e00008  jr      $ra
+8001001c:  00000000  nop                        # delay slot
+
+80010020 <callee>:
+80010020:  03e00008  jr      $ra
+80010024:  00000000  nop                        # delay slot
+80010028:  ffffffff  .word   0xffffffff
+`
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\nreceived:\n%s", expected, b.String())
+	}
+}
+
+func TestDecodeR3000A(t *testing.T) {
+	cases := []struct {
+		word     uint32
+		expected string
+	}{
+		{0x4a280030, "rtpt    sf=1, lm=0"},
+		{0x4a486012, "mvmva   rt, v0, none, sf=1, lm=0"},
+		{0x4b400006, "nclip"},
+		{0x40026000, "mfc0    $v0, $sr"},
+		{0x48024800, "mfc2    $v0, $ir1"},
+		{0x48c2f800, "ctc2    $v0, $flag"},
+		{0xc8800000, "lwc2    $vxy0, 0($a0)"},
+		{0x42000010, "rfe"},
+		{0x49010003, "bc2t    0x80010010"},
+		{0x4c554e3c, ".word   0x4c554e3c"},
+		{0x45000003, ".word   0x45000003"},
+		{0xc4800000, ".word   0xc4800000"},
+	}
+	p := NewPrinter(nil, nil)
+	for _, c := range cases {
+		text, _ := p.Format(Decode(0x80010000, c.word))
+		if text != c.expected {
+			t.Errorf("0x%08x: expected %q, received %q", c.word, c.expected, text)
+		}
+	}
+	if inst := Decode(0x80010000, 0x49010003); inst.Flow != FlowBranch || inst.Target != 0x80010010 {
+		t.Errorf("expected bc2t to branch to 0x80010010, received flow %d to 0x%08x", inst.Flow, inst.Target)
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	cases := []struct {
+		word     uint32
+		expected string
+	}{
+		{0x0040f809, "jalr    $v0"},
+		{0x00401009, "jalr    $v0, $v0"},
+		{0x00a0f809, "jalr    $a1"},
+		{0x03e00008, "jr      $ra"},
+		{0x03e00808, ".word   0x03e00808"},
+		{0x00201000, ".word   0x00201000"},
+		{0x3c218002, ".word   0x3c218002"},
+		{0x18210003, ".word   0x18210003"},
+		{0x00820018, "mult    $a0, $v0"},
+		{0x00821018, ".word   0x00821018"},
+	}
+	p := NewPrinter(nil, nil)
+	for _, c := range cases {
+		text, _ := p.Format(Decode(0x80010000, c.word))
+		if text != c.expected {
+			t.Errorf("0x%08x: expected %q, received %q", c.word, c.expected, text)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dotEscaper escapes text within a quoted Graphviz ID.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// dotQuote quotes s as a Graphviz ID.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// WriteCFG writes the control-flow graph of fn to w in the Graphviz DOT
+// language, with each basic block labeled by its disassembly. Taken branches
+// are drawn in green, branches not taken in red and jumps in blue.
+func (p *Printer) WriteCFG(w io.Writer, fn *Function) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotQuote(fn.Name))
+	fmt.Fprintf(bw, "\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, b := range fn.Blocks {
+		var label strings.Builder
+		if name, ok := p.Name(b.Addr); ok {
+			fmt.Fprintf(&label, "%s:\\l", dotEscaper.Replace(name))
+		}
+		for _, inst := range b.Insts {
+			text, comment := p.Format(inst)
+			line := fmt.Sprintf("%08x:  %s", inst.Addr, text)
+			if comment != "" {
+				line = fmt.Sprintf("%-40s# %s", line, comment)
+			}
+			label.WriteString(dotEscaper.Replace(line))
+			label.WriteString(`\l`)
+		}
+		fmt.Fprintf(bw, "\t\"%08x\" [label=\"%s\"];\n", b.Addr, label.String())
+	}
+	for _, b := range fn.Blocks {
+		for _, e := range b.Succs {
+			color := "blue"
+			switch {
+			case e.Kind == EdgeBranch:
+				color = "green"
+			case e.Kind == EdgeFallthrough && len(b.Succs) > 1:
+				color = "red"
+			case e.Kind == EdgeFallthrough:
+				color = "black"
+			}
+			fmt.Fprintf(bw, "\t\"%08x\" -> \"%08x\" [color=%s];\n", b.Addr, e.To, color)
+		}
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
+
+// WriteCallGraph writes the call graph of functions to w in the Graphviz DOT
+// language. Procedures that are called but aren't among functions, such as
+// those of a library, are drawn dashed and named from symbols if possible.
+func WriteCallGraph(w io.Writer, functions []*Function, symbols Symbols) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "digraph calls {\n")
+	fmt.Fprint(bw, "\tnode [shape=box];\n")
+	defined := make(map[uint32]bool)
+	for _, fn := range functions {
+		defined[fn.Addr] = true
+		fmt.Fprintf(bw, "\t\"%08x\" [label=%s];\n", fn.Addr, dotQuote(fn.Name))
+	}
+	external := make(map[uint32]bool)
+	for _, fn := range functions {
+		for _, addr := range fn.Calls {
+			if defined[addr] || external[addr] {
+				continue
+			}
+			external[addr] = true
+			name, ok := symbols[addr]
+			if !ok {
+				name = ProcedureName(addr)
+			}
+			fmt.Fprintf(bw, "\t\"%08x\" [label=%s, style=dashed];\n", addr, dotQuote(name))
+		}
+	}
+	for _, fn := range functions {
+		for _, addr := range fn.Calls {
+			fmt.Fprintf(bw, "\t\"%08x\" -> \"%08x\";\n", fn.Addr, addr)
+		}
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
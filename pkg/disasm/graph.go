@@ -0,0 +1,204 @@
+package disasm
+
+import (
+	"sort"
+)
+
+// An EdgeKind describes how control passes from one basic block to another.
+type EdgeKind int
+
+const (
+	// EdgeFallthrough continues with the following block, either because it
+	// starts at a branch target or after a conditional branch isn't taken.
+	EdgeFallthrough EdgeKind = iota
+
+	// EdgeBranch is taken when the condition of a branch holds.
+	EdgeBranch
+
+	// EdgeJump is an unconditional jump or branch.
+	EdgeJump
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFallthrough:
+		return "fallthrough"
+	case EdgeBranch:
+		return "branch"
+	case EdgeJump:
+		return "jump"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the kind by name.
+func (k EdgeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// An Edge leads from a basic block to the block at To.
+type Edge struct {
+	Kind EdgeKind `json:"kind"`
+	To   uint32   `json:"to"`
+}
+
+// A Block is a basic block: instructions that are only entered at the first
+// and only left after the last. A block ending in a branch or jump includes
+// the delay slot, which is executed whichever way it goes, unless the delay
+// slot is also a branch target and so starts the following block.
+type Block struct {
+	Addr  uint32  `json:"addr"`
+	Insts []*Inst `json:"-"`
+	Succs []Edge  `json:"succs,omitempty"`
+}
+
+// End returns the address following the block.
+func (b *Block) End() uint32 {
+	return b.Addr + 4*uint32(len(b.Insts))
+}
+
+// A Function is a procedure, running from its entry point to the next one.
+type Function struct {
+	Name   string   `json:"name"`
+	Addr   uint32   `json:"addr"`
+	Size   uint32   `json:"size"`
+	Blocks []*Block `json:"blocks,omitempty"`
+
+	// Calls holds the addresses of the procedures called, including tail
+	// calls made by jumping to another procedure, in order.
+	Calls []uint32 `json:"calls,omitempty"`
+
+	// IndirectCalls counts the calls through registers (jalr), whose
+	// targets aren't known.
+	IndirectCalls int `json:"indirect_calls,omitempty"`
+}
+
+// Functions divides insts, which should be contiguous, into functions and
+// their basic blocks. Functions start at each symbol and each address that is
+// called, with those that have no symbol named by ProcedureName.
+func Functions(insts []*Inst, symbols Symbols) []*Function {
+	if len(insts) == 0 {
+		return nil
+	}
+	start, end := insts[0].Addr, insts[len(insts)-1].Addr+4
+	within := func(addr uint32) bool {
+		return addr >= start && addr < end && addr%4 == 0
+	}
+
+	entries := map[uint32]bool{start: true}
+	for addr := range symbols {
+		if within(addr) {
+			entries[addr] = true
+		}
+	}
+	for _, inst := range insts {
+		if inst.Flow == FlowCall && within(inst.Target) {
+			entries[inst.Target] = true
+		}
+	}
+	addrs := make([]uint32, 0, len(entries))
+	for addr := range entries {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	var functions []*Function
+	for i, addr := range addrs {
+		next := end
+		if i+1 < len(addrs) {
+			next = addrs[i+1]
+		}
+		name, ok := symbols[addr]
+		if !ok {
+			name = ProcedureName(addr)
+		}
+		fn := &Function{Name: name, Addr: addr, Size: next - addr}
+		fn.analyze(insts[(addr-start)/4 : (next-start)/4])
+		functions = append(functions, fn)
+	}
+	return functions
+}
+
+// analyze divides the instructions of the function into basic blocks and
+// finds the procedures it calls. Jumps out of the function are tail calls.
+func (fn *Function) analyze(insts []*Inst) {
+	end := fn.Addr + fn.Size
+	within := func(addr uint32) bool {
+		return addr >= fn.Addr && addr < end && addr%4 == 0
+	}
+
+	// Blocks start at the entry point, at each branch target and after the
+	// delay slot of each branch or jump.
+	leaders := map[uint32]bool{fn.Addr: true}
+	for _, inst := range insts {
+		if inst.Flow == FlowNone {
+			continue
+		}
+		if inst.HasTarget() && inst.Flow != FlowCall && within(inst.Target) {
+			leaders[inst.Target] = true
+		}
+		leaders[inst.Addr+8] = true
+	}
+
+	called := make(map[uint32]bool)
+	call := func(addr uint32) {
+		if !called[addr] {
+			called[addr] = true
+			fn.Calls = append(fn.Calls, addr)
+		}
+	}
+	var b *Block
+	for i, inst := range insts {
+		if leaders[inst.Addr] {
+			b = &Block{Addr: inst.Addr}
+			fn.Blocks = append(fn.Blocks, b)
+		}
+		b.Insts = append(b.Insts, inst)
+		if i+1 < len(insts) && !leaders[insts[i+1].Addr] {
+			continue
+		}
+
+		// The block ends here, so its successors are decided by the
+		// branch or jump before the delay slot, if there is one. A delay
+		// slot that is itself a branch target starts a block of its own,
+		// so the block ends with the branch instead, and falls through to
+		// the delay slot where the branch would.
+		next := inst.Addr + 4
+		var last *Inst
+		switch {
+		case len(b.Insts) > 1 && inst.Flow == FlowNone:
+			last = b.Insts[len(b.Insts)-2]
+		case inst.Flow != FlowNone:
+			last = inst
+		}
+		flow := FlowNone
+		if last != nil {
+			flow = last.Flow
+		}
+		switch flow {
+		case FlowNone, FlowCallRegister, FlowCall:
+			if flow == FlowCall {
+				call(last.Target)
+			} else if flow == FlowCallRegister {
+				fn.IndirectCalls++
+			}
+			if within(next) {
+				b.Succs = append(b.Succs, Edge{EdgeFallthrough, next})
+			}
+		case FlowBranch:
+			if within(last.Target) {
+				b.Succs = append(b.Succs, Edge{EdgeBranch, last.Target})
+			}
+			if within(next) {
+				b.Succs = append(b.Succs, Edge{EdgeFallthrough, next})
+			}
+		case FlowJump:
+			if within(last.Target) {
+				b.Succs = append(b.Succs, Edge{EdgeJump, last.Target})
+			} else {
+				call(last.Target)
+			}
+		}
+	}
+}
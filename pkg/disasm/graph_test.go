@@ -0,0 +1,141 @@
+package disasm
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	code := words(
+		0x0c00400a, // jal     0x80010028
+		0x00000000, // nop
+		0x14400003, // bne     $v0, $zero, .L80010018
+		0x00000000, // nop
+		0x0c0c0000, // jal     0x80300000
+		0x00000000, // nop
+		0x0040f809, // jalr    $v0
+		0x00000000, // nop
+		0x0800400a, // j       0x80010028
+		0x00000000, // nop
+		0x03e00008, // jr      $ra
+		0x00000000, // nop
+	)
+	insts := Disassemble(code, 0x80010000)
+	functions := Functions(insts, Symbols{0x80010000: "main"})
+	if len(functions) != 2 {
+		t.Fatalf("expected 2 functions, received %d", len(functions))
+	}
+	main, callee := functions[0], functions[1]
+	if main.Name != "main" || main.Addr != 0x80010000 || main.Size != 0x28 {
+		t.Fatalf("unexpected function %s at 0x%08x size %d", main.Name, main.Addr, main.Size)
+	}
+	if callee.Name != "sub_80010028" || callee.Size != 0x8 {
+		t.Fatalf("unexpected function %s at 0x%08x size %d", callee.Name, callee.Addr, callee.Size)
+	}
+	if expected := []uint32{0x80010028, 0x80300000}; !reflect.DeepEqual(main.Calls, expected) {
+		t.Errorf("expected calls %x, received %x", expected, main.Calls)
+	}
+	if main.IndirectCalls != 1 {
+		t.Errorf("expected 1 indirect call, received %d", main.IndirectCalls)
+	}
+
+	expected := []struct {
+		addr  uint32
+		size  int
+		succs []Edge
+	}{
+		{0x80010000, 2, []Edge{{EdgeFallthrough, 0x80010008}}},
+		{0x80010008, 2, []Edge{{EdgeBranch, 0x80010018}, {EdgeFallthrough, 0x80010010}}},
+		{0x80010010, 2, []Edge{{EdgeFallthrough, 0x80010018}}},
+		{0x80010018, 2, []Edge{{EdgeFallthrough, 0x80010020}}},
+		{0x80010020, 2, nil},
+	}
+	if len(main.Blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, received %d", len(expected), len(main.Blocks))
+	}
+	for i, b := range main.Blocks {
+		e := expected[i]
+		if b.Addr != e.addr || len(b.Insts) != e.size || !reflect.DeepEqual(b.Succs, e.succs) {
+			t.Errorf("block %d: expected 0x%08x (%d) %v, received 0x%08x (%d) %v", i, e.addr, e.size, e.succs, b.Addr, len(b.Insts), b.Succs)
+		}
+	}
+
+	data, err := json.Marshal(main.Blocks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := `{"addr":2147549192,"succs":[{"kind":"branch","to":2147549208},{"kind":"fallthrough","to":2147549200}]}`; string(data) != s {
+		t.Errorf("expected %s, received %s", s, data)
+	}
+
+	var b bytes.Buffer
+	if err := WriteCallGraph(&b, functions, Symbols{0x80300000: "printf"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"80010000" [label="main"];`,
+		`"80300000" [label="printf", style=dashed];`,
+		`"80010000" -> "80010028";`,
+		`"80010000" -> "80300000";`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected call graph to contain %s:\n%s", s, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := NewPrinter(insts, Symbols{0x80010000: "main"}).WriteCFG(&b, main); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`digraph "main" {`,
+		`"80010008" [label="80010008:  bne     $v0, $zero, .L80010018\l8001000c:  nop`,
+		`"80010008" -> "80010018" [color=green];`,
+		`"80010008" -> "80010010" [color=red];`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected control-flow graph to contain %s:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestFunctionsDelaySlotTarget(t *testing.T) {
+	code := words(
+		0x14400003, // bne     $v0, $zero, .L80010010
+		0x2442ffff, // addiu   $v0, $v0, -1
+		0x08004001, // j       .L80010004
+		0x00000000, // nop
+		0x03e00008, // jr      $ra
+		0x00000000, // nop
+	)
+	functions := Functions(Disassemble(code, 0x80010000), Symbols{0x80010000: "loop"})
+	if len(functions) != 1 {
+		t.Fatalf("expected 1 function, received %d", len(functions))
+	}
+
+	// the delay slot of bne is the target of j, so it starts a block that
+	// bne falls through to, and bne keeps its branch
+	expected := []struct {
+		addr  uint32
+		size  int
+		succs []Edge
+	}{
+		{0x80010000, 1, []Edge{{EdgeBranch, 0x80010010}, {EdgeFallthrough, 0x80010004}}},
+		{0x80010004, 1, []Edge{{EdgeFallthrough, 0x80010008}}},
+		{0x80010008, 2, []Edge{{EdgeJump, 0x80010004}}},
+		{0x80010010, 2, nil},
+	}
+	blocks := functions[0].Blocks
+	if len(blocks) != len(expected) {
+		t.Fatalf("expected %d blocks, received %d", len(expected), len(blocks))
+	}
+	for i, b := range blocks {
+		e := expected[i]
+		if b.Addr != e.addr || len(b.Insts) != e.size || !reflect.DeepEqual(b.Succs, e.succs) {
+			t.Errorf("block %d: expected 0x%08x (%d) %v, received 0x%08x (%d) %v", i, e.addr, e.size, e.succs, b.Addr, len(b.Insts), b.Succs)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mewmew/mips"
+)
+
+// Symbols maps addresses to names.
+type Symbols map[uint32]string
+
+// commentColumn is the column at which comments start.
+const commentColumn = 48
+
+// A Printer writes disassembled instructions in the style of objdump -d,
+// naming addresses with symbols where possible and synthesizing labels for
+// targets that have no symbol: sub_ for procedures that are called and .L for
+// other branch targets.
+type Printer struct {
+	symbols Symbols
+	labels  map[uint32]string
+
+	// procedures holds the labels given to procedures, which are printed like
+	// symbols.
+	procedures map[uint32]bool
+}
+
+// NewPrinter returns a Printer for insts, which should be contiguous.
+func NewPrinter(insts []*Inst, symbols Symbols) *Printer {
+	p := &Printer{
+		symbols:    symbols,
+		labels:     make(map[uint32]string),
+		procedures: make(map[uint32]bool),
+	}
+	if len(insts) == 0 {
+		return p
+	}
+	start, end := insts[0].Addr, insts[len(insts)-1].Addr+4
+	for _, inst := range insts {
+		if !inst.HasTarget() || inst.Target < start || inst.Target >= end {
+			continue
+		}
+		if _, ok := symbols[inst.Target]; ok {
+			continue
+		}
+		if inst.Flow == FlowCall {
+			p.labels[inst.Target] = ProcedureName(inst.Target)
+			p.procedures[inst.Target] = true
+		} else if _, ok := p.labels[inst.Target]; !ok {
+			p.labels[inst.Target] = fmt.Sprintf(".L%08x", inst.Target)
+		}
+	}
+	return p
+}
+
+// ProcedureName returns the name given to a procedure at addr that has no
+// symbol.
+func ProcedureName(addr uint32) string {
+	return fmt.Sprintf("sub_%08x", addr)
+}
+
+// Name returns the symbol or local label at addr, if there is one.
+func (p *Printer) Name(addr uint32) (string, bool) {
+	if name, ok := p.symbols[addr]; ok {
+		return name, true
+	}
+	name, ok := p.labels[addr]
+	return name, ok
+}
+
+// address formats addr as an operand, using its name if it has one.
+func (p *Printer) address(addr uint32) string {
+	if name, ok := p.Name(addr); ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", addr)
+}
+
+// Format returns the instruction, with branch and jump targets named, and
+// any comment describing it.
+func (p *Printer) Format(inst *Inst) (string, string) {
+	var text string
+	switch {
+	case !inst.Valid():
+		text = fmt.Sprintf("%-8s0x%08x", ".word", inst.Word)
+	case inst.Word == 0:
+		text = "nop"
+	case inst.Mnemonic != "":
+		text = inst.Mnemonic
+		if len(inst.Operands) > 0 {
+			text = fmt.Sprintf("%-8s%s", text, strings.Join(inst.Operands, ", "))
+		}
+	default:
+		var args []string
+		for _, arg := range inst.Args {
+			if arg == nil {
+				break
+			}
+			switch arg.(type) {
+			case mips.PCRel:
+				args = append(args, p.address(inst.Target))
+			case mips.Imm:
+				if inst.Op == mips.J || inst.Op == mips.JAL {
+					args = append(args, p.address(inst.Target))
+					continue
+				}
+				args = append(args, arg.String())
+			default:
+				args = append(args, arg.String())
+			}
+		}
+		text = inst.Op.String()
+		if len(args) > 0 {
+			text = fmt.Sprintf("%-8s%s", text, strings.Join(args, ", "))
+		}
+	}
+
+	var comments []string
+	if inst.HasRef {
+		ref := fmt.Sprintf("0x%08x", inst.Ref)
+		if name, ok := p.Name(inst.Ref); ok {
+			ref += " <" + name + ">"
+		}
+		comments = append(comments, ref)
+	}
+	if inst.DelaySlot {
+		comments = append(comments, "delay slot")
+	}
+	return text, strings.Join(comments, "; ")
+}
+
+// Fprint writes insts to w, preceded by the name of each address that has
+// one.
+func (p *Printer) Fprint(w io.Writer, insts []*Inst) error {
+	bw := bufio.NewWriter(w)
+	for _, inst := range insts {
+		if name, ok := p.symbols[inst.Addr]; ok {
+			fmt.Fprintf(bw, "\n%08x <%s>:\n", inst.Addr, name)
+		} else if name, ok := p.labels[inst.Addr]; ok && p.procedures[inst.Addr] {
+			fmt.Fprintf(bw, "\n%08x <%s>:\n", inst.Addr, name)
+		} else if ok {
+			fmt.Fprintf(bw, "%s:\n", name)
+		}
+		text, comment := p.Format(inst)
+		line := fmt.Sprintf("%8x:  %08x  %s", inst.Addr, inst.Word, text)
+		if comment != "" {
+			line = fmt.Sprintf("%-*s# %s", commentColumn, line, comment)
+		}
+		fmt.Fprintln(bw, line)
+	}
+	return bw.Flush()
+}
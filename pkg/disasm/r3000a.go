@@ -0,0 +1,260 @@
+package disasm
+
+import (
+	"fmt"
+
+	"github.com/mewmew/mips"
+)
+
+// The Playstation CPU is an R3000A with the System Control Coprocessor (COP0)
+// and the Geometry Transformation Engine (GTE) as COP2. There is no floating
+// point unit (COP1) or COP3, so their instructions are invalid, as are
+// loads and stores of COP0 registers. The mips package decodes coprocessor
+// instructions for a generic MIPS I, so they are decoded here instead.
+
+// COP0Registers are the names of the COP0 registers that exist on the
+// Playstation.
+var COP0Registers = [32]string{
+	3:  "$bpc",
+	5:  "$bda",
+	6:  "$tar",
+	7:  "$dcic",
+	8:  "$badvaddr",
+	9:  "$bdam",
+	11: "$bpcm",
+	12: "$sr",
+	13: "$cause",
+	14: "$epc",
+	15: "$prid",
+}
+
+// GTEDataRegisters are the names of the GTE data registers, as moved with
+// mfc2/mtc2 and lwc2/swc2.
+var GTEDataRegisters = [32]string{
+	"$vxy0", "$vz0", "$vxy1", "$vz1", "$vxy2", "$vz2", "$rgbc", "$otz",
+	"$ir0", "$ir1", "$ir2", "$ir3", "$sxy0", "$sxy1", "$sxy2", "$sxyp",
+	"$sz0", "$sz1", "$sz2", "$sz3", "$rgb0", "$rgb1", "$rgb2", "$res1",
+	"$mac0", "$mac1", "$mac2", "$mac3", "$irgb", "$orgb", "$lzcs", "$lzcr",
+}
+
+// GTEControlRegisters are the names of the GTE control registers, as moved
+// with cfc2/ctc2.
+var GTEControlRegisters = [32]string{
+	"$rt11rt12", "$rt13rt21", "$rt22rt23", "$rt31rt32", "$rt33", "$trx", "$try", "$trz",
+	"$l11l12", "$l13l21", "$l22l23", "$l31l32", "$l33", "$rbk", "$gbk", "$bbk",
+	"$lr1lr2", "$lr3lg1", "$lg2lg3", "$lb1lb2", "$lb3", "$rfc", "$gfc", "$bfc",
+	"$ofx", "$ofy", "$h", "$dqa", "$dqb", "$zsf3", "$zsf4", "$flag",
+}
+
+// A gteCommand describes a GTE command by its function number.
+type gteCommand struct {
+	name string
+
+	// fields is set for commands that use the sf and lm fields.
+	fields bool
+}
+
+var gteCommands = map[uint32]gteCommand{
+	0x01: {"rtps", true},
+	0x06: {"nclip", false},
+	0x0c: {"op", true},
+	0x10: {"dpcs", true},
+	0x11: {"intpl", true},
+	0x12: {"mvmva", true},
+	0x13: {"ncds", true},
+	0x14: {"cdp", true},
+	0x16: {"ncdt", true},
+	0x1b: {"nccs", true},
+	0x1c: {"cc", true},
+	0x1e: {"ncs", true},
+	0x20: {"nct", true},
+	0x28: {"sqr", true},
+	0x29: {"dcpl", true},
+	0x2a: {"dpct", true},
+	0x2d: {"avsz3", false},
+	0x2e: {"avsz4", false},
+	0x30: {"rtpt", true},
+	0x3d: {"gpf", true},
+	0x3e: {"gpl", true},
+	0x3f: {"ncct", true},
+}
+
+// The operands of mvmva, which multiplies a vector by a matrix and adds a
+// translation vector, by the value of their fields.
+var (
+	MVMVAMatrices     = [4]string{"rt", "ll", "lc", "mx3"}
+	MVMVAVectors      = [4]string{"v0", "v1", "v2", "ir"}
+	MVMVATranslations = [4]string{"tr", "bk", "fc", "none"}
+)
+
+// decodeR3000A decodes the coprocessor instructions of the Playstation into
+// inst, reporting whether the word was one. Instructions whose operands the
+// mips package can't name are given a Mnemonic and Operands.
+func decodeR3000A(inst *Inst) bool {
+	word := inst.Word
+	op := word >> 26
+	rs := word >> 21 & 0x1f
+	rt := word >> 16 & 0x1f
+	rd := word >> 11 & 0x1f
+	switch op {
+	case 0x11, 0x13, 0x30, 0x31, 0x33, 0x38, 0x39, 0x3b:
+		// COP1, COP3 and loads and stores of any coprocessor but the GTE
+		return true
+	case 0x32, 0x3a:
+		return decodeGTETransfer(inst, rt)
+	case 0x10, 0x12:
+	default:
+		return false
+	}
+
+	// The mips package numbers the operations of each coprocessor in turn,
+	// e.g. mfc0, mfc1, mfc2.
+	gte := op == 0x12
+	z := mips.Op(op - 0x10)
+	if rs&0x10 != 0 {
+		if gte {
+			return decodeGTECommand(inst)
+		}
+		if word&0x1ffffff == 0x10 {
+			inst.Inst = mips.Inst{Op: mips.RFE, Enc: word}
+		}
+		return true
+	}
+
+	var o mips.Op
+	var name string
+	switch rs {
+	case 0x00, 0x04:
+		o = mips.MFC0
+		if rs == 0x04 {
+			o = mips.MTC0
+		}
+		name = COP0Registers[rd]
+		if gte {
+			name = GTEDataRegisters[rd]
+		}
+	case 0x02, 0x06:
+		// COP0 has no control registers
+		o = mips.CFC0
+		if rs == 0x06 {
+			o = mips.CTC0
+		}
+		if gte {
+			name = GTEControlRegisters[rd]
+		}
+	case 0x08:
+		o = mips.BC0F
+		if rt == 1 {
+			o = mips.BC0T
+		} else if rt != 0 {
+			return true
+		}
+		inst.Inst = mips.Inst{
+			Op:   o + z,
+			Enc:  word,
+			Args: mips.Args{mips.PCRel(int32(int16(word)) << 2)},
+		}
+		return true
+	}
+	if name == "" {
+		return true
+	}
+	inst.Inst = mips.Inst{
+		Op:   o + z,
+		Enc:  word,
+		Args: mips.Args{mips.Reg(rt)},
+	}
+	inst.Mnemonic = inst.Op.String()
+	inst.Operands = []string{mips.Reg(rt).String(), name}
+	return true
+}
+
+// decodeGTETransfer decodes lwc2 and swc2, which load and store GTE data
+// registers.
+func decodeGTETransfer(inst *Inst, rt uint32) bool {
+	inst.Inst = decode(inst.Word)
+	if !inst.Valid() {
+		return true
+	}
+	inst.Mnemonic = inst.Op.String()
+	inst.Operands = []string{GTEDataRegisters[rt]}
+	for _, arg := range inst.Args[1:] {
+		if arg != nil {
+			inst.Operands = append(inst.Operands, arg.String())
+		}
+	}
+	return true
+}
+
+// decodeGTECommand decodes a GTE command, naming the fields it uses: sf
+// shifts results right by 12 bits and lm limits them to be positive, while
+// mvmva also selects the matrix (mx), vector (v) and translation (cv).
+func decodeGTECommand(inst *Inst) bool {
+	word := inst.Word
+	cmd, ok := gteCommands[word&0x3f]
+	if !ok {
+		return true
+	}
+	inst.Inst = mips.Inst{Op: mips.COP2, Enc: word, Args: mips.Args{mips.Imm{Imm: word & 0x1ffffff}}}
+	inst.Mnemonic = cmd.name
+	inst.Operands = nil
+	if cmd.name == "mvmva" {
+		inst.Operands = append(inst.Operands,
+			MVMVAMatrices[word>>17&3],
+			MVMVAVectors[word>>15&3],
+			MVMVATranslations[word>>13&3],
+		)
+	}
+	if cmd.fields {
+		inst.Operands = append(inst.Operands,
+			fmt.Sprintf("sf=%d", word>>19&1),
+			fmt.Sprintf("lm=%d", word>>10&1),
+		)
+	}
+	return true
+}
+
+// unusedFieldsSet reports whether the fields of word that aren't used by its
+// instruction are non-zero. The R3000A ignores them, as does the mips package,
+// but no assembler sets them, so such words are almost certainly data.
+func unusedFieldsSet(word uint32) bool {
+	rs := word >> 21 & 0x1f
+	rt := word >> 16 & 0x1f
+	rd := word >> 11 & 0x1f
+	sa := word >> 6 & 0x1f
+	switch op := word >> 26; op {
+	case 0x00:
+		switch funct := word & 0x3f; funct {
+		case 0x00, 0x02, 0x03:
+			// sll, srl, sra
+			return rs != 0
+		case 0x08:
+			// jr
+			return rt != 0 || rd != 0 || sa != 0
+		case 0x09:
+			// jalr
+			return rt != 0 || sa != 0
+		case 0x0c, 0x0d:
+			// syscall, break
+			return false
+		case 0x10, 0x12:
+			// mfhi, mflo
+			return rs != 0 || rt != 0 || sa != 0
+		case 0x11, 0x13:
+			// mthi, mtlo
+			return rt != 0 || rd != 0 || sa != 0
+		case 0x18, 0x19, 0x1a, 0x1b:
+			// mult, multu, div, divu
+			return rd != 0 || sa != 0
+		default:
+			return sa != 0
+		}
+	case 0x06, 0x07:
+		// blez, bgtz
+		return rt != 0
+	case 0x0f:
+		// lui
+		return rs != 0
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Package ar implements access to Unix ar archives, which hold libraries of
+// object files such as the ECOFF objects of the Net Yaroze library.
+package ar
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Magic is the signature at the start of every archive.
+var Magic = [8]byte{'!', '<', 'a', 'r', 'c', 'h', '>', '\n'}
+
+// headerSize is the size of the header preceding each member.
+const headerSize = 60
+
+// A Member is a file stored in an archive.
+type Member struct {
+	Name string
+	Data []byte
+}
+
+// An Archive is the list of members of an ar archive, in the order they are
+// stored. The symbol tables and long name tables that archivers add are not
+// included.
+type Archive struct {
+	Members []*Member
+}
+
+// Open reads the named archive.
+func Open(name string) (*Archive, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads an archive from data. Both the GNU and BSD conventions for long
+// member names are understood.
+func Parse(data []byte) (*Archive, error) {
+	if !bytes.HasPrefix(data, Magic[:]) {
+		return nil, errors.New("file magic invalid")
+	}
+	a := &Archive{}
+	var names []byte
+	for off := len(Magic); off < len(data); {
+		if off+headerSize > len(data) {
+			return nil, errors.Errorf("truncated member header at offset %d", off)
+		}
+		hdr := data[off : off+headerSize]
+		if string(hdr[58:60]) != "`\n" {
+			return nil, errors.Errorf("invalid member header at offset %d", off)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 || int64(off+headerSize)+size > int64(len(data)) {
+			return nil, errors.Errorf("invalid member size at offset %d", off)
+		}
+		body := data[off+headerSize : off+headerSize+int(size)]
+		off += headerSize + int(size) + int(size)&1
+
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		switch {
+		case name == "/" || name == "/SYM64/" || strings.HasPrefix(name, "__.SYMDEF"):
+			// symbol table
+			continue
+		case name == "//":
+			names = body
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD: the name precedes the data
+			n, err := strconv.Atoi(name[3:])
+			if err != nil || n > len(body) {
+				return nil, errors.Errorf("invalid member name %q", name)
+			}
+			name, body = strings.TrimRight(string(body[:n]), "\x00"), body[n:]
+		case strings.HasPrefix(name, "/"):
+			// GNU: the name is in the long name table
+			i, err := strconv.Atoi(name[1:])
+			if err != nil || i > len(names) {
+				return nil, errors.Errorf("invalid member name %q", name)
+			}
+			name = string(names[i:])
+			if j := strings.Index(name, "/\n"); j >= 0 {
+				name = name[:j]
+			}
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+		a.Members = append(a.Members, &Member{Name: name, Data: body})
+	}
+	return a, nil
+}
+
+// Open returns a reader for the contents of the member.
+func (m *Member) Open() io.ReaderAt {
+	return bytes.NewReader(m.Data)
+}
+
+// IsArchive reports whether the named file is an ar archive.
+func IsArchive(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [8]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return magic == Magic
+}
@@ -0,0 +1,68 @@
+package ar
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	a, err := Open(filepath.Join("testdata", "libtest.a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		name string
+		size int
+	}{
+		{"puts.o", 756},
+		{"video.o", 764},
+	}
+	if len(a.Members) != len(expected) {
+		t.Fatalf("expected %d members, received %d", len(expected), len(a.Members))
+	}
+	for i, m := range a.Members {
+		if m.Name != expected[i].name || len(m.Data) != expected[i].size {
+			t.Errorf("expected %s (%d bytes), received %s (%d bytes)", expected[i].name, expected[i].size, m.Name, len(m.Data))
+		}
+	}
+	if !IsArchive(filepath.Join("testdata", "libtest.a")) {
+		t.Errorf("expected libtest.a to be an archive")
+	}
+}
+
+func TestParseLongNames(t *testing.T) {
+	header := func(name string, size int) string {
+		return padRight(name, 16) + padRight("0", 12) + padRight("0", 6) + padRight("0", 6) + padRight("644", 8) + padRight(strconv.Itoa(size), 10) + "`\n"
+	}
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	b.WriteString(header("//", 22) + "a_long_member_name.o/\n")
+	b.WriteString(header("/0", 3) + "abc\n")
+	b.WriteString(header("#1/8", 10) + "bsd.o\x00\x00\x00xy")
+	a, err := Parse(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Members) != 2 {
+		t.Fatalf("expected 2 members, received %d", len(a.Members))
+	}
+	if m := a.Members[0]; m.Name != "a_long_member_name.o" || string(m.Data) != "abc" {
+		t.Errorf("unexpected member %q %q", m.Name, m.Data)
+	}
+	if m := a.Members[1]; m.Name != "bsd.o" || string(m.Data) != "xy" {
+		t.Errorf("unexpected member %q %q", m.Name, m.Data)
+	}
+
+	if _, err := Parse([]byte("!<arch>\nshort")); err == nil {
+		t.Errorf("expected error for truncated archive")
+	}
+}
+
+func padRight(s string, n int) string {
+	for len(s) < n {
+		s += " "
+	}
+	return s
+}
@@ -41,6 +41,24 @@ const (
 	F_NODF   = 0002000
 )
 
+// Section header flags, describing the contents of a section.
+const (
+	STYP_REG    = 0x00000000 /* regular section */
+	STYP_TEXT   = 0x00000020 /* text only */
+	STYP_DATA   = 0x00000040 /* data only */
+	STYP_BSS    = 0x00000080 /* bss only */
+	STYP_RDATA  = 0x00000100 /* read only data */
+	STYP_SDATA  = 0x00000200 /* small data */
+	STYP_SBSS   = 0x00000400 /* small bss */
+	STYP_UCODE  = 0x00000800 /* ucode */
+	STYP_LIT8   = 0x08000000 /* literal pool for 8 byte literals */
+	STYP_LIT4   = 0x10000000 /* literal pool for 4 byte literals */
+	STYP_INIT   = 0x80000000 /* section initialization text */
+	STYP_FINI   = 0x01000000 /* .fini section text */
+	STYP_LIB    = 0x40000000 /* shared library */
+	S_NRELOC_OV = 0x20000000 /* s_nreloc overflowed */
+)
+
 type SymbolType uint32
 
 const (
@@ -1,11 +1,13 @@
 package ecoff
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 )
 
 // A FileHeader represents an ECOFF file header.
@@ -44,6 +46,8 @@ type File struct {
 	ExternalSymbols []*ExternalSymbol
 	LocalSymbols    []*Symbol
 	Sections        []*Section
+	SourceFiles     []*SourceFile
+	Procedures      []*Procedure
 
 	byteOrder binary.ByteOrder
 	closer    io.Closer
@@ -66,6 +70,7 @@ type SectionHeader struct {
 // A Section represents a single section in an ECOFF file.
 type Section struct {
 	SectionHeader
+	Relocations []*Relocation
 
 	io.ReaderAt
 	sr *io.SectionReader
@@ -83,6 +88,20 @@ func (s *Section) Open() io.ReadSeeker {
 	return io.NewSectionReader(s.sr, 0, 1<<63-1)
 }
 
+// SectionName returns the name of the section, which is terminated by a NUL
+// byte if shorter than 8 bytes.
+func (s *Section) SectionName() string {
+	if i := bytes.IndexByte(s.Name[:], 0); i >= 0 {
+		return string(s.Name[:i])
+	}
+	return string(s.Name[:])
+}
+
+// Executable reports whether the section holds code.
+func (s *Section) Executable() bool {
+	return uint32(s.Flags)&(STYP_TEXT|STYP_INIT|STYP_FINI) != 0
+}
+
 func (s *Section) String() string {
 	return fmt.Sprintf("%-10s len=%-4d offset=%-4d 0x%08X 0x%08X", s.Name, s.Size, s.Offset, s.PhysicalAddress, s.VirtualAddress)
 }
@@ -139,6 +158,11 @@ func NewFile(r io.ReaderAt) (*File, error) {
 		s.ReaderAt = s.sr
 		f.Sections = append(f.Sections, s)
 	}
+	for _, s := range f.Sections {
+		if err := f.readRelocations(sr, s); err != nil {
+			return nil, err
+		}
+	}
 
 	// Read symbolic headers
 	sr.Seek(int64(f.FileHeader.SymbolicHeaderOffset), os.SEEK_SET)
@@ -148,15 +172,29 @@ func NewFile(r io.ReaderAt) (*File, error) {
 	}
 
 	sr.Seek(int64(shdr.ProceduresOffset), os.SEEK_SET)
+	pds := make([]*ProcedureDescriptor32, 0)
 	for i := 0; i < int(shdr.ProceduresCount); i++ {
 		// NOTE: Support only planned for 32-bit files.
 		pd := new(ProcedureDescriptor32)
 		if err := binary.Read(sr, f.byteOrder, pd); err != nil {
 			return nil, err
 		}
+		pds = append(pds, pd)
+	}
+
+	// File descriptors only describe the source files, so the file can
+	// still be used if they can't be read.
+	sr.Seek(int64(shdr.FileDescriptorOffset), os.SEEK_SET)
+	for i := 0; i < int(shdr.FileDescriptorLength); i++ {
+		fd := new(SourceFile)
+		if err := binary.Read(sr, f.byteOrder, &fd.FileDescriptor); err != nil {
+			f.SourceFiles = nil
+			break
+		}
+		f.SourceFiles = append(f.SourceFiles, fd)
 	}
 
-	// TODO: read additional headers, such as FileDescriptor
+	// TODO: read additional headers, such as RelativeFileDescriptor
 
 	// Parse local strings
 	ls := make([]byte, shdr.LocalStringsLength)
@@ -189,6 +227,10 @@ func NewFile(r io.ReaderAt) (*File, error) {
 		sym.Name, _ = getString(ls, int(sym.Index))
 		f.LocalSymbols = append(f.LocalSymbols, sym)
 	}
+	for _, fd := range f.SourceFiles {
+		fd.Name, _ = getString(ls, int(fd.StringsOffset+fd.FileName))
+		f.Procedures = append(f.Procedures, f.procedures(fd, pds)...)
+	}
 
 	// Parse external strings
 	es := make([]byte, shdr.ExternalStringsLength)
@@ -229,9 +271,64 @@ func NewFile(r io.ReaderAt) (*File, error) {
 		f.ExternalSymbols = append(f.ExternalSymbols, sym)
 	}
 
+	// Name the symbols and sections referred to by relocations
+	for _, s := range f.Sections {
+		for _, rel := range s.Relocations {
+			switch {
+			case rel.External && int(rel.Index) < len(f.ExternalSymbols):
+				rel.Symbol = f.ExternalSymbols[rel.Index].Name
+			case !rel.External && int(rel.Index) < len(relocationSections):
+				rel.Symbol = relocationSections[rel.Index]
+			}
+		}
+	}
+
 	return f, nil
 }
 
+// procedures returns the procedures of the source file, which are described by
+// a range of pds and named by its local symbols.
+func (f *File) procedures(fd *SourceFile, pds []*ProcedureDescriptor32) []*Procedure {
+	start, end := int(fd.ProceduresOffset), int(fd.ProceduresOffset)+int(fd.ProceduresCount)
+	if start > len(pds) || end > len(pds) || start > end {
+		return nil
+	}
+	procedures := make([]*Procedure, 0)
+	for _, pd := range pds[start:end] {
+		p := &Procedure{
+			ProcedureDescriptor32: *pd,
+			Addr:                  uint32(fd.Address + pd.Address),
+			File:                  fd,
+		}
+		i := int(fd.SymbolsOffset + pd.LocalSymbolsOffset)
+		if i >= 0 && i < len(f.LocalSymbols) {
+			p.Name = f.LocalSymbols[i].Name
+			p.Size = f.procedureSize(i)
+		}
+		procedures = append(procedures, p)
+	}
+	return procedures
+}
+
+// procedureSize returns the size of the procedure whose symbol is the local
+// symbol i, which is the value of the ST_END symbol closing it. Blocks within
+// the procedure are closed by ST_END symbols of their own.
+func (f *File) procedureSize(i int) uint32 {
+	depth := 0
+	for _, s := range f.LocalSymbols[i+1:] {
+		switch s.Type {
+		case ST_BLOCK, ST_PROC, ST_STATIC_PROC, ST_FILE:
+			depth++
+		case ST_END:
+			if depth == 0 {
+				return s.Value
+			}
+			depth--
+		}
+	}
+	return 0
+}
+
 func (f *File) Close() error {
 	var err error
 	if f.closer != nil {
@@ -260,21 +357,25 @@ func (f *File) Size() uint32 {
 	return uint32(n)
 }
 
-func (f *File) String() string {
-	var name string
+// arch returns the name of the architecture and byte order given by the file
+// magic.
+func (f *File) arch() string {
 	switch f.FileHeader.Magic {
 	case MIPSBE_MAGIC:
-		name = "MIPSBE ECOFF"
+		return "MIPSBE"
 	case MIPSEL_BE_MAGIC:
-		name = "MIPSEL-BE ECOFF"
+		return "MIPSEL-BE"
 	case MIPSEL_MAGIC:
-		name = "MIPSEL ECOFF"
+		return "MIPSEL"
 	case MIPSBE_EL_MAGIC:
-		name = "MIPSBE-EL ECOFF"
+		return "MIPSBE-EL"
 	default:
 		panic("invalid file magic")
 	}
-	return fmt.Sprintf("%s executable - start=0x%08X size=%d sections=%d", name, f.Entry, f.Size(), len(f.Sections))
+}
+
+func (f *File) String() string {
+	return fmt.Sprintf("%s ECOFF executable - start=0x%08X size=%d sections=%d", f.arch(), f.Entry, f.Size(), len(f.Sections))
 }
 
 // Symbols returns a slice of Symbols from the combined local and external
@@ -302,6 +403,60 @@ func (f *File) SymbolsByType(st SymbolType) map[uint32]*Symbol {
 	return symbols
 }
 
+// A SymbolTable is a list of symbols sorted by address.
+type SymbolTable []*Symbol
+
+// TextSymbols returns the procedures and global text symbols of the file,
+// sorted by address with a single symbol for each address. Procedures are
+// preferred over other symbols at the same address.
+func (f *File) TextSymbols() SymbolTable {
+	rank := func(s *Symbol) int {
+		switch s.Type {
+		case ST_PROC, ST_STATIC_PROC:
+			return 0
+		case ST_GLOBAL:
+			switch StorageClass(s.StorageClass) {
+			case SC_TEXT, SC_ABS:
+				return 1
+			}
+		}
+		return -1
+	}
+	symbols := make(SymbolTable, 0)
+	for _, s := range f.Symbols() {
+		if rank(s) >= 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		if symbols[i].Value != symbols[j].Value {
+			return symbols[i].Value < symbols[j].Value
+		}
+		return rank(symbols[i]) < rank(symbols[j])
+	})
+	n := 0
+	for i, s := range symbols {
+		if i > 0 && s.Value == symbols[n-1].Value {
+			continue
+		}
+		symbols[n] = s
+		n++
+	}
+	return symbols[:n]
+}
+
+// Lookup returns the symbol closest to, but not after, addr and the offset of
+// addr from it. If there is no such symbol it returns nil.
+func (t SymbolTable) Lookup(addr uint32) (*Symbol, uint32) {
+	i := sort.Search(len(t), func(i int) bool {
+		return t[i].Value > addr
+	})
+	if i == 0 {
+		return nil, 0
+	}
+	return t[i-1], addr - t[i-1].Value
+}
+
 // getString extracts a string from an ECOFF string table.
 func getString(section []byte, start int) (string, bool) {
 	if start < 0 || start >= len(section) {
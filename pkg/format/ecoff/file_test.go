@@ -2,6 +2,7 @@ package ecoff
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -20,3 +21,174 @@ func TestEcoffParseFile(t *testing.T) {
 	}
 	fmt.Printf("%+v\n", f)
 }
+
+func TestTextSymbols(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "main-ecoff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	symbols := f.TextSymbols()
+	cases := []struct {
+		addr   uint32
+		name   string
+		offset uint32
+	}{
+		{0x801401C0, "main", 0},
+		{0x801401F4, "main", 0x34},
+		{0x80140AB8, "SetVideoMode", 8},
+		{0x80043794, "putchar", 4},
+	}
+	for _, c := range cases {
+		s, offset := symbols.Lookup(c.addr)
+		if s == nil || s.Name != c.name || offset != c.offset {
+			t.Errorf("0x%08X: expected %s+0x%x, received %v+0x%x", c.addr, c.name, c.offset, s, offset)
+		}
+	}
+	if s, _ := symbols.Lookup(0x80000000); s != nil {
+		t.Errorf("expected no symbol before the first, received %s", s.Name)
+	}
+}
+
+func TestSections(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "puts.o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	expected := []struct {
+		name       string
+		executable bool
+	}{
+		{".text", true},
+		{".rdata", false},
+	}
+	if len(f.Sections) != len(expected) {
+		t.Fatalf("expected %d sections, received %d", len(expected), len(f.Sections))
+	}
+	for i, s := range f.Sections {
+		if s.SectionName() != expected[i].name || s.Executable() != expected[i].executable {
+			t.Errorf("section %d: expected %s (executable %v), received %q (executable %v)", i, expected[i].name, expected[i].executable, s.SectionName(), s.Executable())
+		}
+	}
+}
+
+func TestFileDescriptors(t *testing.T) {
+	if n := binary.Size(FileDescriptor{}); n != 72 {
+		t.Fatalf("expected file descriptors of 72 bytes, received %d", n)
+	}
+	f, err := Open(filepath.Join("testdata", "main-ecoff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if len(f.SourceFiles) != 12 {
+		t.Fatalf("expected 12 source files, received %d", len(f.SourceFiles))
+	}
+	cases := []struct {
+		index      int
+		name       string
+		addr       uint32
+		procedures uint16
+		count      int16
+		symbols    int32
+	}{
+		{0, "startup.s", 0x80140000, 0, 0, 0},
+		{1, "main.c", 0x801401C0, 0, 10, 10},
+		{2, "stdef1.s", 0x80140AB0, 10, 0, 348},
+		{11, "video.c", 0x80140AB0, 10, 1, 366},
+	}
+	for _, c := range cases {
+		fd := f.SourceFiles[c.index]
+		if fd.Name != c.name || uint32(fd.Address) != c.addr || fd.ProceduresOffset != c.procedures || fd.ProceduresCount != c.count || fd.SymbolsOffset != c.symbols {
+			t.Errorf("%d: expected %s at 0x%08X with procedures %d+%d and symbols from %d, received %s at 0x%08X with procedures %d+%d and symbols from %d",
+				c.index, c.name, c.addr, c.procedures, c.count, c.symbols,
+				fd.Name, uint32(fd.Address), fd.ProceduresOffset, fd.ProceduresCount, fd.SymbolsOffset)
+		}
+	}
+}
+
+func TestProcedures(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "main-ecoff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if len(f.SourceFiles) != 12 {
+		t.Fatalf("expected 12 source files, received %d", len(f.SourceFiles))
+	}
+	if name := f.SourceFiles[1].Name; name != "main.c" {
+		t.Errorf("expected source file main.c, received %s", name)
+	}
+	cases := []struct {
+		name string
+		addr uint32
+		size uint32
+		file string
+	}{
+		{"main", 0x801401C0, 0x394, "main.c"},
+		{"init_prim", 0x80140554, 0x150, "main.c"},
+		{"stop_sound", 0x80140A58, 0x4C, "main.c"},
+		{"SetVideoMode", 0x80140AB0, 0x38, "video.c"},
+	}
+	if len(f.Procedures) != 11 {
+		t.Fatalf("expected 11 procedures, received %d", len(f.Procedures))
+	}
+	for _, c := range cases {
+		found := false
+		for _, p := range f.Procedures {
+			if p.Name != c.name {
+				continue
+			}
+			found = true
+			if p.Addr != c.addr || p.Size != c.size || p.File.Name != c.file {
+				t.Errorf("%s: expected 0x%08X size 0x%X in %s, received 0x%08X size 0x%X in %s", c.name, c.addr, c.size, c.file, p.Addr, p.Size, p.File.Name)
+			}
+		}
+		if !found {
+			t.Errorf("procedure %s not found", c.name)
+		}
+	}
+}
+
+func TestRelocations(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "puts.o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	expected := []Relocation{
+		{Addr: 0x14, Index: 2, Type: R_REFHI, Symbol: ".rdata"},
+		{Addr: 0x1c, Index: 2, Type: R_REFLO, Symbol: ".rdata"},
+		{Addr: 0x18, Index: 1, Type: R_JMPADDR, Symbol: ".text"},
+		{Addr: 0x20, Index: 6, Type: R_JMPADDR, External: true, Symbol: "putchar"},
+	}
+	relocs := f.Sections[0].Relocations
+	if len(relocs) != len(expected) {
+		t.Fatalf("expected %d relocations, received %d", len(expected), len(relocs))
+	}
+	for i, r := range relocs {
+		if *r != expected[i] {
+			t.Errorf("expected %v, received %v", &expected[i], r)
+		}
+	}
+}
+
+func TestRelocationsLittleEndian(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "video.o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	expected := Relocation{Addr: 0x1c, Index: 3, Type: R_JMPADDR, External: true, Symbol: "SsSetTickMode"}
+	relocs := f.Sections[0].Relocations
+	if len(relocs) != 1 || *relocs[0] != expected {
+		t.Fatalf("expected %v, received %v", &expected, relocs)
+	}
+}
@@ -0,0 +1,115 @@
+package ecoff
+
+import (
+	"encoding/json"
+)
+
+// The types below define the schema of an ECOFF file encoded as JSON, which
+// is kept stable for scripts that consume it. Fields are only ever added.
+
+type jsonFile struct {
+	Arch     string         `json:"arch"`
+	Header   jsonHeader     `json:"header"`
+	Sections []*jsonSection `json:"sections"`
+	Symbols  []*jsonSymbol  `json:"symbols"`
+}
+
+type jsonHeader struct {
+	Flags     uint16 `json:"flags"`
+	Timestamp int32  `json:"timestamp"`
+	Version   string `json:"version"`
+	Entry     uint32 `json:"entry"`
+	TextStart uint32 `json:"text_start"`
+	TextSize  int32  `json:"text_size"`
+	DataStart uint32 `json:"data_start"`
+	DataSize  int32  `json:"data_size"`
+	BssStart  uint32 `json:"bss_start"`
+	BssSize   int32  `json:"bss_size"`
+	GprMask   uint32 `json:"gpr_mask"`
+	GpValue   uint32 `json:"gp_value"`
+}
+
+type jsonSection struct {
+	Name        string            `json:"name"`
+	Addr        uint32            `json:"addr"`
+	Size        int32             `json:"size"`
+	Offset      uint32            `json:"offset"`
+	Flags       uint32            `json:"flags"`
+	Relocations []*jsonRelocation `json:"relocations"`
+}
+
+type jsonRelocation struct {
+	Addr     uint32         `json:"addr"`
+	Type     RelocationType `json:"type"`
+	Symbol   string         `json:"symbol"`
+	External bool           `json:"external"`
+}
+
+type jsonSymbol struct {
+	Name         string     `json:"name"`
+	Value        uint32     `json:"value"`
+	Type         SymbolType `json:"type"`
+	StorageClass uint32     `json:"storage_class"`
+	Index        uint32     `json:"index"`
+	External     bool       `json:"external"`
+}
+
+// MarshalJSON encodes the headers, sections, relocations and symbols of the
+// file. Symbols are listed as in the symbol tables, external symbols first.
+func (f *File) MarshalJSON() ([]byte, error) {
+	out := &jsonFile{
+		Arch: f.arch(),
+		Header: jsonHeader{
+			Flags:     f.FileHeader.Flags,
+			Timestamp: f.Timestamp,
+			Version:   Version(f.Vstamp).String(),
+			Entry:     f.Entry,
+			TextStart: f.TextStart,
+			TextSize:  f.TextSize,
+			DataStart: f.DataStart,
+			DataSize:  f.DataSize,
+			BssStart:  f.BssStart,
+			BssSize:   f.BssSize,
+			GprMask:   f.GprMask,
+			GpValue:   f.GpValue,
+		},
+		Sections: make([]*jsonSection, 0, len(f.Sections)),
+		Symbols:  make([]*jsonSymbol, 0, len(f.ExternalSymbols)+len(f.LocalSymbols)),
+	}
+	for _, s := range f.Sections {
+		js := &jsonSection{
+			Name:        s.SectionName(),
+			Addr:        s.VirtualAddress,
+			Size:        s.Size,
+			Offset:      s.Offset,
+			Flags:       uint32(s.Flags),
+			Relocations: make([]*jsonRelocation, 0, len(s.Relocations)),
+		}
+		for _, r := range s.Relocations {
+			js.Relocations = append(js.Relocations, &jsonRelocation{
+				Addr:     r.Addr,
+				Type:     r.Type,
+				Symbol:   r.Symbol,
+				External: r.External,
+			})
+		}
+		out.Sections = append(out.Sections, js)
+	}
+	symbol := func(s *Symbol, external bool) *jsonSymbol {
+		return &jsonSymbol{
+			Name:         s.Name,
+			Value:        s.Value,
+			Type:         s.Type,
+			StorageClass: s.StorageClass,
+			Index:        s.SectionIndex,
+			External:     external,
+		}
+	}
+	for _, s := range f.ExternalSymbols {
+		out.Symbols = append(out.Symbols, symbol(&s.Symbol, true))
+	}
+	for _, s := range f.LocalSymbols {
+		out.Symbols = append(out.Symbols, symbol(s, false))
+	}
+	return json.Marshal(out)
+}
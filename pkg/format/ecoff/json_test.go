@@ -0,0 +1,91 @@
+package ecoff
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden JSON files in testdata")
+
+func TestMarshalJSON(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "puts.o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Arch   string
+		Header struct {
+			Entry uint32
+		}
+		Sections []struct {
+			Name        string
+			Relocations []struct {
+				Type   string
+				Symbol string
+			}
+		}
+		Symbols []struct {
+			Name     string
+			External bool
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Arch != "MIPSEL-BE" {
+		t.Errorf("expected arch MIPSEL-BE, received %s", out.Arch)
+	}
+	if len(out.Sections) != 2 || out.Sections[0].Name != ".text" || len(out.Sections[0].Relocations) != 4 {
+		t.Fatalf("expected .text and .rdata with 4 relocations, received %s", data)
+	}
+	if r := out.Sections[0].Relocations[3]; r.Type != "R_JMPADDR" || r.Symbol != "putchar" {
+		t.Errorf("expected R_JMPADDR putchar, received %s %s", r.Type, r.Symbol)
+	}
+	if n := len(f.ExternalSymbols) + len(f.LocalSymbols); len(out.Symbols) != n {
+		t.Fatalf("expected %d symbols, received %d", n, len(out.Symbols))
+	}
+	if s := out.Symbols[3]; s.Name != "puts" || !s.External {
+		t.Errorf("expected external symbol puts, received %+v", s)
+	}
+}
+
+func TestMarshalJSONGolden(t *testing.T) {
+	for _, name := range []string{"puts.o", "video.o", "main-ecoff"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := Open(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			data, err := json.MarshalIndent(f, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, '\n')
+			golden := filepath.Join("testdata", name+".json")
+			if *update {
+				if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, expected) {
+				t.Errorf("JSON differs from %s, run go test -update if the change is intended", golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package ecoff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A RelocationType describes how an address is stored in the word being
+// relocated.
+type RelocationType uint8
+
+const (
+	R_IGNORE  RelocationType = 0 /* ignore */
+	R_REFHALF RelocationType = 1 /* 16-bit address */
+	R_REFWORD RelocationType = 2 /* 32-bit address */
+	R_JMPADDR RelocationType = 3 /* 26-bit jump target */
+	R_REFHI   RelocationType = 4 /* high 16 bits, adjusted for R_REFLO */
+	R_REFLO   RelocationType = 5 /* low 16 bits */
+	R_GPREL   RelocationType = 6 /* 16-bit offset from the global pointer */
+	R_LITERAL RelocationType = 7 /* gp-relative reference to a literal pool */
+)
+
+func (t RelocationType) String() string {
+	switch t {
+	case R_IGNORE:
+		return "R_IGNORE"
+	case R_REFHALF:
+		return "R_REFHALF"
+	case R_REFWORD:
+		return "R_REFWORD"
+	case R_JMPADDR:
+		return "R_JMPADDR"
+	case R_REFHI:
+		return "R_REFHI"
+	case R_REFLO:
+		return "R_REFLO"
+	case R_GPREL:
+		return "R_GPREL"
+	case R_LITERAL:
+		return "R_LITERAL"
+	default:
+		return fmt.Sprintf("R_%d", uint8(t))
+	}
+}
+
+// MarshalText encodes the type by name.
+func (t RelocationType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// relocationSections are the sections that relocations that aren't external
+// refer to, by index.
+var relocationSections = []string{
+	1: S_TEXT,
+	2: S_RDATA,
+	3: S_DATA,
+	4: S_SDATA,
+	5: S_SBSS,
+	6: S_BSS,
+	7: S_INIT,
+	8: S_LIT8,
+	9: S_LIT4,
+}
+
+// A Relocation is an entry in the relocation table of a section, describing a
+// word at Addr that refers to an external symbol or to another section.
+type Relocation struct {
+	Addr     uint32
+	Index    uint32
+	Type     RelocationType
+	External bool
+
+	// Symbol is the name of the external symbol or section referred to.
+	Symbol string
+}
+
+func (r *Relocation) String() string {
+	return fmt.Sprintf("0x%08X %-9s %s", r.Addr, r.Type, r.Symbol)
+}
+
+// readRelocations reads the relocation table of s from r. The packing of the
+// fields of each entry depends on the byte order of the file.
+func (f *File) readRelocations(r io.ReadSeeker, s *Section) error {
+	if _, err := r.Seek(int64(s.RelocationsOffset), io.SeekStart); err != nil {
+		return err
+	}
+	for i := 0; i < int(s.NumRelocations); i++ {
+		var e [2]uint32
+		if err := binary.Read(r, f.byteOrder, &e); err != nil {
+			return err
+		}
+		rel := &Relocation{Addr: e[0]}
+		switch f.byteOrder {
+		case binary.LittleEndian:
+			rel.Index = extractBits(e[1], 0, 24)
+			rel.Type = RelocationType(extractBits(e[1], 27, 4))
+			rel.External = extractBits(e[1], 31, 1) != 0
+		case binary.BigEndian:
+			rel.Index = extractBits(e[1], 8, 24)
+			rel.Type = RelocationType(extractBits(e[1], 1, 4))
+			rel.External = extractBits(e[1], 0, 1) != 0
+		}
+		s.Relocations = append(s.Relocations, rel)
+	}
+	return nil
+}
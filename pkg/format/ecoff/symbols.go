@@ -66,6 +66,8 @@ type FileDescriptor struct {
 	StringsLength             int32
 	SymbolsOffset             int32
 	SymbolsCount              int32
+	LineNumbersIndex          int32
+	LineNumbersCount          int32
 	OptimizationSymbolsOffset int32
 	OptimizationSymbolsCount  int32
 	ProceduresOffset          uint16
@@ -107,6 +109,23 @@ type ProcedureDescriptor32 struct {
 	LineOffset                  int32
 }
 
+// A SourceFile is a source file described by a file descriptor.
+type SourceFile struct {
+	FileDescriptor
+	Name string
+}
+
+// A Procedure is a procedure described by a procedure descriptor, along with
+// the name and size given by its symbols. The Address of the descriptor is
+// relative to the Address of the file descriptor, while Addr is absolute.
+type Procedure struct {
+	ProcedureDescriptor32
+	Name string
+	Addr uint32
+	Size uint32
+	File *SourceFile
+}
+
 // A ProcedureDescriptor64 represents a 64-bit ECOFF file descriptor structure.
 // There should be a structure representing each text label in any given 64-bit
 // ECOFF file.
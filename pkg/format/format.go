@@ -0,0 +1,76 @@
+// Package format identifies the object file formats used for Playstation 1
+// development.
+package format
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/ChrisRx/psxsdk/pkg/format/ar"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+	"github.com/pkg/errors"
+)
+
+// A Format is a kind of object file.
+type Format int
+
+const (
+	Unknown Format = iota
+	ECOFF
+	PSXEXE
+	Archive
+)
+
+func (f Format) String() string {
+	switch f {
+	case ECOFF:
+		return "ECOFF"
+	case PSXEXE:
+		return "PSX-EXE"
+	case Archive:
+		return "archive"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the format by name.
+func (f Format) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// Detect identifies the format of the object file in r using its magic
+// number.
+func Detect(r io.ReaderAt) (Format, error) {
+	magic := make([]byte, len(psx.ExecutableSignature))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return Unknown, err
+	}
+	magic = magic[:n]
+	if bytes.Equal(magic, psx.ExecutableSignature[:]) {
+		return PSXEXE, nil
+	}
+	if bytes.Equal(magic, ar.Magic[:]) {
+		return Archive, nil
+	}
+	if len(magic) >= 2 {
+		switch [2]byte{magic[0], magic[1]} {
+		case ecoff.MIPSEL_MAGIC, ecoff.MIPSEL_BE_MAGIC, ecoff.MIPSBE_MAGIC, ecoff.MIPSBE_EL_MAGIC:
+			return ECOFF, nil
+		}
+	}
+	return Unknown, errors.New("unrecognized file format")
+}
+
+// DetectFile identifies the format of the named object file.
+func DetectFile(name string) (Format, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+	return Detect(f)
+}
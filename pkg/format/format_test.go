@@ -0,0 +1,32 @@
+package format
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected Format
+	}{
+		{filepath.Join("ecoff", "testdata", "main-ecoff"), ECOFF},
+		{filepath.Join("ecoff", "testdata", "puts.o"), ECOFF},
+		{filepath.Join("psx", "testdata", "psx.exe"), PSXEXE},
+		{filepath.Join("ar", "testdata", "libtest.a"), Archive},
+	}
+	for _, c := range cases {
+		f, err := DetectFile(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != c.expected {
+			t.Errorf("%s: expected %s, received %s", c.name, c.expected, f)
+		}
+	}
+
+	if _, err := Detect(bytes.NewReader([]byte("MZ"))); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
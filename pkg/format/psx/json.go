@@ -0,0 +1,59 @@
+package psx
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// The types below define the schema of a PSX-EXE encoded as JSON, which is
+// kept stable for scripts that consume it. Fields are only ever added.
+
+type jsonFile struct {
+	Header   jsonHeader     `json:"header"`
+	Sections []*jsonSection `json:"sections"`
+}
+
+type jsonHeader struct {
+	PC0       uint32 `json:"pc0"`
+	GP0       uint32 `json:"gp0"`
+	TextAddr  uint32 `json:"text_addr"`
+	TextSize  uint32 `json:"text_size"`
+	DataAddr  uint32 `json:"data_addr"`
+	DataSize  uint32 `json:"data_size"`
+	BSSAddr   uint32 `json:"bss_addr"`
+	BSSSize   uint32 `json:"bss_size"`
+	StackAddr uint32 `json:"stack_addr"`
+	StackSize uint32 `json:"stack_size"`
+	Marker    string `json:"marker"`
+}
+
+type jsonSection struct {
+	Name string `json:"name"`
+	Addr uint32 `json:"addr"`
+	Size int    `json:"size"`
+}
+
+// MarshalJSON encodes the header and sections of the file, leaving out the
+// contents of the sections.
+func (f *File) MarshalJSON() ([]byte, error) {
+	out := &jsonFile{
+		Header: jsonHeader{
+			PC0:       f.PC0,
+			GP0:       f.GP0,
+			TextAddr:  f.TextAddr,
+			TextSize:  f.TextSize,
+			DataAddr:  f.DataAddr,
+			DataSize:  f.DataSize,
+			BSSAddr:   f.BSSAddr,
+			BSSSize:   f.BSSSize,
+			StackAddr: f.StackAddr,
+			StackSize: f.StackSize,
+			Marker:    string(bytes.TrimRight(f.ASCIIMarker[:], "\x00")),
+		},
+		Sections: make([]*jsonSection, 0, len(f.Sections)),
+	}
+	for _, s := range f.Sections {
+		out.Sections = append(out.Sections, &jsonSection{Name: s.Name, Addr: s.Addr, Size: len(s.Data)})
+	}
+	return json.Marshal(out)
+}
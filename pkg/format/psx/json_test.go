@@ -0,0 +1,71 @@
+package psx
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden JSON files in testdata")
+
+func TestMarshalJSON(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "psx.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Header struct {
+			PC0      uint32 `json:"pc0"`
+			TextSize uint32 `json:"text_size"`
+			Marker   string
+		}
+		Sections []struct {
+			Name string
+			Size int
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Header.PC0 != 0x801412f0 || out.Header.TextSize != 1251328 {
+		t.Errorf("expected pc0 0x801412f0 and text size 1251328, received %s", data)
+	}
+	if out.Header.Marker != "COMBINE version 1.00" {
+		t.Errorf("expected marker %q, received %q", "COMBINE version 1.00", out.Header.Marker)
+	}
+	if len(out.Sections) != 1 || out.Sections[0].Name != "text" || out.Sections[0].Size != 1251328 {
+		t.Errorf("expected a single text section, received %+v", out.Sections)
+	}
+}
+
+func TestMarshalJSONGolden(t *testing.T) {
+	f, err := Open(filepath.Join("testdata", "psx.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	golden := filepath.Join("testdata", "psx.exe.json")
+	if *update {
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("JSON differs from %s, run go test -update if the change is intended", golden)
+	}
+}
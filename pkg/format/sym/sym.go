@@ -0,0 +1,114 @@
+// Package sym implements access to text symbol files, such as the .SYM files
+// read by the no$psx debugger and the map files written by linkers, and writes
+// symbols for import into other debuggers and disassemblers.
+package sym
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A Kind describes what a symbol names.
+type Kind int
+
+const (
+	Label Kind = iota
+	Function
+	Data
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Label:
+		return "label"
+	case Function:
+		return "function"
+	case Data:
+		return "data"
+	default:
+		return "unknown"
+	}
+}
+
+// A Symbol is a name for an address. Symbols read from text files are all
+// labels, while those taken from object files may also give the size of a
+// function and the source file it was compiled from.
+type Symbol struct {
+	Addr uint32
+	Name string
+	Kind Kind
+	Size uint32
+	File string
+}
+
+// A File is a list of symbols, in the order they were read.
+type File struct {
+	Symbols []*Symbol
+}
+
+// Open reads the named symbol file.
+func Open(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads symbols from r. Every line holding just an address and a name,
+// in either order, is a symbol, which covers no$psx .SYM files ("80010000
+// main") as well as the symbol listings of Psy-Q and GNU ld map files. Other
+// lines, and anything following a ';', are ignored.
+func Parse(r io.Reader) (*File, error) {
+	f := &File{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if addr, ok := parseAddr(fields[0]); ok && isName(fields[1]) {
+			f.Symbols = append(f.Symbols, &Symbol{Addr: addr, Name: fields[1]})
+		} else if addr, ok := parseAddr(fields[1]); ok && isName(fields[0]) {
+			f.Symbols = append(f.Symbols, &Symbol{Addr: addr, Name: fields[0]})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseAddr parses a hexadecimal address, which has either a 0x prefix or
+// exactly 8 digits. Addresses wider than 32 bits, as written by 64-bit
+// linkers, are accepted if they fit.
+func parseAddr(s string) (uint32, bool) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	} else if len(s) != 8 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || v > 0xffffffff {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// isName reports whether s looks like a symbol name rather than a number or
+// a no$psx directive (e.g. ".word:0004").
+func isName(s string) bool {
+	if strings.ContainsAny(s, ":=()*") {
+		return false
+	}
+	c := s[0]
+	return c == '_' || c == '.' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
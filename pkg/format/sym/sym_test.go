@@ -0,0 +1,38 @@
+package sym
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected []Symbol
+	}{
+		{"main.sym", []Symbol{
+			{Addr: 0x80010000, Name: "_start"},
+			{Addr: 0x80010040, Name: "main"},
+			{Addr: 0x80020000, Name: "message"},
+		}},
+		{"main.map", []Symbol{
+			{Addr: 0x80010000, Name: "_start"},
+			{Addr: 0x80010040, Name: "main"},
+			{Addr: 0x800100C0, Name: "puts"},
+		}},
+	}
+	for _, c := range cases {
+		f, err := Open(filepath.Join("testdata", c.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Symbols) != len(c.expected) {
+			t.Fatalf("%s: expected %d symbols, received %d", c.name, len(c.expected), len(f.Symbols))
+		}
+		for i, s := range f.Symbols {
+			if *s != c.expected[i] {
+				t.Errorf("%s: expected %+v, received %+v", c.name, c.expected[i], *s)
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package sym
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// sorted returns the symbols ordered by address, keeping the order of symbols
+// at the same address.
+func (f *File) sorted() []*Symbol {
+	symbols := make([]*Symbol, len(f.Symbols))
+	copy(symbols, f.Symbols)
+	sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].Addr < symbols[j].Addr })
+	return symbols
+}
+
+// Write writes the symbols as a no$psx .SYM file, ordered by address. The
+// source file of each function is given in a comment.
+func (f *File) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "; no$psx symbols\n")
+	for _, s := range f.sorted() {
+		fmt.Fprintf(bw, "%08X %s", s.Addr, s.Name)
+		if s.File != "" {
+			fmt.Fprintf(bw, " ; %s", s.File)
+		}
+		fmt.Fprint(bw, "\n")
+	}
+	return bw.Flush()
+}
+
+// WriteIDC writes the symbols as an IDC script for IDA, which names each
+// address and creates functions spanning their size.
+func (f *File) WriteIDC(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "#include <idc.idc>\n\nstatic main(void)\n{\n")
+	for _, s := range f.sorted() {
+		if s.Kind == Function {
+			end := "BADADDR"
+			if s.Size > 0 {
+				end = fmt.Sprintf("0x%08X", s.Addr+s.Size)
+			}
+			fmt.Fprintf(bw, "\tadd_func(0x%08X, %s);\n", s.Addr, end)
+		}
+		fmt.Fprintf(bw, "\tset_name(0x%08X, %s, SN_NOWARN);\n", s.Addr, strconv.Quote(s.Name))
+		if s.File != "" {
+			fmt.Fprintf(bw, "\tset_func_cmt(0x%08X, %s, 1);\n", s.Addr, strconv.Quote(s.File))
+		}
+	}
+	fmt.Fprint(bw, "}\n")
+	return bw.Flush()
+}
+
+// ghidraScript is run by Ghidra's script manager, after the symbols are
+// listed, to apply them to the current program.
+const ghidraScript = `
+for addr, name, kind, size, source in symbols:
+    a = toAddr(addr)
+    createLabel(a, name, True, SourceType.IMPORTED)
+    if kind == "function":
+        fn = getFunctionAt(a)
+        if fn is None:
+            fn = createFunction(a, name)
+        if fn is not None and size > 0:
+            fn.setBody(AddressSet(a, a.add(size - 1)))
+    if source:
+        setPlateComment(a, source)
+`
+
+// WriteGhidra writes the symbols as a Python script for Ghidra, which labels
+// each address and creates functions spanning their size.
+func (f *File) WriteGhidra(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "# Imports symbols into the current program.\n")
+	fmt.Fprint(bw, "# @category Playstation\n\n")
+	fmt.Fprint(bw, "from ghidra.program.model.address import AddressSet\n")
+	fmt.Fprint(bw, "from ghidra.program.model.symbol import SourceType\n\n")
+	fmt.Fprint(bw, "symbols = [\n")
+	for _, s := range f.sorted() {
+		fmt.Fprintf(bw, "    (0x%08X, %s, %q, %d, %s),\n", s.Addr, strconv.Quote(s.Name), s.Kind, s.Size, strconv.Quote(s.File))
+	}
+	fmt.Fprint(bw, "]\n")
+	fmt.Fprint(bw, ghidraScript)
+	return bw.Flush()
+}
+
+// WriteCSV writes the symbols as CSV, with a header naming the columns.
+func (f *File) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "address", "kind", "size", "file"})
+	for _, s := range f.sorted() {
+		cw.Write([]string{
+			s.Name,
+			fmt.Sprintf("0x%08X", s.Addr),
+			s.Kind.String(),
+			strconv.FormatUint(uint64(s.Size), 10),
+			s.File,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,75 @@
+package sym
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	f := &File{Symbols: []*Symbol{
+		{Addr: 0x80020000, Name: "message", Kind: Data},
+		{Addr: 0x80010040, Name: "main", Kind: Function, Size: 0x80, File: "main.c"},
+		{Addr: 0x80010000, Name: "_start"},
+	}}
+	cases := []struct {
+		name     string
+		write    func(*File, *bytes.Buffer) error
+		expected []string
+	}{
+		{"sym", func(f *File, b *bytes.Buffer) error { return f.Write(b) }, []string{
+			"; no$psx symbols",
+			"80010000 _start",
+			"80010040 main ; main.c",
+			"80020000 message",
+		}},
+		{"idc", func(f *File, b *bytes.Buffer) error { return f.WriteIDC(b) }, []string{
+			"#include <idc.idc>",
+			"",
+			"static main(void)",
+			"{",
+			"\tset_name(0x80010000, \"_start\", SN_NOWARN);",
+			"\tadd_func(0x80010040, 0x800100C0);",
+			"\tset_name(0x80010040, \"main\", SN_NOWARN);",
+			"\tset_func_cmt(0x80010040, \"main.c\", 1);",
+			"\tset_name(0x80020000, \"message\", SN_NOWARN);",
+			"}",
+		}},
+		{"csv", func(f *File, b *bytes.Buffer) error { return f.WriteCSV(b) }, []string{
+			"name,address,kind,size,file",
+			"_start,0x80010000,label,0,",
+			"main,0x80010040,function,128,main.c",
+			"message,0x80020000,data,0,",
+		}},
+	}
+	for _, c := range cases {
+		var b bytes.Buffer
+		if err := c.write(f, &b); err != nil {
+			t.Fatal(err)
+		}
+		if expected := strings.Join(c.expected, "\n") + "\n"; b.String() != expected {
+			t.Errorf("%s: expected:\n%s\nreceived:\n%s", c.name, expected, b.String())
+		}
+	}
+
+	var b bytes.Buffer
+	if err := f.WriteGhidra(&b); err != nil {
+		t.Fatal(err)
+	}
+	if s := `    (0x80010040, "main", "function", 128, "main.c"),`; !strings.Contains(b.String(), s) {
+		t.Errorf("expected Ghidra script to contain %s:\n%s", s, b.String())
+	}
+
+	// The .SYM file can be read back, in order of address.
+	b.Reset()
+	if err := f.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	g, err := Parse(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Symbols) != 3 || g.Symbols[1].Name != "main" || g.Symbols[1].Addr != 0x80010040 {
+		t.Errorf("unexpected symbols read back: %v", g.Symbols)
+	}
+}
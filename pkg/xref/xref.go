@@ -0,0 +1,257 @@
+// Package xref builds a cross-reference database of the code in an
+// executable, recording the procedures each instruction calls or jumps to and
+// the data it loads, stores or takes the address of. Addresses of data are
+// found where they are built up with lui and a following instruction (e.g.
+// lui/addiu or lui/lw), or relative to the global pointer.
+package xref
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/mewmew/mips"
+	"github.com/pkg/errors"
+)
+
+// A Kind describes how an instruction refers to an address.
+type Kind int
+
+const (
+	// Call is a call to a procedure (jal, bal).
+	Call Kind = iota
+
+	// Jump is a jump to another procedure, such as a tail call.
+	Jump
+
+	// Read is a load from memory.
+	Read
+
+	// Write is a store to memory.
+	Write
+
+	// Address is the address itself being computed, such as a pointer to
+	// data or a procedure passed as an argument.
+	Address
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Call:
+		return "call"
+	case Jump:
+		return "jump"
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Address:
+		return "address"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the kind by name.
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// A Ref is a reference from the instruction at From, in the function at Func,
+// to the address To.
+type Ref struct {
+	From uint32 `json:"from"`
+	Func uint32 `json:"func"`
+	To   uint32 `json:"to"`
+	Kind Kind   `json:"kind"`
+}
+
+// A Database holds the references made by the code of an executable.
+type Database struct {
+	Functions []*disasm.Function
+	Symbols   disasm.Symbols
+
+	// Refs holds every reference, ordered by the address they are made
+	// from.
+	Refs []*Ref
+
+	// GP is the value of the global pointer register, used to find the
+	// addresses of gp-relative loads and stores.
+	GP uint32
+
+	to map[uint32][]*Ref
+}
+
+// New returns an empty database, naming addresses with symbols.
+func New(symbols disasm.Symbols, gp uint32) *Database {
+	if symbols == nil {
+		symbols = make(disasm.Symbols)
+	}
+	return &Database{
+		Symbols: symbols,
+		GP:      gp,
+		to:      make(map[uint32][]*Ref),
+	}
+}
+
+// Build returns the database of the executable sections of img.
+func Build(img *binutils.Image) *Database {
+	db := New(img.Symbols, img.GP)
+	for _, s := range img.Sections {
+		if s.Executable {
+			db.Add(disasm.Disassemble(s.Data[:len(s.Data)&^3], s.Addr))
+		}
+	}
+	return db
+}
+
+// Add records the functions of insts, which should be contiguous, and the
+// references they make.
+func (db *Database) Add(insts []*disasm.Inst) {
+	functions := disasm.Functions(insts, db.Symbols)
+	db.Functions = append(db.Functions, functions...)
+	sort.Slice(db.Functions, func(i, j int) bool { return db.Functions[i].Addr < db.Functions[j].Addr })
+
+	var refs []*Ref
+	for _, fn := range functions {
+		for _, b := range fn.Blocks {
+			for _, inst := range b.Insts {
+				refs = append(refs, db.refs(fn, inst)...)
+			}
+		}
+	}
+	for _, r := range refs {
+		db.to[r.To] = append(db.to[r.To], r)
+	}
+	db.Refs = append(db.Refs, refs...)
+	sort.SliceStable(db.Refs, func(i, j int) bool { return db.Refs[i].From < db.Refs[j].From })
+}
+
+// refs returns the references made by inst, which is in fn.
+func (db *Database) refs(fn *disasm.Function, inst *disasm.Inst) []*Ref {
+	ref := func(to uint32, k Kind) []*Ref {
+		return []*Ref{{From: inst.Addr, Func: fn.Addr, To: to, Kind: k}}
+	}
+	switch inst.Flow {
+	case disasm.FlowCall:
+		return ref(inst.Target, Call)
+	case disasm.FlowJump:
+		if inst.Target < fn.Addr || inst.Target >= fn.Addr+fn.Size {
+			return ref(inst.Target, Jump)
+		}
+		return nil
+	}
+	if !inst.Valid() {
+		return nil
+	}
+	k, ok := access(inst.Op)
+	if !ok {
+		return nil
+	}
+	if inst.HasRef {
+		return ref(inst.Ref, k)
+	}
+	if rs := mips.Reg(inst.Word >> 21 & 0x1f); rs == mips.GP && db.GP != 0 {
+		return ref(db.GP+uint32(int32(int16(inst.Word))), k)
+	}
+	return nil
+}
+
+// access returns how an instruction that computes an address from a register
+// and an immediate uses it, reporting whether it is one.
+func access(op mips.Op) (Kind, bool) {
+	switch op {
+	case mips.LB, mips.LBU, mips.LH, mips.LHU, mips.LW, mips.LWL, mips.LWR, mips.LWC2:
+		return Read, true
+	case mips.SB, mips.SH, mips.SW, mips.SWL, mips.SWR, mips.SWC2:
+		return Write, true
+	case mips.ADDIU, mips.ADDI, mips.ORI:
+		return Address, true
+	}
+	return 0, false
+}
+
+// To returns the references to addr, ordered by the address they are made
+// from.
+func (db *Database) To(addr uint32) []*Ref {
+	return db.to[addr]
+}
+
+// Callers returns the calls and jumps to the procedure at addr.
+func (db *Database) Callers(addr uint32) []*Ref {
+	var refs []*Ref
+	for _, r := range db.to[addr] {
+		if r.Kind == Call || r.Kind == Jump {
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// From returns the references made by the function at addr.
+func (db *Database) From(addr uint32) []*Ref {
+	fn := db.Function(addr)
+	if fn == nil {
+		return nil
+	}
+	i := sort.Search(len(db.Refs), func(i int) bool { return db.Refs[i].From >= fn.Addr })
+	j := sort.Search(len(db.Refs), func(i int) bool { return db.Refs[i].From >= fn.Addr+fn.Size })
+	return db.Refs[i:j]
+}
+
+// Function returns the function containing addr, or nil if there isn't one.
+func (db *Database) Function(addr uint32) *disasm.Function {
+	i := sort.Search(len(db.Functions), func(i int) bool { return db.Functions[i].Addr > addr })
+	if i == 0 {
+		return nil
+	}
+	if fn := db.Functions[i-1]; addr < fn.Addr+fn.Size {
+		return fn
+	}
+	return nil
+}
+
+// Name returns the symbol at addr, or the name of the function starting
+// there, if there is one.
+func (db *Database) Name(addr uint32) (string, bool) {
+	if name, ok := db.Symbols[addr]; ok {
+		return name, true
+	}
+	if fn := db.Function(addr); fn != nil && fn.Addr == addr {
+		return fn.Name, true
+	}
+	return "", false
+}
+
+// Lookup returns the address named by a symbol or function name. A name at
+// more than one address, such as that of static functions in different files,
+// is an error listing the addresses, so one can be chosen by address instead.
+func (db *Database) Lookup(name string) (uint32, error) {
+	var addrs []uint32
+	for addr, s := range db.Symbols {
+		if s == name {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		for _, fn := range db.Functions {
+			if fn.Name == name {
+				addrs = append(addrs, fn.Addr)
+			}
+		}
+	}
+	switch len(addrs) {
+	case 0:
+		return 0, errors.Errorf("symbol %s not found", name)
+	case 1:
+		return addrs[0], nil
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	found := make([]string, len(addrs))
+	for i, addr := range addrs {
+		found[i] = fmt.Sprintf("0x%08X", addr)
+	}
+	return 0, errors.Errorf("symbol %s is ambiguous, found at %s", name, strings.Join(found, ", "))
+}
@@ -0,0 +1,76 @@
+package xref
+
+import (
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+)
+
+func TestBuild(t *testing.T) {
+	img, err := binutils.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := Build(img)
+
+	initHeap, err := db.Lookup("InitHeap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callers := db.Callers(initHeap)
+	if len(callers) != 1 || callers[0].From != 0x80140134 || callers[0].Func != 0x80140000 {
+		t.Fatalf("expected InitHeap to be called once from 0x80140134, received %v", callers)
+	}
+
+	main, err := db.Lookup("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn := db.Function(main + 0x28); fn == nil || fn.Name != "main" {
+		t.Fatalf("expected 0x%08x to be in main, received %v", main+0x28, fn)
+	}
+	expected := []Ref{
+		{From: 0x801401e8, Func: main, To: 0x801401ac, Kind: Call},
+		{From: 0x801401f4, Func: main, To: 0x80140ab0, Kind: Call},
+		// addiu $a0, $gp, 0x8080
+		{From: 0x801401fc, Func: main, To: 0x801412f0, Kind: Address},
+	}
+	refs := db.From(main)
+	if len(refs) < len(expected) {
+		t.Fatalf("expected at least %d references from main, received %d", len(expected), len(refs))
+	}
+	for i, r := range expected {
+		if *refs[i] != r {
+			t.Errorf("expected %+v, received %+v", r, *refs[i])
+		}
+	}
+	for _, r := range refs {
+		if r.Func != main {
+			t.Errorf("reference %+v isn't from main", *r)
+		}
+	}
+	if to := db.To(0x801412f0); len(to) == 0 {
+		t.Errorf("expected references to 0x801412f0")
+	}
+	if _, err := db.Lookup("nonexistent"); err == nil {
+		t.Errorf("expected error looking up nonexistent symbol")
+	}
+}
+
+func TestLookupAmbiguous(t *testing.T) {
+	db := &Database{Symbols: disasm.Symbols{
+		0x80010000: "init",
+		0x80020000: "init",
+		0x80030000: "main",
+	}}
+	if addr, err := db.Lookup("main"); err != nil || addr != 0x80030000 {
+		t.Fatalf("expected main at 0x80030000, received 0x%08X (%v)", addr, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := db.Lookup("init")
+		if err == nil || err.Error() != "symbol init is ambiguous, found at 0x80010000, 0x80020000" {
+			t.Fatalf("expected init to be ambiguous, received %v", err)
+		}
+	}
+}
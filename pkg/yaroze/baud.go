@@ -0,0 +1,98 @@
+package yaroze
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+// BaudRates are the baud rates supported by the monitor, in the order they
+// are tried when detecting the rate in use.
+var BaudRates = []int{115200, 57600, 38400, 19200, 9600}
+
+// A ModeSetter is a Transport that can change its baud rate, such as
+// serial.Port.
+type ModeSetter interface {
+	SetMode(mode *serial.Mode) error
+}
+
+// DetectBaudRate returns the baud rate used by the monitor on the named serial
+// device, trying each of BaudRates until the console responds with a prompt.
+func DetectBaudRate(name string) (int, error) {
+	for _, rate := range BaudRates {
+		err := Probe(name, rate, probeTimeout)
+		if err == nil {
+			return rate, nil
+		}
+		if !IsTimeout(err) {
+			return 0, err
+		}
+	}
+	return 0, errors.Errorf("no response from %s at any supported baud rate", name)
+}
+
+// BaudRate returns the baud rate used to talk to the monitor, or zero if it
+// isn't known.
+func (p *Port) BaudRate() int {
+	return p.baud
+}
+
+// SetBaudRate switches the monitor, and then the Transport, to a different
+// baud rate. If the monitor doesn't respond at the new rate, the Transport is
+// returned to the previous rate.
+func (p *Port) SetBaudRate(ctx context.Context, rate int) error {
+	ms, ok := p.Transport.(ModeSetter)
+	if !ok {
+		return errors.New("cannot change the baud rate of this connection")
+	}
+	if p.baud == 0 {
+		return errors.New("cannot change the baud rate when the current rate is unknown")
+	}
+	if rate == p.baud {
+		return nil
+	}
+	supported := false
+	for _, r := range BaudRates {
+		supported = supported || r == rate
+	}
+	if !supported {
+		return errors.Errorf("%d baud is not supported by the monitor", rate)
+	}
+
+	// The monitor switches once it has echoed the command.
+	p.discard()
+	command := fmt.Sprintf("%s %d", CmdBaudRate, rate)
+	if err := p.Write(ctx, []byte(command+"\r")); err != nil {
+		return err
+	}
+	if err := p.ReadUntil(ctx, command+"\r\n"); err != nil {
+		return errors.Wrapf(err, "cannot switch to %d baud", rate)
+	}
+	if err := ms.SetMode(&serial.Mode{BaudRate: rate}); err != nil {
+		return err
+	}
+	if err := p.SendCommand(ctx, ""); err != nil {
+		if err := ms.SetMode(&serial.Mode{BaudRate: p.baud}); err != nil {
+			return err
+		}
+		return errors.Wrapf(err, "no response at %d baud", rate)
+	}
+	p.baud = rate
+	return nil
+}
+
+// WithBaudRate switches to a different baud rate while running fn, such as
+// for a large upload, and then restores the previous rate.
+func (p *Port) WithBaudRate(ctx context.Context, rate int, fn func() error) error {
+	prev := p.baud
+	if err := p.SetBaudRate(ctx, rate); err != nil {
+		return err
+	}
+	err := fn()
+	if rerr := p.SetBaudRate(ctx, prev); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+	"go.bug.st/serial"
+)
+
+func TestDetectBaudRate(t *testing.T) {
+	defer func(open func(string, int) (Transport, error), timeout time.Duration) {
+		openSerial, probeTimeout = open, timeout
+	}(openSerial, probeTimeout)
+	probeTimeout = 20 * time.Millisecond
+
+	var tried []int
+	openSerial = func(name string, baud int) (Transport, error) {
+		tried = append(tried, baud)
+		c := yarozetest.NewConsole()
+		c.SetBaudRate(38400)
+		c.SetMode(&serial.Mode{BaudRate: baud})
+		return c, nil
+	}
+	rate, err := DetectBaudRate("/dev/ttyUSB0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 38400 {
+		t.Fatalf("expected 38400 baud, received %d", rate)
+	}
+	if len(tried) != 3 {
+		t.Fatalf("expected 3 rates to be tried, received %v", tried)
+	}
+
+	// Opening the port without a baud rate detects it.
+	p, err := OpenPort(&PortConfig{DeviceName: "/dev/ttyUSB0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.BaudRate() != 38400 {
+		t.Fatalf("expected port to be opened at 38400 baud, received %d", p.BaudRate())
+	}
+	if p.timeout != DefaultTimeout {
+		t.Fatalf("expected default timeout once detected, received %s", p.timeout)
+	}
+}
+
+func TestPortSetBaudRate(t *testing.T) {
+	c := yarozetest.NewConsole()
+	c.SetBaudRate(9600)
+	c.SetMode(&serial.Mode{BaudRate: 9600})
+	p, err := NewPort(c, &PortConfig{BaudRate: 9600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte{0xa5, 0x5a}, 3*BlockSize)
+	err = p.WithBaudRate(ctx, 115200, func() error {
+		if c.BaudRate() != 115200 {
+			t.Errorf("expected monitor to switch to 115200 baud, received %d", c.BaudRate())
+		}
+		return p.Upload(ctx, 0x80100000, data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaudRate() != 9600 || p.BaudRate() != 9600 {
+		t.Fatalf("expected 9600 baud to be restored, monitor is at %d and port at %d", c.BaudRate(), p.BaudRate())
+	}
+	if got := c.ReadMemory(0x80100000, len(data)); !bytes.Equal(got, data) {
+		t.Fatal("uploaded data does not match")
+	}
+
+	// A rate the monitor doesn't support leaves everything as it was.
+	if err := p.SetBaudRate(ctx, 12345); err == nil {
+		t.Fatal("expected unsupported baud rate to fail")
+	}
+	if p.BaudRate() != 9600 {
+		t.Fatalf("expected port to stay at 9600 baud, received %d", p.BaudRate())
+	}
+	if _, err := p.Registers(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,200 @@
+package yaroze
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisRx/psxsdk/pkg/format"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+	"github.com/pkg/errors"
+)
+
+// A DataFile is an auxiliary file, such as a TIM image or a VAB sound bank,
+// that is uploaded to a fixed address alongside the executable.
+type DataFile struct {
+	Name string
+	Addr uint32
+}
+
+// ParseDataFile parses a data file given as file@address, where the address
+// is in hexadecimal and may be prefixed with 0x.
+func ParseDataFile(s string) (*DataFile, error) {
+	i := strings.LastIndexByte(s, '@')
+	if i <= 0 {
+		return nil, errors.Errorf("invalid data file %q, expected file@address", s)
+	}
+	addr, err := parseAddr(s[i+1:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid data file %q", s)
+	}
+	return &DataFile{Name: s[:i], Addr: addr}, nil
+}
+
+func parseAddr(s string) (uint32, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 32)
+	if err != nil {
+		return 0, errors.Errorf("invalid address %q", s)
+	}
+	return uint32(v), nil
+}
+
+// ReadManifest reads a list of data files from the named manifest. Each line
+// holds a file name and the hexadecimal address to upload it to, and blank
+// lines or lines starting with # are ignored. The "local dload" lines used by
+// the auto batch files of the Net Yaroze siocons are also accepted. File
+// names are relative to the directory of the manifest.
+func ReadManifest(name string) ([]*DataFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(name)
+	var files []*DataFile
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 4 && fields[0] == "local" && fields[1] == "dload" {
+			fields = fields[2:]
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf("%s:%d: expected file and address", name, n)
+		}
+		addr, err := parseAddr(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", name, n)
+		}
+		path := filepath.FromSlash(strings.Replace(fields[0], "\\", "/", -1))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		files = append(files, &DataFile{Name: path, Addr: addr})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// A Region is a range of console memory occupied by an upload.
+type Region struct {
+	Name string
+	Addr uint32
+	Size uint32
+}
+
+// End returns the address following the region.
+func (r Region) End() uint32 {
+	return r.Addr + r.Size
+}
+
+// ExecutableRegions returns the memory occupied by the sections of the named
+// ECOFF or PSX-EXE executable, including any uninitialized data.
+func ExecutableRegions(name string) ([]Region, error) {
+	ft, err := format.DetectFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var regions []Region
+	switch ft {
+	case format.ECOFF:
+		f, err := ecoff.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		for _, s := range f.Sections {
+			if s.Size <= 0 {
+				continue
+			}
+			regions = append(regions, Region{
+				Name: s.SectionName(),
+				Addr: s.VirtualAddress,
+				Size: uint32(s.Size),
+			})
+		}
+	case format.PSXEXE:
+		f, err := psx.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, Region{Name: "text", Addr: f.TextAddr, Size: uint32(len(f.Section("text").Data))})
+		if f.BSSSize != 0 {
+			regions = append(regions, Region{Name: "bss", Addr: f.BSSAddr, Size: f.BSSSize})
+		}
+	default:
+		return nil, errors.Errorf("cannot load %s executable", ft)
+	}
+	return regions, nil
+}
+
+// DataRegions returns the memory that will be occupied by each data file.
+func DataRegions(files []*DataFile) ([]Region, error) {
+	regions := make([]Region, 0, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, Region{Name: f.Name, Addr: f.Addr, Size: uint32(fi.Size())})
+	}
+	return regions, nil
+}
+
+// CheckOverlap returns an error describing the first pair of regions found to
+// overlap.
+func CheckOverlap(regions []Region) error {
+	sorted := make([]Region, 0, len(regions))
+	for _, r := range regions {
+		if r.Size != 0 {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Addr < sorted[j].Addr
+	})
+	for i := 1; i < len(sorted); i++ {
+		a, b := sorted[i-1], sorted[i]
+		if b.Addr < a.End() {
+			return errors.Errorf("%s (0x%08X-0x%08X) overlaps %s (0x%08X-0x%08X)",
+				b.Name, b.Addr, b.End(), a.Name, a.Addr, a.End())
+		}
+	}
+	return nil
+}
+
+// LoadData uploads each data file to its address. As with WriteMemory, the
+// memory following each file is kept, rather than overwritten with the padding
+// of the final block, so a file may end right before the executable or
+// another file.
+func (p *Port) LoadData(ctx context.Context, files []*DataFile) error {
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.Name)
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if data, err = p.withTail(ctx, f.Addr, data); err != nil {
+			return errors.Wrapf(err, "cannot upload %s", f.Name)
+		}
+		t := newTransfer(p.progress, filepath.Base(f.Name), f.Addr, len(data))
+		if err := p.upload(ctx, t, data, 0); err != nil {
+			return errors.Wrapf(err, "cannot upload %s", f.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDataFile(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected *DataFile
+	}{
+		{"tex.tim@0x80090000", &DataFile{Name: "tex.tim", Addr: 0x80090000}},
+		{"snd/bank.vab@800A0000", &DataFile{Name: "snd/bank.vab", Addr: 0x800a0000}},
+		{"a@b.seq@0x80100000", &DataFile{Name: "a@b.seq", Addr: 0x80100000}},
+	}
+	for _, c := range cases {
+		f, err := ParseDataFile(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(f, c.expected) {
+			t.Errorf("%s: expected %+v, received %+v", c.input, c.expected, f)
+		}
+	}
+	for _, s := range []string{"tex.tim", "@0x80090000", "tex.tim@", "tex.tim@0x180000000", "tex.tim@zz"} {
+		if _, err := ParseDataFile(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "auto")
+	manifest := "# assets\n\ntex.tim 80090000\nlocal dload data\\bank.vab 0x800A0000\n"
+	if err := ioutil.WriteFile(name, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ReadManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*DataFile{
+		{Name: filepath.Join(dir, "tex.tim"), Addr: 0x80090000},
+		{Name: filepath.Join(dir, "data", "bank.vab"), Addr: 0x800a0000},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %+v, received %+v", expected, files)
+	}
+
+	if err := ioutil.WriteFile(name, []byte("tex.tim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(name); err == nil {
+		t.Fatal("expected error for line without address")
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	regions, err := ExecutableRegions("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(regions) == 0 {
+		t.Fatal("expected executable regions")
+	}
+	if err := CheckOverlap(regions); err != nil {
+		t.Fatal(err)
+	}
+	text := regions[0]
+
+	ok := append(regions, Region{Name: "tex.tim", Addr: 0x80100000, Size: 0x8000})
+	if err := CheckOverlap(ok); err != nil {
+		t.Fatal(err)
+	}
+	bad := append(regions, Region{Name: "tex.tim", Addr: text.End() - 4, Size: 0x100})
+	if err := CheckOverlap(bad); err == nil {
+		t.Fatal("expected overlap with the text section")
+	}
+	adjacent := []Region{{Name: "a", Addr: 0x80100000, Size: 0x100}, {Name: "b", Addr: 0x80100100, Size: 0x100}}
+	if err := CheckOverlap(adjacent); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortLoadData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tim := bytes.Repeat([]byte{0x10, 0x00, 0x00, 0x00}, 1500)
+	vab := []byte("pBAV")
+	files := []*DataFile{
+		{Name: filepath.Join(dir, "tex.tim"), Addr: 0x80090000},
+		{Name: filepath.Join(dir, "bank.vab"), Addr: 0x800a0000},
+	}
+	for i, data := range [][]byte{tim, vab} {
+		if err := ioutil.WriteFile(files[i].Name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, c := newTestPort(t)
+	defer p.Close()
+	if err := p.LoadData(context.Background(), files); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ReadMemory(0x80090000, len(tim)); !bytes.Equal(got, tim) {
+		t.Error("tex.tim was not uploaded correctly")
+	}
+	if got := c.ReadMemory(0x800a0000, len(vab)); !bytes.Equal(got, vab) {
+		t.Error("bank.vab was not uploaded correctly")
+	}
+}
+
+// A file ending one byte before the next region doesn't overlap it, and
+// loading it leaves that region intact.
+func TestPortLoadDataAdjacent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "tex.tim")
+	tim := bytes.Repeat([]byte{0x10}, 0xfff)
+	if err := ioutil.WriteFile(name, tim, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []*DataFile{{Name: name, Addr: 0x8013f000}}
+	regions, err := ExecutableRegions("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DataRegions(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end := data[0].End(); end != regions[0].Addr-1 {
+		t.Fatalf("expected tex.tim to end at 0x%08X, received 0x%08X", regions[0].Addr-1, end)
+	}
+	if err := CheckOverlap(append(regions, data...)); err != nil {
+		t.Fatal(err)
+	}
+
+	p, c := newTestPort(t)
+	defer p.Close()
+	following := []byte{0xaa, 0x01, 0x02, 0x03, 0x04}
+	c.WriteMemory(0x8013ffff, following)
+	if err := p.LoadData(context.Background(), files); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ReadMemory(0x8013f000, len(tim)); !bytes.Equal(got, tim) {
+		t.Error("tex.tim was not uploaded correctly")
+	}
+	if got := c.ReadMemory(0x8013ffff, len(following)); !bytes.Equal(got, following) {
+		t.Errorf("expected the memory following tex.tim to be kept, found % x", got)
+	}
+}
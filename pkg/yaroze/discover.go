@@ -0,0 +1,225 @@
+package yaroze
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+// A Device is a serial port that may be connected to a Net Yaroze.
+type Device struct {
+	Name         string
+	USB          bool
+	VID          string
+	PID          string
+	SerialNumber string
+
+	// Adapter is the name of the USB-serial adapter, if it is one known to
+	// work with the Net Yaroze serial cable.
+	Adapter string
+}
+
+// knownAdapters are USB-serial adapters commonly used with the Net Yaroze
+// serial cable, identified by USB vendor and product ID.
+var knownAdapters = []struct {
+	VID, PID string
+	Name     string
+}{
+	{"067B", "2303", "Prolific PL2303"},
+	{"0403", "6001", "FTDI FT232R"},
+	{"0403", "6015", "FTDI FT231X"},
+	{"10C4", "EA60", "Silicon Labs CP210x"},
+	{"1A86", "7523", "WCH CH340"},
+}
+
+// probeTimeout is how long a device is given to respond with the monitor
+// prompt when searching for the console.
+var probeTimeout = 500 * time.Millisecond
+
+// Hooks for replacing the serial hardware during tests.
+var (
+	listDevices = ListDevices
+	openSerial  = func(name string, baud int) (Transport, error) {
+		return serial.Open(name, &serial.Mode{BaudRate: baud})
+	}
+)
+
+// ListDevices returns the serial ports of the system, starting with known
+// USB-serial adapters, followed by any other USB devices and finally
+// everything else.
+func ListDevices() ([]*Device, error) {
+	devices, err := detailedPorts()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		for _, a := range knownAdapters {
+			if d.USB && strings.EqualFold(d.VID, a.VID) && strings.EqualFold(d.PID, a.PID) {
+				d.Adapter = a.Name
+			}
+		}
+	}
+	sortDevices(devices)
+	return devices, nil
+}
+
+func sortDevices(devices []*Device) {
+	rank := func(d *Device) int {
+		switch {
+		case d.Adapter != "":
+			return 0
+		case d.USB:
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		if ri, rj := rank(devices[i]), rank(devices[j]); ri != rj {
+			return ri < rj
+		}
+		return devices[i].Name < devices[j].Name
+	})
+}
+
+// Probe reports whether the named serial device is connected to a Net Yaroze
+// by interrupting the console and waiting for the monitor prompt.
+func Probe(name string, baud int, timeout time.Duration) error {
+	t, err := openSerial(name, baud)
+	if err != nil {
+		return err
+	}
+	p, err := NewPort(t, &PortConfig{Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	return p.Close()
+}
+
+// FindDevice returns the name of the serial device connected to a Net Yaroze
+// and the baud rate it responded at, probing each device in turn at the given
+// baud rate, or at each supported rate if it is zero. The last device found is
+// remembered and tried first next time. Only USB devices are probed unless
+// there are none.
+func FindDevice(baud int) (string, int, error) {
+	devices, err := listDevices()
+	if err != nil {
+		return "", 0, err
+	}
+	if len(devices) == 0 {
+		return "", 0, errors.New("cannot find serial devices")
+	}
+	cfg, err := ReadConfig()
+	if err != nil {
+		return "", 0, err
+	}
+
+	var candidates []string
+	for _, d := range devices {
+		if d.Name == cfg.Device {
+			candidates = append(candidates, d.Name)
+		}
+	}
+	for _, d := range devices {
+		if d.USB && d.Name != cfg.Device {
+			candidates = append(candidates, d.Name)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, d := range devices {
+			candidates = append(candidates, d.Name)
+		}
+	}
+	for _, name := range candidates {
+		rate := baud
+		var err error
+		if rate == 0 {
+			rate, err = DetectBaudRate(name)
+		} else {
+			err = Probe(name, rate, probeTimeout)
+		}
+		if err != nil {
+			continue
+		}
+		if err := RememberDevice(name); err != nil {
+			return "", 0, err
+		}
+		return name, rate, nil
+	}
+	return "", 0, errors.Errorf("cannot find a Net Yaroze on %s", strings.Join(candidates, ", "))
+}
+
+// Config holds settings remembered between runs.
+type Config struct {
+	// Device is the last serial device found to be connected to a Net
+	// Yaroze.
+	Device string `json:"device,omitempty"`
+}
+
+// ConfigPath returns the location of the configuration file, in the user
+// configuration directory.
+func ConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "psxsdk", "yaroze.json"), nil
+}
+
+// ReadConfig reads the configuration file, returning an empty Config if there
+// isn't one.
+func ReadConfig() (*Config, error) {
+	name, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", name)
+	}
+	return cfg, nil
+}
+
+// Write replaces the configuration file with c.
+func (c *Config) Write() error {
+	name, err := ConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// RememberDevice records name as the last device found to be connected to a
+// Net Yaroze.
+func RememberDevice(name string) error {
+	cfg, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Device == name {
+		return nil
+	}
+	cfg.Device = name
+	return cfg.Write()
+}
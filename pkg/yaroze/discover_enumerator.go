@@ -0,0 +1,24 @@
+//go:build !darwin || cgo
+// +build !darwin cgo
+
+package yaroze
+
+import "go.bug.st/serial/enumerator"
+
+func detailedPorts() ([]*Device, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(ports))
+	for _, p := range ports {
+		devices = append(devices, &Device{
+			Name:         p.Name,
+			USB:          p.IsUSB,
+			VID:          p.VID,
+			PID:          p.PID,
+			SerialNumber: p.SerialNumber,
+		})
+	}
+	return devices, nil
+}
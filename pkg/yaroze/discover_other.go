@@ -0,0 +1,20 @@
+//go:build darwin && !cgo
+// +build darwin,!cgo
+
+package yaroze
+
+import "go.bug.st/serial"
+
+// detailedPorts falls back to listing port names, since USB details can't be
+// enumerated on darwin without cgo.
+func detailedPorts() ([]*Device, error) {
+	ports, err := serial.GetPortsList()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*Device, 0, len(ports))
+	for _, name := range ports {
+		devices = append(devices, &Device{Name: name})
+	}
+	return devices, nil
+}
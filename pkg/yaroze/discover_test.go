@@ -0,0 +1,163 @@
+package yaroze
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+// silentTransport is a serial device that never responds.
+type silentTransport struct {
+	done   chan struct{}
+	closes int32
+}
+
+func (t *silentTransport) Read(p []byte) (int, error) {
+	<-t.done
+	return 0, io.EOF
+}
+
+func (t *silentTransport) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (t *silentTransport) Close() error {
+	atomic.AddInt32(&t.closes, 1)
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	return nil
+}
+
+func (t *silentTransport) SetRTS(rts bool) error {
+	return nil
+}
+
+func (t *silentTransport) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{CTS: true}, nil
+}
+
+func TestSortDevices(t *testing.T) {
+	devices := []*Device{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB1", USB: true, VID: "2341", PID: "0043"},
+		{Name: "/dev/ttyS1"},
+		{Name: "/dev/ttyUSB2", USB: true, VID: "067B", PID: "2303", Adapter: "Prolific PL2303"},
+		{Name: "/dev/ttyUSB0", USB: true, VID: "1234", PID: "5678"},
+	}
+	sortDevices(devices)
+	var names []string
+	for _, d := range devices {
+		names = append(names, d.Name)
+	}
+	expected := []string{"/dev/ttyUSB2", "/dev/ttyUSB0", "/dev/ttyUSB1", "/dev/ttyS0", "/dev/ttyS1"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, received %v", expected, names)
+	}
+}
+
+func TestFindDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	os.Setenv("HOME", dir)
+
+	defer func(list func() ([]*Device, error), open func(string, int) (Transport, error), timeout time.Duration) {
+		listDevices, openSerial, probeTimeout = list, open, timeout
+	}(listDevices, openSerial, probeTimeout)
+	probeTimeout = 20 * time.Millisecond
+
+	listDevices = func() ([]*Device, error) {
+		return []*Device{
+			{Name: "/dev/ttyUSB0", USB: true, Adapter: "Prolific PL2303"},
+			{Name: "/dev/ttyUSB1", USB: true},
+			{Name: "/dev/ttyS0"},
+		}, nil
+	}
+	var opened []string
+	openSerial = func(name string, baud int) (Transport, error) {
+		opened = append(opened, name)
+		switch name {
+		case "/dev/ttyUSB0":
+			return &silentTransport{done: make(chan struct{})}, nil
+		case "/dev/ttyUSB1":
+			c := yarozetest.NewConsole()
+			c.SetBaudRate(38400)
+			c.SetMode(&serial.Mode{BaudRate: baud})
+			return c, nil
+		}
+		return nil, errors.Errorf("cannot open %s", name)
+	}
+
+	name, rate, err := FindDevice(38400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "/dev/ttyUSB1" || rate != 38400 {
+		t.Fatalf("expected /dev/ttyUSB1 at 38400, received %s at %d", name, rate)
+	}
+	if expected := []string{"/dev/ttyUSB0", "/dev/ttyUSB1"}; !reflect.DeepEqual(opened, expected) {
+		t.Fatalf("expected to probe %v, probed %v", expected, opened)
+	}
+
+	// The device found is remembered and tried first.
+	cfg, err := ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Device != "/dev/ttyUSB1" {
+		t.Fatalf("expected /dev/ttyUSB1 to be remembered, received %q", cfg.Device)
+	}
+	opened = nil
+	if _, _, err := FindDevice(38400); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"/dev/ttyUSB1"}; !reflect.DeepEqual(opened, expected) {
+		t.Fatalf("expected to probe %v, probed %v", expected, opened)
+	}
+
+	// Without a baud rate, the rate the console responds at is returned.
+	name, rate, err = FindDevice(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "/dev/ttyUSB1" || rate != 38400 {
+		t.Fatalf("expected /dev/ttyUSB1 at 38400, received %s at %d", name, rate)
+	}
+}
+
+func TestOpenPortClosesOnce(t *testing.T) {
+	defer func(open func(string, int) (Transport, error)) {
+		openSerial = open
+	}(openSerial)
+	silent := &silentTransport{done: make(chan struct{})}
+	openSerial = func(name string, baud int) (Transport, error) {
+		return silent, nil
+	}
+	_, err := OpenPort(&PortConfig{
+		DeviceName: "/dev/ttyUSB0",
+		BaudRate:   9600,
+		Timeout:    20 * time.Millisecond,
+	})
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout, received %v", err)
+	}
+	if n := atomic.LoadInt32(&silent.closes); n != 1 {
+		t.Errorf("expected the device to be closed once, closed %d times", n)
+	}
+}
@@ -0,0 +1,66 @@
+package yaroze
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// A TimeoutError is returned when the console does not respond before the
+// deadline of an operation is exceeded.
+type TimeoutError struct {
+	Op string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("yaroze: %s: timed out waiting for console", e.Op)
+}
+
+// Timeout reports whether the error was caused by a timeout, so it can be
+// identified in the same way as net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// A NAKError is returned when the console rejects a block of data sent during
+// a binary transfer, usually because the checksum did not match.
+type NAKError struct {
+	Block    int
+	Response byte
+}
+
+func (e *NAKError) Error() string {
+	return fmt.Sprintf("yaroze: block %d rejected by console (response 0x%02X)", e.Block, e.Response)
+}
+
+// A BlockError is returned when a block could not be delivered to the console
+// after exhausting all retries. Block is the index of the first block that was
+// not acknowledged, from which the transfer can be resumed.
+type BlockError struct {
+	Block int
+	Err   error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("yaroze: giving up on block %d: %v", e.Block, e.Err)
+}
+
+// Cause returns the error from the final attempt to send the block.
+func (e *BlockError) Cause() error { return e.Err }
+
+// A PromptError is returned when the monitor returns to the command prompt
+// while a different response was expected, typically because the command
+// was not accepted.
+type PromptError struct {
+	Expected string
+	Received string
+}
+
+func (e *PromptError) Error() string {
+	return fmt.Sprintf("yaroze: expected %q, received prompt: %q", e.Expected, e.Received)
+}
+
+// IsTimeout reports whether err, or the error that caused it, is a
+// TimeoutError.
+func IsTimeout(err error) bool {
+	_, ok := errors.Cause(err).(*TimeoutError)
+	return ok
+}
@@ -0,0 +1,374 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+The file server protocol lets a running program access files on the host over
+the serial link, in the same way PCopen/PCread/PCwrite/PClseek/PCclose work
+with the PC file server of the other Playstation development systems.
+
+The protocol is defined here rather than taken from the Net Yaroze, which has
+no file server: neither the monitor nor libps answer or make such requests,
+and the PCopen family of the professional tools works over the link of the
+development board, not the serial port. A program using it must therefore
+include a client speaking this format, which is pinned down by the exchange
+in testdata/fileserver.transcript.
+
+Since the link also carries the output of the program, each request and reply
+starts with the marker ESC 'P' 'C' followed by an opcode. Integers are 32-bit
+big-endian (the same as the bwr handshake) and strings are NUL-terminated.
+
+  Request                                     Reply
+  'o' open   mode, path                       result (handle)
+  'c' creat  attr, path                       result (handle)
+  'r' read   handle, length                   result (length read), data
+  'w' write  handle, length, data             result (length written)
+  's' seek   handle, offset, whence           result (new offset)
+  'x' close  handle                           result
+
+Every reply echoes the opcode of the request and a result of -1 indicates an
+error. Modes for open are 0 (read), 1 (write) and 2 (read/write).
+*/
+
+// FileServerMarker starts every file server request and reply.
+const FileServerMarker = "\x1bPC"
+
+// File server opcodes.
+const (
+	PCOpen  = 'o'
+	PCCreat = 'c'
+	PCRead  = 'r'
+	PCWrite = 'w'
+	PCSeek  = 's'
+	PCClose = 'x'
+)
+
+const (
+	// maxPathLength limits the length of a path sent by the console.
+	maxPathLength = 255
+
+	// maxTransfer limits the amount of data read or written by a single
+	// request.
+	maxTransfer = 64 * 1024
+)
+
+// A FileServer answers file requests made by a program running on the
+// console against the files of a local directory. Requests are not allowed to
+// escape the directory.
+type FileServer struct {
+	root  string
+	files map[int32]*os.File
+	next  int32
+}
+
+// NewFileServer returns a FileServer serving files from the directory root.
+func NewFileServer(root string) (*FileServer, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", root)
+	}
+	return &FileServer{
+		root:  root,
+		files: make(map[int32]*os.File),
+		next:  1,
+	}, nil
+}
+
+// Close closes any files left open by the program.
+func (s *FileServer) Close() error {
+	var err error
+	for h, f := range s.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.files, h)
+	}
+	return err
+}
+
+// Serve copies the output of the running program to w, answering file
+// requests, until ctx is done or communication with the console fails.
+func (s *FileServer) Serve(ctx context.Context, p *Port, w io.Writer) error {
+	for {
+		data, err := s.Recv(ctx, p)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Recv is like Port.Recv, but answers any file requests found in the output
+// of the running program instead of returning them.
+func (s *FileServer) Recv(ctx context.Context, p *Port) ([]byte, error) {
+	marker := []byte(FileServerMarker)
+	for {
+		if len(p.buf) == 0 {
+			if err := p.fill(ctx, "read"); err != nil {
+				return nil, err
+			}
+		}
+		if i := bytes.Index(p.buf, marker); i >= 0 {
+			out := append([]byte(nil), p.buf[:i]...)
+			p.buf = p.buf[i+len(marker):]
+			hctx, cancel := requestContext(ctx)
+			defer cancel()
+			return out, s.handle(hctx, p)
+		}
+
+		// hold back anything that could be the start of a marker
+		k := partialSuffix(p.buf, marker)
+		if k == len(p.buf) {
+			if err := p.fill(ctx, "read"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out := append([]byte(nil), p.buf[:len(p.buf)-k]...)
+		p.buf = p.buf[len(p.buf)-k:]
+		return out, nil
+	}
+}
+
+// requestContext returns the context a request is answered in once its marker
+// has been consumed. The deadline of ctx, such as that of a short poll for
+// output, doesn't apply, as the rest of the request must be read and answered
+// to stay in step with the program. Each read is still bounded by the timeout
+// of the port, and cancelling ctx still ends the request.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	rctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.Canceled {
+				cancel()
+			}
+		case <-rctx.Done():
+		}
+	}()
+	return rctx, cancel
+}
+
+// partialSuffix returns the length of the longest suffix of data that is a
+// prefix of marker.
+func partialSuffix(data, marker []byte) int {
+	for k := len(marker) - 1; k > 0; k-- {
+		if len(data) >= k && bytes.HasSuffix(data, marker[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+func (s *FileServer) handle(ctx context.Context, p *Port) error {
+	op, err := p.RecvByte(ctx)
+	if err != nil {
+		return err
+	}
+	var result int32
+	var data []byte
+	switch op {
+	case PCOpen, PCCreat:
+		mode, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		name, err := p.recvString(ctx)
+		if err != nil {
+			return err
+		}
+		result = s.open(name, op == PCCreat, mode)
+	case PCRead:
+		h, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		n, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		result, data = s.read(int32(h), n)
+	case PCWrite:
+		h, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		n, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		if n > maxTransfer {
+			return errors.Errorf("file server write of %d bytes is too large", n)
+		}
+		buf := make([]byte, n)
+		for i := range buf {
+			if buf[i], err = p.RecvByte(ctx); err != nil {
+				return err
+			}
+		}
+		result = s.write(int32(h), buf)
+	case PCSeek:
+		h, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		offset, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		whence, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		result = s.seek(int32(h), int32(offset), int(whence))
+	case PCClose:
+		h, err := p.recvUint32(ctx)
+		if err != nil {
+			return err
+		}
+		result = s.close(int32(h))
+	default:
+		return errors.Errorf("unknown file server request 0x%02X", op)
+	}
+
+	reply := make([]byte, 0, len(FileServerMarker)+5+len(data))
+	reply = append(reply, FileServerMarker...)
+	reply = append(reply, op, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(reply[len(reply)-4:], uint32(result))
+	reply = append(reply, data...)
+	return p.Write(ctx, reply)
+}
+
+// resolve maps a path requested by the console to a path within the root
+// directory. Backslashes are treated as separators and any device prefix,
+// such as "sim:" or "c:", is ignored.
+func (s *FileServer) resolve(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *FileServer) open(name string, create bool, mode uint32) int32 {
+	flag := os.O_RDONLY
+	switch {
+	case create:
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	case mode == 1:
+		flag = os.O_WRONLY
+	case mode == 2:
+		flag = os.O_RDWR
+	}
+	f, err := os.OpenFile(s.resolve(name), flag, 0644)
+	if err != nil {
+		return -1
+	}
+	h := s.next
+	s.next++
+	s.files[h] = f
+	return h
+}
+
+func (s *FileServer) read(h int32, n uint32) (int32, []byte) {
+	f, ok := s.files[h]
+	if !ok {
+		return -1, nil
+	}
+	if n > maxTransfer {
+		n = maxTransfer
+	}
+	buf := make([]byte, n)
+	m, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return -1, nil
+	}
+	return int32(m), buf[:m]
+}
+
+func (s *FileServer) write(h int32, data []byte) int32 {
+	f, ok := s.files[h]
+	if !ok {
+		return -1
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		return -1
+	}
+	return int32(n)
+}
+
+func (s *FileServer) seek(h int32, offset int32, whence int) int32 {
+	f, ok := s.files[h]
+	if !ok || whence < 0 || whence > 2 {
+		return -1
+	}
+	n, err := f.Seek(int64(offset), whence)
+	if err != nil {
+		return -1
+	}
+	return int32(n)
+}
+
+func (s *FileServer) close(h int32) int32 {
+	f, ok := s.files[h]
+	if !ok {
+		return -1
+	}
+	delete(s.files, h)
+	if err := f.Close(); err != nil {
+		return -1
+	}
+	return 0
+}
+
+func (p *Port) recvUint32(ctx context.Context) (uint32, error) {
+	var b [4]byte
+	for i := range b {
+		v, err := p.RecvByte(ctx)
+		if err != nil {
+			return 0, err
+		}
+		b[i] = v
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func (p *Port) recvString(ctx context.Context) (string, error) {
+	var sb strings.Builder
+	for {
+		b, err := p.RecvByte(ctx)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return sb.String(), nil
+		}
+		if sb.Len() >= maxPathLength {
+			return "", errors.New("file server path is too long")
+		}
+		sb.WriteByte(b)
+	}
+}
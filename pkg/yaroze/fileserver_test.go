@@ -0,0 +1,272 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+)
+
+// pcRequest encodes a file server request as sent by the running program.
+func pcRequest(op byte, args ...interface{}) []byte {
+	buf := append([]byte(FileServerMarker), op)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			buf = append(buf, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(buf[len(buf)-4:], v)
+		case string:
+			buf = append(buf, v...)
+			buf = append(buf, 0)
+		case []byte:
+			buf = append(buf, v...)
+		}
+	}
+	return buf
+}
+
+// pcCall sends a request from the program and returns the result and data of
+// the reply.
+func pcCall(t *testing.T, s *FileServer, p *Port, c *yarozetest.Console, req []byte) (int32, []byte) {
+	t.Helper()
+	c.Print(req)
+	out, err := s.Recv(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 0 {
+		t.Fatalf("unexpected output %q", out)
+	}
+	reply := c.Input()
+	if len(reply) < len(FileServerMarker)+5 || !bytes.HasPrefix(reply, []byte(FileServerMarker)) {
+		t.Fatalf("invalid reply %q", reply)
+	}
+	reply = reply[len(FileServerMarker):]
+	if reply[0] != req[len(FileServerMarker)] {
+		t.Fatalf("expected reply to opcode %q, received %q", req[len(FileServerMarker)], reply[0])
+	}
+	return int32(binary.BigEndian.Uint32(reply[1:])), reply[5:]
+}
+
+func newTestFileServer(t *testing.T) (*FileServer, *Port, *yarozetest.Console, string, func()) {
+	dir, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileServer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, c := newTestPort(t)
+	if err := p.Go(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReadUntil(context.Background(), "\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	return s, p, c, dir, func() {
+		s.Close()
+		p.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	s, p, c, dir, cleanup := newTestFileServer(t)
+	defer cleanup()
+
+	content := []byte("0123456789abcdef")
+	if err := ioutil.WriteFile(filepath.Join(dir, "root", "data.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, _ := pcCall(t, s, p, c, pcRequest(PCOpen, uint32(0), `sim:\data.bin`))
+	if h < 0 {
+		t.Fatal("expected open to succeed")
+	}
+	n, data := pcCall(t, s, p, c, pcRequest(PCRead, uint32(h), uint32(10)))
+	if n != 10 || !bytes.Equal(data, content[:10]) {
+		t.Fatalf("expected %q, received %d bytes %q", content[:10], n, data)
+	}
+	if off, _ := pcCall(t, s, p, c, pcRequest(PCSeek, uint32(h), uint32(4), uint32(0))); off != 4 {
+		t.Fatalf("expected offset 4, received %d", off)
+	}
+	n, data = pcCall(t, s, p, c, pcRequest(PCRead, uint32(h), uint32(100)))
+	if n != 12 || !bytes.Equal(data, content[4:]) {
+		t.Fatalf("expected %q, received %d bytes %q", content[4:], n, data)
+	}
+	if r, _ := pcCall(t, s, p, c, pcRequest(PCClose, uint32(h))); r != 0 {
+		t.Fatalf("expected close to succeed, received %d", r)
+	}
+	if r, _ := pcCall(t, s, p, c, pcRequest(PCClose, uint32(h))); r != -1 {
+		t.Fatal("expected close of a closed handle to fail")
+	}
+
+	h, _ = pcCall(t, s, p, c, pcRequest(PCCreat, uint32(0), "out/../save.dat"))
+	if h < 0 {
+		t.Fatal("expected creat to succeed")
+	}
+	if n, _ := pcCall(t, s, p, c, pcRequest(PCWrite, uint32(h), uint32(5), []byte("saved"))); n != 5 {
+		t.Fatalf("expected 5 bytes written, received %d", n)
+	}
+	pcCall(t, s, p, c, pcRequest(PCClose, uint32(h)))
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "root", "save.dat")); err != nil || string(got) != "saved" {
+		t.Fatalf("expected file to contain %q, received %q (%v)", "saved", got, err)
+	}
+}
+
+func TestFileServerSandbox(t *testing.T) {
+	s, p, c, dir, cleanup := newTestFileServer(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"../secret", `..\..\secret`, "/../secret", "c:../secret"} {
+		if h, _ := pcCall(t, s, p, c, pcRequest(PCOpen, uint32(0), name)); h != -1 {
+			t.Errorf("expected open of %q to fail, received handle %d", name, h)
+		}
+	}
+}
+
+func TestFileServerOutput(t *testing.T) {
+	s, p, c, _, cleanup := newTestFileServer(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Output around a request is passed through, including a marker split
+	// across reads.
+	c.Print([]byte("hello\x1bP"))
+	out, err := s.Recv(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Fatalf("expected %q, received %q", "hello", out)
+	}
+	c.Print(append(pcRequest(PCClose, uint32(42))[2:], " world\x1b[2J"...))
+	var buf bytes.Buffer
+	for buf.Len() < len(" world\x1b[2J") {
+		out, err := s.Recv(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(out)
+	}
+	if buf.String() != " world\x1b[2J" {
+		t.Fatalf("expected %q, received %q", " world\x1b[2J", buf.String())
+	}
+	if reply := c.Input(); !bytes.Equal(reply, []byte(FileServerMarker+"x\xff\xff\xff\xff")) {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+}
+
+// A request arriving slowly is still read in full when output is polled with
+// a deadline much shorter than the time the request takes, as siocons does.
+func TestFileServerSlowRequest(t *testing.T) {
+	s, p, c, _, cleanup := newTestFileServer(t)
+	defer cleanup()
+
+	h, _ := pcCall(t, s, p, c, pcRequest(PCCreat, uint32(0), "slow.dat"))
+	if h < 0 {
+		t.Fatal("expected creat to succeed")
+	}
+	data := bytes.Repeat([]byte("0123456789"), 20)
+	req := append(pcRequest(PCWrite, uint32(h), uint32(len(data)), data), "after"...)
+	go func() {
+		for i := 0; i < len(req); i += 40 {
+			j := i + 40
+			if j > len(req) {
+				j = len(req)
+			}
+			c.Print(req[i:j])
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var out []byte
+	for !bytes.HasSuffix(out, []byte("after")) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Millisecond)
+		data, err := s.Recv(ctx, p)
+		cancel()
+		if err != nil && !IsTimeout(err) {
+			t.Fatal(err)
+		}
+		out = append(out, data...)
+	}
+	if string(out) != "after" {
+		t.Fatalf("expected %q, received %q", "after", out)
+	}
+	reply := []byte(FileServerMarker + "w\x00\x00\x00\xc8")
+	if got := c.Input(); !bytes.Equal(got, reply) {
+		t.Fatalf("expected reply %q, received %q", reply, got)
+	}
+}
+
+// The wire format is pinned by replaying a transcript of a complete exchange,
+// so a change to the framing can't go unnoticed by also changing pcRequest.
+func TestReplayFileServer(t *testing.T) {
+	f, err := os.Open("testdata/fileserver.transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rp, err := NewReplay(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "DATA.BIN"), []byte("TIM\x10rest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p, err := NewPort(rp, &PortConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+	if err := p.Go(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReadUntil(ctx, "\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for !bytes.HasSuffix(out, []byte("done\r\n")) {
+		data, err := s.Recv(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, data...)
+	}
+	if err := rp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "loading\r\ndone\r\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if len(s.files) != 0 {
+		t.Fatal("expected the file to be closed")
+	}
+}
@@ -0,0 +1,838 @@
+package yaroze
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/disasm"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/pkg/errors"
+)
+
+/*
+A GDBStub lets GDB debug a program on the console by translating the GDB
+remote serial protocol into monitor commands:
+
+  g G p P        dr and sr
+  m              dw
+  M              bwr
+  Z0 z0          software breakpoints, written with bwr
+  c s            go, waiting for the monitor prompt to return
+  qRcmd          any monitor command, run with "monitor <command>" in GDB
+
+Registers are numbered as GDB expects for 32-bit MIPS: the general purpose
+registers, followed by sr, lo, hi, badvaddr, cause and pc, and then the
+floating point registers, which the console doesn't have and are reported as
+unavailable along with badvaddr.
+
+The monitor can't single step, so s is emulated by placing breakpoints on
+every instruction that could follow the current one. The monitor regains
+control when the program executes a break instruction (or raises any other
+exception) and the stop is reported to GDB with a signal derived from the
+Cause register. A GDB interrupt (Ctrl-C) is passed on to the console in the
+same way.
+*/
+
+// BreakInstruction is written over an instruction to set a software
+// breakpoint.
+const BreakInstruction = 0x0000000d
+
+const (
+	// gdbRegisterCount is the number of registers GDB expects for 32-bit
+	// MIPS.
+	gdbRegisterCount = 72
+	gdbRegisterPC    = 37
+	gdbRegisterSP    = 29
+
+	// gdbPacketSize is the largest packet accepted from GDB.
+	gdbPacketSize = 4096
+)
+
+// Signal numbers reported to GDB, which uses its own numbering rather than
+// that of the host.
+const (
+	gdbSIGINT  = 2
+	gdbSIGILL  = 4
+	gdbSIGTRAP = 5
+	gdbSIGFPE  = 8
+	gdbSIGBUS  = 10
+	gdbSIGSEGV = 11
+)
+
+// errDetached ends a GDB session once the reply to the current packet has
+// been sent.
+var errDetached = errors.New("detached")
+
+// gdbRegisterName returns the monitor name of GDB register n, or an empty
+// string if the monitor doesn't have it.
+func gdbRegisterName(n int) string {
+	switch {
+	case n < 32:
+		return GPRNames[n]
+	case n == 32:
+		return "sr"
+	case n == 33:
+		return "lo"
+	case n == 34:
+		return "hi"
+	case n == 36:
+		return "cause"
+	case n == gdbRegisterPC:
+		return "epc"
+	}
+	return ""
+}
+
+// exceptionSignal returns the signal reported to GDB for an exception with the
+// given Cause register.
+func exceptionSignal(cause uint32) int {
+	switch (cause >> 2) & 0x1f {
+	case 0:
+		return gdbSIGINT
+	case 1, 2, 3:
+		return gdbSIGSEGV
+	case 4, 5, 6, 7:
+		return gdbSIGBUS
+	case 10, 11:
+		return gdbSIGILL
+	case 12:
+		return gdbSIGFPE
+	}
+	return gdbSIGTRAP
+}
+
+// A GDBStub debugs the program on the console on behalf of GDB.
+type GDBStub struct {
+	p *Port
+
+	// Output receives the output of the program while it is running.
+	Output io.Writer
+
+	// Log, if set, receives a message each time GDB connects or disconnects
+	// and each time the program stops.
+	Log *log.Logger
+
+	symbols     disasm.Symbols
+	addrs       []uint32
+	textStart   uint32
+	textEnd     uint32
+	breakpoints map[uint32][]byte
+	regs        Registers
+	signal      int
+}
+
+// NewGDBStub returns a GDBStub debugging the program on the console connected
+// to p.
+func NewGDBStub(p *Port) *GDBStub {
+	return &GDBStub{
+		p:           p,
+		Output:      ioutil.Discard,
+		breakpoints: make(map[uint32][]byte),
+		signal:      gdbSIGTRAP,
+	}
+}
+
+// SetSymbols uses the symbols of f to describe where the program stopped,
+// choosing the same symbol for each address as objdump.
+func (s *GDBStub) SetSymbols(f *ecoff.File) {
+	s.symbols = binutils.ECOFFSymbols(f)
+	s.addrs = make([]uint32, 0, len(s.symbols))
+	for addr := range s.symbols {
+		s.addrs = append(s.addrs, addr)
+	}
+	sort.Slice(s.addrs, func(i, j int) bool { return s.addrs[i] < s.addrs[j] })
+	s.textStart = f.TextStart
+	s.textEnd = f.TextStart + uint32(f.TextSize)
+}
+
+// Serve accepts connections from GDB on l until it is closed, handling one
+// at a time.
+func (s *GDBStub) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.logf("%s connected", conn.RemoteAddr())
+		if err := s.ServeConn(context.Background(), conn); err != nil {
+			s.logf("%s disconnected: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		s.logf("%s disconnected", conn.RemoteAddr())
+	}
+}
+
+// ServeConn handles a single GDB session on conn, closing it when GDB
+// detaches or disconnects. Any breakpoints left behind by GDB are removed.
+func (s *GDBStub) ServeConn(ctx context.Context, conn io.ReadWriteCloser) error {
+	c := newRSPConn(conn)
+	defer conn.Close()
+	defer s.clearBreakpoints(ctx)
+	s.regs = nil
+	for {
+		select {
+		case pkt, ok := <-c.packets:
+			if !ok {
+				if c.err == io.EOF {
+					return nil
+				}
+				return c.err
+			}
+			reply, err := s.handle(ctx, c, pkt)
+			if err != nil && err != errDetached {
+				return err
+			}
+			if reply != "" || err == nil {
+				if err := c.send(reply); err != nil {
+					return err
+				}
+			}
+			if err == errDetached {
+				return nil
+			}
+		case <-c.interrupts:
+			// the program isn't running
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *GDBStub) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log.Printf(format, args...)
+	}
+}
+
+// fail logs err and returns the error reply sent to GDB.
+func (s *GDBStub) fail(err error) string {
+	s.logf("%v", err)
+	return "E01"
+}
+
+// handle returns the reply to a single packet. Errors are only returned when
+// the session can't continue.
+func (s *GDBStub) handle(ctx context.Context, c *rspConn, pkt string) (string, error) {
+	if pkt == "" {
+		return "", nil
+	}
+	args := pkt[1:]
+	switch pkt[0] {
+	case '?':
+		return s.stopReply(ctx), nil
+	case 'g':
+		return s.readRegisters(ctx), nil
+	case 'G':
+		return s.writeRegisters(ctx, args), nil
+	case 'p':
+		return s.readRegister(ctx, args), nil
+	case 'P':
+		return s.writeRegister(ctx, args), nil
+	case 'm':
+		return s.readMemory(ctx, args), nil
+	case 'M':
+		return s.writeMemory(ctx, args), nil
+	case 'Z', 'z':
+		return s.breakpoint(ctx, args, pkt[0] == 'Z'), nil
+	case 'c':
+		return s.resume(ctx, c, args, false)
+	case 's':
+		return s.resume(ctx, c, args, true)
+	case 'D':
+		if err := s.clearBreakpoints(ctx); err != nil {
+			return s.fail(err), nil
+		}
+		if err := s.p.Go(ctx); err != nil {
+			return s.fail(err), nil
+		}
+		return "OK", errDetached
+	case 'k':
+		return "", errDetached
+	case 'H':
+		return "OK", nil
+	case 'q':
+		return s.query(ctx, c, args), nil
+	case 'Q':
+		if args == "StartNoAckMode" {
+			atomic.StoreInt32(&c.noAck, 1)
+			return "OK", nil
+		}
+	}
+	return "", nil
+}
+
+func (s *GDBStub) query(ctx context.Context, c *rspConn, q string) string {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+", gdbPacketSize)
+	case q == "Attached":
+		return "1"
+	case q == "fThreadInfo":
+		return "m1"
+	case q == "sThreadInfo":
+		return "l"
+	case q == "Symbol::":
+		return "OK"
+	case strings.HasPrefix(q, "Rcmd,"):
+		command, err := hex.DecodeString(q[len("Rcmd,"):])
+		if err != nil {
+			return s.fail(errors.Wrap(err, "invalid monitor command"))
+		}
+		resp, err := s.p.Exec(ctx, string(command))
+		s.regs = nil
+		if err != nil {
+			return s.fail(err)
+		}
+		if resp != "" {
+			if err := c.send("O" + hex.EncodeToString([]byte(resp))); err != nil {
+				return s.fail(err)
+			}
+		}
+		return "OK"
+	}
+	return ""
+}
+
+// registers returns the registers of the console, which are only read once
+// each time the program stops.
+func (s *GDBStub) registers(ctx context.Context) (Registers, error) {
+	if s.regs == nil {
+		regs, err := s.p.Registers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.regs = regs
+	}
+	return s.regs, nil
+}
+
+func (s *GDBStub) setRegister(ctx context.Context, name string, v uint32) error {
+	if err := s.p.SetRegister(ctx, name, v); err != nil {
+		return err
+	}
+	if s.regs != nil {
+		s.regs[name] = v
+	}
+	return nil
+}
+
+// formatRegister formats a register value as GDB expects, in target (little
+// endian) byte order.
+func formatRegister(v uint32) string {
+	return fmt.Sprintf("%08x", bits.ReverseBytes32(v))
+}
+
+func parseRegister(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return bits.ReverseBytes32(uint32(v)), nil
+}
+
+func (s *GDBStub) readRegisters(ctx context.Context) string {
+	regs, err := s.registers(ctx)
+	if err != nil {
+		return s.fail(err)
+	}
+	var b strings.Builder
+	for n := 0; n < gdbRegisterCount; n++ {
+		name := gdbRegisterName(n)
+		if name == "" {
+			b.WriteString("xxxxxxxx")
+			continue
+		}
+		b.WriteString(formatRegister(regs[name]))
+	}
+	return b.String()
+}
+
+func (s *GDBStub) writeRegisters(ctx context.Context, args string) string {
+	regs, err := s.registers(ctx)
+	if err != nil {
+		return s.fail(err)
+	}
+	for n := 0; n < gdbRegisterCount && len(args) >= (n+1)*8; n++ {
+		name := gdbRegisterName(n)
+		field := args[n*8 : (n+1)*8]
+		if name == "" || name == "zero" || strings.Contains(field, "x") {
+			continue
+		}
+		v, err := parseRegister(field)
+		if err != nil {
+			return s.fail(errors.Wrapf(err, "invalid value for %s", name))
+		}
+		if v == regs[name] {
+			continue
+		}
+		if err := s.setRegister(ctx, name, v); err != nil {
+			return s.fail(err)
+		}
+	}
+	return "OK"
+}
+
+func (s *GDBStub) readRegister(ctx context.Context, args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil {
+		return s.fail(errors.Wrapf(err, "invalid register %q", args))
+	}
+	name := gdbRegisterName(int(n))
+	if name == "" {
+		return "xxxxxxxx"
+	}
+	regs, err := s.registers(ctx)
+	if err != nil {
+		return s.fail(err)
+	}
+	return formatRegister(regs[name])
+}
+
+func (s *GDBStub) writeRegister(ctx context.Context, args string) string {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return s.fail(errors.Errorf("invalid register write %q", args))
+	}
+	n, err := strconv.ParseUint(args[:i], 16, 8)
+	if err != nil {
+		return s.fail(errors.Wrapf(err, "invalid register %q", args[:i]))
+	}
+	name := gdbRegisterName(int(n))
+	if name == "zero" {
+		return "OK"
+	}
+	if name == "" {
+		return s.fail(errors.Errorf("register %d cannot be written", n))
+	}
+	v, err := parseRegister(args[i+1:])
+	if err != nil {
+		return s.fail(errors.Wrapf(err, "invalid value for %s", name))
+	}
+	if err := s.setRegister(ctx, name, v); err != nil {
+		return s.fail(err)
+	}
+	return "OK"
+}
+
+// parseAddrLength parses the addr,length arguments of memory and breakpoint
+// packets. GDB may sign extend addresses, so only the low 32 bits are used.
+func parseAddrLength(s string) (uint32, int, error) {
+	i := strings.IndexByte(s, ',')
+	if i < 0 {
+		return 0, 0, errors.Errorf("invalid address %q", s)
+	}
+	addr, err := strconv.ParseUint(s[:i], 16, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid address %q", s[:i])
+	}
+	n, err := strconv.ParseUint(s[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid length %q", s[i+1:])
+	}
+	return uint32(addr), int(n), nil
+}
+
+func (s *GDBStub) readMemory(ctx context.Context, args string) string {
+	addr, n, err := parseAddrLength(args)
+	if err != nil {
+		return s.fail(err)
+	}
+	if n > gdbPacketSize/2 {
+		n = gdbPacketSize / 2
+	}
+	data, err := s.p.ReadMemory(ctx, addr, n)
+	if err != nil {
+		return s.fail(err)
+	}
+	return hex.EncodeToString(data)
+}
+
+func (s *GDBStub) writeMemory(ctx context.Context, args string) string {
+	i := strings.IndexByte(args, ':')
+	if i < 0 {
+		return s.fail(errors.Errorf("invalid memory write %q", args))
+	}
+	addr, n, err := parseAddrLength(args[:i])
+	if err != nil {
+		return s.fail(err)
+	}
+	data, err := hex.DecodeString(args[i+1:])
+	if err != nil || len(data) != n {
+		return s.fail(errors.Errorf("invalid data for %d bytes at 0x%08X", n, addr))
+	}
+	if n == 0 {
+		return "OK"
+	}
+	if err := s.p.WriteMemory(ctx, addr, data); err != nil {
+		return s.fail(err)
+	}
+	return "OK"
+}
+
+func (s *GDBStub) breakpoint(ctx context.Context, args string, insert bool) string {
+	// only software breakpoints are supported
+	if !strings.HasPrefix(args, "0,") {
+		return ""
+	}
+	addr, _, err := parseAddrLength(args[2:])
+	if err != nil {
+		return s.fail(err)
+	}
+	if insert {
+		err = s.insertBreakpoint(ctx, addr)
+	} else {
+		err = s.removeBreakpoint(ctx, addr)
+	}
+	if err != nil {
+		return s.fail(err)
+	}
+	return "OK"
+}
+
+// insertBreakpoint replaces the instruction at addr with a break instruction,
+// saving the original. As the monitor only writes whole blocks, the rest of
+// the block written is read back first by WriteMemory, so the instructions
+// following a breakpoint are kept.
+func (s *GDBStub) insertBreakpoint(ctx context.Context, addr uint32) error {
+	if addr&3 != 0 {
+		return errors.Errorf("breakpoint address 0x%08X is not aligned", addr)
+	}
+	if _, ok := s.breakpoints[addr]; ok {
+		return nil
+	}
+	orig, err := s.p.ReadMemory(ctx, addr, 4)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, BreakInstruction)
+	if err := s.p.WriteMemory(ctx, addr, data); err != nil {
+		return err
+	}
+	s.breakpoints[addr] = orig
+	return nil
+}
+
+// removeBreakpoint restores the instruction replaced by the breakpoint at
+// addr, again writing a whole block of the memory as it is.
+func (s *GDBStub) removeBreakpoint(ctx context.Context, addr uint32) error {
+	orig, ok := s.breakpoints[addr]
+	if !ok {
+		return nil
+	}
+	if err := s.p.WriteMemory(ctx, addr, orig); err != nil {
+		return err
+	}
+	delete(s.breakpoints, addr)
+	return nil
+}
+
+// clearBreakpoints removes every breakpoint, restoring the original
+// instructions.
+func (s *GDBStub) clearBreakpoints(ctx context.Context) error {
+	for addr := range s.breakpoints {
+		if err := s.removeBreakpoint(ctx, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resume continues the program, optionally from a new address, and waits for
+// it to stop. When stepping, temporary breakpoints are placed on every
+// instruction that could follow the current one.
+func (s *GDBStub) resume(ctx context.Context, c *rspConn, args string, step bool) (reply string, err error) {
+	if args != "" {
+		addr, err := strconv.ParseUint(args, 16, 64)
+		if err != nil {
+			return s.fail(errors.Wrapf(err, "invalid address %q", args)), nil
+		}
+		if err := s.setRegister(ctx, "epc", uint32(addr)); err != nil {
+			return s.fail(err), nil
+		}
+	}
+	var temporary []uint32
+	// the temporary breakpoints are removed however this ends, so none are
+	// left in the program
+	defer func() {
+		for _, addr := range temporary {
+			if rerr := s.removeBreakpoint(ctx, addr); rerr != nil && err == nil {
+				reply, err = "", rerr
+			}
+		}
+	}()
+	if step {
+		next, err := s.successors(ctx)
+		if err != nil {
+			return s.fail(err), nil
+		}
+		for _, addr := range next {
+			if _, ok := s.breakpoints[addr]; ok {
+				continue
+			}
+			if err := s.insertBreakpoint(ctx, addr); err != nil {
+				return s.fail(err), nil
+			}
+			temporary = append(temporary, addr)
+		}
+	}
+	// the end of the echoed command isn't output of the program
+	if err := s.p.Go(ctx); err != nil {
+		return s.fail(err), nil
+	}
+	if err := s.p.ReadUntil(ctx, "\r\n"); err != nil {
+		return s.fail(err), nil
+	}
+	s.regs = nil
+	sig, err := s.wait(ctx, c)
+	if err != nil {
+		return "", err
+	}
+	if sig == 0 {
+		regs, err := s.registers(ctx)
+		if err != nil {
+			return "", err
+		}
+		sig = exceptionSignal(regs["cause"])
+	}
+	s.signal = sig
+	reply = s.stopReply(ctx)
+	if s.regs != nil {
+		s.logf("stopped at %s (signal %d)", s.describe(s.regs["epc"]), sig)
+	}
+	return reply, nil
+}
+
+// successors returns the addresses of the instructions that could be executed
+// after the one at the current pc, following any branch and its delay slot.
+func (s *GDBStub) successors(ctx context.Context) ([]uint32, error) {
+	regs, err := s.registers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc := regs["epc"]
+	data, ok := s.breakpoints[pc]
+	if !ok {
+		if data, err = s.p.ReadMemory(ctx, pc, 4); err != nil {
+			return nil, err
+		}
+	}
+	inst := binary.LittleEndian.Uint32(data)
+	branch := pc + 4 + uint32(int32(int16(inst)))<<2
+	var next []uint32
+	switch op := inst >> 26; {
+	case op == 0 && (inst&0x3f == 0x08 || inst&0x3f == 0x09):
+		// jr, jalr
+		next = []uint32{regs.GPR(int(inst>>21) & 0x1f)}
+	case op == 0x01, op >= 0x04 && op <= 0x07:
+		// bltz, bgez, bltzal, bgezal, beq, bne, blez, bgtz
+		next = []uint32{branch, pc + 8}
+	case op == 0x02, op == 0x03:
+		// j, jal
+		next = []uint32{(pc+4)&0xf0000000 | (inst&0x03ffffff)<<2}
+	case op == 0x10 && (inst>>21)&0x1f == 0x08:
+		// bc0f, bc0t
+		next = []uint32{branch, pc + 8}
+	default:
+		next = []uint32{pc + 4}
+	}
+
+	// a breakpoint on the current instruction would be hit straight away
+	n := 0
+	for _, addr := range next {
+		if addr != pc {
+			next[n] = addr
+			n++
+		}
+	}
+	return next[:n], nil
+}
+
+// wait copies the output of the running program to Output until the monitor
+// prompt shows that it has stopped. An interrupt from GDB is passed on to the
+// console, in which case SIGINT is returned, otherwise the signal is zero and
+// should be derived from the exception that stopped the program.
+func (s *GDBStub) wait(ctx context.Context, c *rspConn) (int, error) {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.interrupts:
+			cancel()
+		case <-c.done:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+
+	p := s.p
+	stop := []byte("\r\n" + Prompt)
+	sig := 0
+	for {
+		if i := bytes.Index(p.buf, stop); i >= 0 {
+			s.Output.Write(p.buf[:i])
+			p.buf = p.buf[i+len(stop):]
+			return sig, nil
+		}
+
+		// hold back anything that could be the start of the prompt
+		k := partialSuffix(p.buf, stop)
+		s.Output.Write(p.buf[:len(p.buf)-k])
+		p.buf = p.buf[len(p.buf)-k:]
+		err := p.fill(waitCtx, "read")
+		if err == context.Canceled && sig == 0 && ctx.Err() == nil {
+			sig = gdbSIGINT
+			waitCtx = ctx
+			if err := p.Clear(ctx); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// stopReply returns the reply describing why the program last stopped, along
+// with the registers GDB needs first.
+func (s *GDBStub) stopReply(ctx context.Context) string {
+	regs, err := s.registers(ctx)
+	if err != nil {
+		s.logf("%v", err)
+		return fmt.Sprintf("S%02x", s.signal)
+	}
+	return fmt.Sprintf("T%02x%02x:%s;%02x:%s;", s.signal,
+		gdbRegisterPC, formatRegister(regs["epc"]),
+		gdbRegisterSP, formatRegister(regs["sp"]))
+}
+
+// describe formats addr along with the symbol it belongs to, if known.
+func (s *GDBStub) describe(addr uint32) string {
+	i := sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] > addr })
+	switch {
+	case i == 0 || addr < s.textStart || addr >= s.textEnd:
+		return fmt.Sprintf("0x%08X", addr)
+	case s.addrs[i-1] == addr:
+		return fmt.Sprintf("0x%08X <%s>", addr, s.symbols[addr])
+	}
+	return fmt.Sprintf("0x%08X <%s+0x%x>", addr, s.symbols[s.addrs[i-1]], addr-s.addrs[i-1])
+}
+
+// An rspConn reads packets sent by GDB in the background, so that an
+// interrupt can be noticed while the program is running. Acknowledgements
+// from GDB are ignored, since TCP doesn't lose packets.
+type rspConn struct {
+	conn  io.ReadWriter
+	wmu   sync.Mutex
+	noAck int32
+
+	packets    chan string
+	interrupts chan struct{}
+	done       chan struct{}
+	err        error
+}
+
+func newRSPConn(conn io.ReadWriter) *rspConn {
+	c := &rspConn{
+		conn:       conn,
+		packets:    make(chan string, 16),
+		interrupts: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+func rspChecksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+func (c *rspConn) read() {
+	defer close(c.packets)
+	defer close(c.done)
+	r := bufio.NewReader(c.conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			c.err = err
+			return
+		}
+		switch b {
+		case 0x03:
+			select {
+			case c.interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := r.ReadBytes('#')
+			if err != nil {
+				c.err = err
+				return
+			}
+			data = data[:len(data)-1]
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				c.err = err
+				return
+			}
+			v, err := strconv.ParseUint(string(sum[:]), 16, 8)
+			valid := err == nil && byte(v) == rspChecksum(data) && len(data) <= gdbPacketSize
+			if atomic.LoadInt32(&c.noAck) == 0 {
+				ack := "+"
+				if !valid {
+					ack = "-"
+				}
+				if err := c.write([]byte(ack)); err != nil {
+					c.err = err
+					return
+				}
+			}
+			if valid {
+				c.packets <- string(data)
+			}
+		}
+	}
+}
+
+func (c *rspConn) write(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// send sends a packet to GDB, escaping any characters that would otherwise
+// end it early.
+func (c *rspConn) send(data string) error {
+	var b bytes.Buffer
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i]; ch {
+		case '#', '$', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(ch ^ 0x20)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return c.write([]byte(fmt.Sprintf("$%s#%02x", b.Bytes(), rspChecksum(b.Bytes()))))
+}
@@ -0,0 +1,331 @@
+package yaroze
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/binutils"
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+)
+
+// gdbClient speaks the GDB remote serial protocol to a GDBStub.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *gdbClient) call(pkt string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "$%s#%02x", pkt, rspChecksum([]byte(pkt)))
+	if b, err := c.r.ReadByte(); err != nil || b != '+' {
+		c.t.Fatalf("%s: expected ack, received %q (%v)", pkt, b, err)
+	}
+	return c.reply()
+}
+
+func (c *gdbClient) reply() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.r.Discard(2); err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn.Write([]byte("+"))
+	return strings.TrimSuffix(data, "#")
+}
+
+// lockedBuffer is a bytes.Buffer that can be written by the stub while being
+// read by the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestGDBStub(t *testing.T) (*yarozetest.Console, *GDBStub, *gdbClient, func()) {
+	c := yarozetest.NewConsole()
+	p, err := NewPort(c, &PortConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewGDBStub(p)
+	go s.Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &gdbClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	return c, s, client, func() {
+		conn.Close()
+		l.Close()
+		p.Close()
+	}
+}
+
+// waitRunning waits for the program on the console to be started. Since it is
+// called from other goroutines, it reports failure with Error.
+func waitRunning(t *testing.T, c *yarozetest.Console) bool {
+	deadline := time.Now().Add(DefaultTimeout)
+	for !c.Running() {
+		if time.Now().After(deadline) {
+			t.Error("timed out waiting for the program to be started")
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func words(v ...uint32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, w := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], w)
+	}
+	return data
+}
+
+func TestGDBStubRegisters(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+	c.SetRegister("v0", 0x12345678)
+	c.SetRegister("epc", 0x80010000)
+
+	if resp := client.call("qSupported:multiprocess+"); !strings.Contains(resp, "PacketSize=") {
+		t.Fatalf("unexpected qSupported response %q", resp)
+	}
+	regs := client.call("g")
+	if len(regs) != gdbRegisterCount*8 {
+		t.Fatalf("expected %d registers, received %q", gdbRegisterCount, regs)
+	}
+	if v0 := regs[2*8 : 3*8]; v0 != "78563412" {
+		t.Fatalf("expected v0 of 78563412, received %s", v0)
+	}
+	if pc := regs[gdbRegisterPC*8 : (gdbRegisterPC+1)*8]; pc != "00000180" {
+		t.Fatalf("expected pc of 00000180, received %s", pc)
+	}
+	if bad := regs[35*8 : 36*8]; bad != "xxxxxxxx" {
+		t.Fatalf("expected badvaddr to be unavailable, received %s", bad)
+	}
+
+	if resp := client.call("P25=04000180"); resp != "OK" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if epc := c.Register("epc"); epc != 0x80010004 {
+		t.Fatalf("expected epc of 0x80010004, received 0x%08X", epc)
+	}
+	if resp := client.call("p25"); resp != "04000180" {
+		t.Fatalf("expected pc of 04000180, received %q", resp)
+	}
+}
+
+func TestGDBStubMemory(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+
+	if resp := client.call("M80010002,3:aabbcc"); resp != "OK" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if got := c.ReadMemory(0x80010000, 6); !bytes.Equal(got, []byte{0, 0, 0xaa, 0xbb, 0xcc, 0}) {
+		t.Fatalf("unexpected memory % x", got)
+	}
+	if resp := client.call("mffffffff80010001,4"); resp != "00aabbcc" {
+		t.Fatalf("unexpected memory %q", resp)
+	}
+	if resp := client.call("m80010000"); resp != "E01" {
+		t.Fatalf("expected an error for a malformed read, received %q", resp)
+	}
+}
+
+func TestGDBStubBreakpoint(t *testing.T) {
+	c, s, client, done := newTestGDBStub(t)
+	defer done()
+	output := &lockedBuffer{}
+	s.Output = output
+
+	code := words(0x24020001, 0x24030002, 0x00431021, 0x03e00008)
+	c.WriteMemory(0x80010000, code)
+	c.SetRegister("epc", 0x80010000)
+
+	if resp := client.call("Z0,80010008,4"); resp != "OK" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if got := c.ReadMemory(0x80010008, 4); !bytes.Equal(got, words(BreakInstruction)) {
+		t.Fatalf("expected break instruction, found % x", got)
+	}
+	if got := c.ReadMemory(0x8001000c, 4); !bytes.Equal(got, code[12:]) {
+		t.Fatalf("expected the following instruction to be kept, found % x", got)
+	}
+	go func() {
+		if !waitRunning(t, c) {
+			return
+		}
+		c.Print([]byte("hello\r\n"))
+		c.Exception(0x80010008, 9<<2)
+	}()
+	resp := client.call("c")
+	if expected := "T0525:08000180;"; !strings.HasPrefix(resp, expected) {
+		t.Fatalf("expected %q, received %q", expected, resp)
+	}
+	if out := output.String(); out != "hello\r\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if resp := client.call("z0,80010008,4"); resp != "OK" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if got := c.ReadMemory(0x80010000, len(code)); !bytes.Equal(got, code) {
+		t.Fatalf("expected code to be restored, found % x", got)
+	}
+	if resp := client.call("Z1,80010008,4"); resp != "" {
+		t.Fatalf("expected hardware breakpoints to be unsupported, received %q", resp)
+	}
+}
+
+func TestGDBStubStep(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+
+	// beq v0, v1, +3 followed by its delay slot
+	code := words(0x10430003, 0x00000000, 0x24020001, 0x24020002, 0x24020003, 0x24020004)
+	c.WriteMemory(0x80010000, code)
+	c.SetRegister("epc", 0x80010000)
+
+	go func() {
+		if !waitRunning(t, c) {
+			return
+		}
+		for _, addr := range []uint32{0x80010008, 0x80010010} {
+			if got := c.ReadMemory(addr, 4); !bytes.Equal(got, words(BreakInstruction)) {
+				t.Errorf("expected break instruction at 0x%08X, found % x", addr, got)
+			}
+		}
+		c.Exception(0x80010010, 9<<2)
+	}()
+	if resp := client.call("s"); !strings.HasPrefix(resp, "T0525:10000180;") {
+		t.Fatalf("unexpected response %q", resp)
+	}
+	if got := c.ReadMemory(0x80010000, len(code)); !bytes.Equal(got, code) {
+		t.Fatalf("expected code to be restored, found % x", got)
+	}
+}
+
+func TestGDBStubStepFailure(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+
+	code := words(0x10430003, 0x00000000, 0x24020001, 0x24020002, 0x24020003, 0x24020004)
+	c.WriteMemory(0x80010000, code)
+	c.SetRegister("epc", 0x80010000)
+
+	// the breakpoint on the branch target is inserted, but the one after the
+	// delay slot can't be, even when the transfer is restarted
+	c.Reject(0x80010008, 2)
+	if resp := client.call("s"); !strings.HasPrefix(resp, "E") {
+		t.Fatalf("expected an error, received %q", resp)
+	}
+	if c.Running() {
+		t.Fatal("expected the program not to be started")
+	}
+	if got := c.ReadMemory(0x80010000, len(code)); !bytes.Equal(got, code) {
+		t.Fatalf("expected the temporary breakpoints to be removed, found % x", got)
+	}
+}
+
+func TestGDBStubInterrupt(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+
+	go func() {
+		if !waitRunning(t, c) {
+			return
+		}
+		client.conn.Write([]byte{0x03})
+	}()
+	if resp := client.call("c"); !strings.HasPrefix(resp, "T02") {
+		t.Fatalf("expected SIGINT, received %q", resp)
+	}
+	if c.Running() {
+		t.Fatal("expected program to be stopped")
+	}
+}
+
+func TestGDBStubMonitorCommand(t *testing.T) {
+	c, _, client, done := newTestGDBStub(t)
+	defer done()
+	c.SetRegister("sp", 0x801fff00)
+
+	resp := client.call("qRcmd," + hex.EncodeToString([]byte("dr")))
+	if !strings.HasPrefix(resp, "O") {
+		t.Fatalf("expected output, received %q", resp)
+	}
+	out, err := hex.DecodeString(resp[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "sp   =801fff00") {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if resp := client.reply(); resp != "OK" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+}
+
+func TestGDBStubDescribe(t *testing.T) {
+	f, err := ecoff.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := NewGDBStub(nil)
+	s.SetSymbols(f)
+
+	var main uint32
+	for addr, name := range binutils.ECOFFSymbols(f) {
+		if name == "main" {
+			main = addr
+		}
+	}
+	cases := []struct {
+		addr     uint32
+		expected string
+	}{
+		{main, fmt.Sprintf("0x%08X <main>", main)},
+		{main + 8, fmt.Sprintf("0x%08X <main+0x8>", main+8)},
+		{f.TextStart - 4, fmt.Sprintf("0x%08X", f.TextStart-4)},
+		{f.TextStart + uint32(f.TextSize), fmt.Sprintf("0x%08X", f.TextStart+uint32(f.TextSize))},
+	}
+	for _, c := range cases {
+		if d := s.describe(c.addr); d != c.expected {
+			t.Errorf("expected %s, received %s", c.expected, d)
+		}
+	}
+}
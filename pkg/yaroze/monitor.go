@@ -0,0 +1,176 @@
+package yaroze
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Net Yaroze monitor commands, as typed at the prompt.
+const (
+	CmdBaudRate    = "baud"
+	CmdBinaryWrite = "bwr"
+	CmdClearScreen = "cls"
+	CmdDisplayRegs = "dr"
+	CmdDumpWords   = "dw"
+	CmdGo          = "go"
+	CmdSetRegister = "sr"
+)
+
+// maxDumpWords is the number of words requested by each dw command.
+const maxDumpWords = 256
+
+// GPRNames are the names used by the monitor for the general purpose
+// registers, indexed by register number.
+var GPRNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+// Registers holds the register values reported by the monitor, keyed by the
+// register name (e.g. "sp" or "epc").
+type Registers map[string]uint32
+
+// GPR returns the value of general purpose register n.
+func (r Registers) GPR(n int) uint32 {
+	return r[GPRNames[n]]
+}
+
+var (
+	registerPattern = regexp.MustCompile(`([a-z][a-z0-9]*)\s*=\s*([0-9a-fA-F]{1,8})\b`)
+	dumpPattern     = regexp.MustCompile(`^\s*([0-9a-fA-F]{8})\s*:((?:\s+[0-9a-fA-F]{8})+)`)
+)
+
+// Registers returns the registers of the console, as displayed by the dr
+// command.
+func (p *Port) Registers(ctx context.Context) (Registers, error) {
+	resp, err := p.Exec(ctx, CmdDisplayRegs)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRegisters(resp)
+}
+
+// ParseRegisters parses the output of the dr command, which lists registers
+// as name=value pairs with the value in hexadecimal.
+func ParseRegisters(s string) (Registers, error) {
+	regs := make(Registers)
+	for _, m := range registerPattern.FindAllStringSubmatch(s, -1) {
+		v, err := strconv.ParseUint(m[2], 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		regs[m[1]] = uint32(v)
+	}
+	if len(regs) == 0 {
+		return nil, errors.Errorf("no registers found in response: %q", s)
+	}
+	return regs, nil
+}
+
+// SetRegister sets the named register, which takes effect when the program is
+// resumed with the go command.
+func (p *Port) SetRegister(ctx context.Context, name string, v uint32) error {
+	return p.SendCommand(ctx, fmt.Sprintf("%s %s %x", CmdSetRegister, name, v))
+}
+
+// ReadMemory returns n bytes of console memory starting at addr, using the dw
+// command.
+func (p *Port) ReadMemory(ctx context.Context, addr uint32, n int) ([]byte, error) {
+	start := addr &^ 3
+	words := (int(addr-start) + n + 3) / 4
+	data := make([]byte, 0, words*4)
+	for i := 0; i < words; i += maxDumpWords {
+		count := words - i
+		if count > maxDumpWords {
+			count = maxDumpWords
+		}
+		base := start + uint32(i*4)
+		resp, err := p.Exec(ctx, fmt.Sprintf("%s %08x %x", CmdDumpWords, base, count))
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := ParseDump(resp, base, count)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+	return data[addr-start : int(addr-start)+n], nil
+}
+
+// ParseDump parses the output of the dw command, which lists the address of
+// each line followed by up to four words in hexadecimal, and returns count
+// words of memory starting at addr.
+func ParseDump(s string, addr uint32, count int) ([]byte, error) {
+	data := make([]byte, count*4)
+	found := make([]bool, count)
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		m := dumpPattern.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.ParseUint(m[1], 16, 32)
+		if uint32(line) < addr {
+			continue
+		}
+		for j, field := range strings.Fields(m[2]) {
+			v, _ := strconv.ParseUint(field, 16, 32)
+			i := int(uint32(line)-addr)/4 + j
+			if i >= count {
+				break
+			}
+			binary.LittleEndian.PutUint32(data[i*4:], uint32(v))
+			found[i] = true
+		}
+	}
+	for i, ok := range found {
+		if !ok {
+			return nil, errors.Errorf("memory dump is missing address 0x%08X", addr+uint32(i*4))
+		}
+	}
+	return data, nil
+}
+
+// WriteMemory writes data to console memory at addr using a binary transfer.
+// The monitor writes every block of a transfer in full, so the final block is
+// filled with the memory already following data, which is read first, rather
+// than with padding that would overwrite it.
+func (p *Port) WriteMemory(ctx context.Context, addr uint32, data []byte) error {
+	data, err := p.withTail(ctx, addr, data)
+	if err != nil {
+		return err
+	}
+	return p.Upload(ctx, addr, data)
+}
+
+// withTail returns data to be written at addr extended to a whole number of
+// blocks with the memory that follows it, so that uploading it leaves that
+// memory as it is.
+func (p *Port) withTail(ctx context.Context, addr uint32, data []byte) ([]byte, error) {
+	n := len(data) % BlockSize
+	if n == 0 {
+		return data, nil
+	}
+	tail, err := p.ReadMemory(ctx, addr+uint32(len(data)), BlockSize-n)
+	if err != nil {
+		return nil, err
+	}
+	return append(data[:len(data):len(data)], tail...), nil
+}
+
+// FillMemory sets n bytes of console memory starting at addr to v, leaving
+// the memory following them unchanged, as with WriteMemory.
+func (p *Port) FillMemory(ctx context.Context, addr uint32, n int, v byte) error {
+	return p.WriteMemory(ctx, addr, bytes.Repeat([]byte{v}, n))
+}
@@ -0,0 +1,81 @@
+package yaroze
+
+import "time"
+
+// Progress describes the state of a transfer to the console. It is reported to
+// PortConfig.Progress each time a block is acknowledged.
+type Progress struct {
+	// Section is the name of the section being uploaded, if known.
+	Section string
+
+	// Addr is the address in console memory the transfer started at.
+	Addr uint32
+
+	// Block is the index of the block that was acknowledged and Blocks is the
+	// total number of blocks in the transfer.
+	Block  int
+	Blocks int
+
+	// Sent is the number of bytes acknowledged by the console so far and
+	// Total is the size of the transfer.
+	Sent  int
+	Total int
+
+	// Retries is the number of blocks retransmitted during the transfer.
+	Retries int
+
+	// Elapsed is the time since the transfer started.
+	Elapsed time.Duration
+}
+
+// BaudRate returns the effective rate of the transfer in bits per second,
+// assuming each byte is framed by a start and stop bit.
+func (p Progress) BaudRate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Sent*10) / p.Elapsed.Seconds()
+}
+
+// Done reports whether every byte of the transfer has been acknowledged.
+func (p Progress) Done() bool {
+	return p.Sent >= p.Total
+}
+
+// A transfer tracks the progress of a single upload.
+type transfer struct {
+	Progress
+
+	// first is the index of the first block sent by the current bwr command,
+	// which is non-zero when a transfer has been resumed.
+	first int
+
+	start  time.Time
+	report func(Progress)
+}
+
+func newTransfer(report func(Progress), section string, addr uint32, size int) *transfer {
+	return &transfer{
+		Progress: Progress{
+			Section: section,
+			Addr:    addr,
+			Blocks:  (size + BlockSize - 1) / BlockSize,
+			Total:   size,
+		},
+		start:  time.Now(),
+		report: report,
+	}
+}
+
+func (t *transfer) retry() {
+	t.Retries++
+}
+
+func (t *transfer) ack(block, n int) {
+	t.Block = t.first + block
+	t.Sent += n
+	t.Elapsed = time.Since(t.start)
+	if t.report != nil {
+		t.report(t.Progress)
+	}
+}
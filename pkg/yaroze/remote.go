@@ -0,0 +1,432 @@
+package yaroze
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+/*
+A serial port can be shared over TCP with a Server, and used from another
+machine by opening a Port with a device name of tcp://host:port. Both ends
+exchange frames made up of a type byte, a 16-bit big-endian payload length and
+the payload:
+
+  'H' hello   server  empty, sent when the connection is accepted
+  'E' error   server  a message, sent before closing the connection
+  'D' data    both    bytes to or from the serial port
+  'R' rts     client  1 byte, the new state of the RTS line
+  'B' baud    client  32-bit big-endian baud rate to switch the serial port to
+  'C' cts     server  1 byte, the new state of the CTS line
+
+The server only accepts one client at a time, since the monitor can't tell
+two conversations apart. Because of the latency of the network, the server
+waits for CTS itself before writing each run of bytes that fits in the
+console's receive FIFO, and the state forwarded to the client is informational.
+*/
+
+// RemotePrefix is the prefix of device names that refer to a serial port
+// shared by a Server.
+const RemotePrefix = "tcp://"
+
+const (
+	frameHello = 'H'
+	frameError = 'E'
+	frameData  = 'D'
+	frameRTS   = 'R'
+	frameBaud  = 'B'
+	frameCTS   = 'C'
+
+	maxFrameSize = 4096
+)
+
+// serverCTSInterval is how often a Server checks for changes to the CTS line
+// to forward to the client.
+const serverCTSInterval = time.Millisecond
+
+// sioFIFOSize is the size of the receive FIFO of the console's serial port,
+// which is how much a Server writes after each check of the CTS line.
+const sioFIFOSize = 8
+
+func writeFrame(w io.Writer, typ byte, payload []byte) error {
+	for {
+		n := len(payload)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		buf := make([]byte, 3+n)
+		buf[0] = typ
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		copy(buf[3:], payload[:n])
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return nil
+		}
+	}
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+// A Server shares a Transport, usually a serial port, with clients connecting
+// over TCP.
+type Server struct {
+	t Transport
+
+	// Log, if set, receives a message each time a client connects or
+	// disconnects.
+	Log *log.Logger
+
+	in    chan []byte
+	inErr error
+	busy  int32
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewServer returns a Server sharing t, which is read continuously until the
+// Server is closed.
+func NewServer(t Transport) *Server {
+	s := &Server{
+		t:    t,
+		in:   make(chan []byte, 16),
+		done: make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+func (s *Server) read() {
+	defer close(s.in)
+	for {
+		buf := make([]byte, 256)
+		n, err := s.t.Read(buf)
+		if n > 0 {
+			select {
+			case s.in <- buf[:n]:
+			case <-s.done:
+				return
+			}
+		}
+		if err != nil {
+			s.inErr = err
+			return
+		}
+	}
+}
+
+// Close closes the shared Transport. It may be called more than once, such as
+// when a deferred Close follows one made on shutdown.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.closeErr = s.t.Close()
+	})
+	return s.closeErr
+}
+
+// Serve accepts connections on l until it is closed. Connections made while a
+// client is already connected are refused.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+			s.logf("refused %s, console is in use", conn.RemoteAddr())
+			writeFrame(conn, frameError, []byte("console is in use"))
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer atomic.StoreInt32(&s.busy, 0)
+			defer conn.Close()
+			s.logf("%s connected", conn.RemoteAddr())
+			if err := s.handle(conn); err != nil {
+				s.logf("%s disconnected: %v", conn.RemoteAddr(), err)
+				return
+			}
+			s.logf("%s disconnected", conn.RemoteAddr())
+		}()
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		s.Log.Printf(format, args...)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) error {
+	// anything received while nobody was connected is of no use
+	for drained := false; !drained; {
+		select {
+		case <-s.in:
+		default:
+			drained = true
+		}
+	}
+	if err := writeFrame(conn, frameHello, nil); err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.recv(conn)
+	}()
+	ticker := time.NewTicker(serverCTSInterval)
+	defer ticker.Stop()
+	var cts *bool
+	for {
+		select {
+		case data, ok := <-s.in:
+			if !ok {
+				return errors.Wrap(s.inErr, "serial port closed")
+			}
+			if err := writeFrame(conn, frameData, data); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			bits, err := s.t.GetModemStatusBits()
+			if err != nil {
+				return err
+			}
+			if cts == nil || *cts != bits.CTS {
+				v := bits.CTS
+				cts = &v
+				if err := writeFrame(conn, frameCTS, []byte{btob(v)}); err != nil {
+					return err
+				}
+			}
+		case err := <-errc:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// recv handles frames sent by the client until the connection is closed.
+func (s *Server) recv(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		typ, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case frameData:
+			if err := s.write(payload); err != nil {
+				return err
+			}
+		case frameRTS:
+			if len(payload) != 1 {
+				return errors.New("invalid rts frame")
+			}
+			if err := s.t.SetRTS(payload[0] != 0); err != nil {
+				return err
+			}
+		case frameBaud:
+			ms, ok := s.t.(ModeSetter)
+			if !ok {
+				return errors.New("cannot change the baud rate of the serial port")
+			}
+			if len(payload) != 4 {
+				return errors.New("invalid baud frame")
+			}
+			rate := int(binary.BigEndian.Uint32(payload))
+			if err := ms.SetMode(&serial.Mode{BaudRate: rate}); err != nil {
+				return err
+			}
+			s.logf("switched to %d baud", rate)
+		default:
+			return errors.Errorf("unexpected frame 0x%02X", typ)
+		}
+	}
+}
+
+// write sends data to the console in runs no longer than its receive FIFO,
+// checking CTS before each run rather than before every byte.
+func (s *Server) write(data []byte) error {
+	for len(data) > 0 {
+		if err := s.waitCTS(); err != nil {
+			return err
+		}
+		n := len(data)
+		if n > sioFIFOSize {
+			n = sioFIFOSize
+		}
+		if _, err := s.t.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (s *Server) waitCTS() error {
+	deadline := time.Now().Add(DefaultTimeout)
+	for {
+		bits, err := s.t.GetModemStatusBits()
+		if err != nil {
+			return err
+		}
+		if bits.CTS {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &TimeoutError{Op: "wait for CTS"}
+		}
+		time.Sleep(ctsPollInterval)
+	}
+}
+
+func btob(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// A RemoteTransport is a Transport connected to a serial port shared by a
+// Server.
+type RemoteTransport struct {
+	conn net.Conn
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	cts  bool
+	err  error
+
+	wmu sync.Mutex
+}
+
+// DialTransport connects to the Server listening on addr, given as host:port
+// with or without the tcp:// prefix.
+func DialTransport(addr string) (*RemoteTransport, error) {
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(addr, RemotePrefix), DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(DefaultTimeout))
+	typ, payload, err := readFrame(r)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "cannot connect to %s", addr)
+	}
+	conn.SetReadDeadline(time.Time{})
+	switch typ {
+	case frameHello:
+	case frameError:
+		conn.Close()
+		return nil, errors.Errorf("%s: %s", addr, payload)
+	default:
+		conn.Close()
+		return nil, errors.Errorf("%s: unexpected frame 0x%02X", addr, typ)
+	}
+	t := &RemoteTransport{conn: conn, cts: true}
+	t.cond = sync.NewCond(&t.mu)
+	go t.recv(r)
+	return t, nil
+}
+
+func (t *RemoteTransport) recv(r *bufio.Reader) {
+	for {
+		typ, payload, err := readFrame(r)
+		t.mu.Lock()
+		switch {
+		case err != nil:
+			t.err = err
+		case typ == frameData:
+			t.buf = append(t.buf, payload...)
+		case typ == frameCTS && len(payload) == 1:
+			t.cts = payload[0] != 0
+		case typ == frameError:
+			t.err = errors.Errorf("server: %s", payload)
+		}
+		failed := t.err != nil
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		if failed {
+			return
+		}
+	}
+}
+
+func (t *RemoteTransport) Read(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.buf) == 0 && t.err == nil {
+		t.cond.Wait()
+	}
+	if len(t.buf) == 0 {
+		return 0, t.err
+	}
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+func (t *RemoteTransport) Write(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if err := writeFrame(t.conn, frameData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *RemoteTransport) SetRTS(rts bool) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return writeFrame(t.conn, frameRTS, []byte{btob(rts)})
+}
+
+// SetMode switches the serial port shared by the Server to a different baud
+// rate.
+func (t *RemoteTransport) SetMode(mode *serial.Mode) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(mode.BaudRate))
+	return writeFrame(t.conn, frameBaud, payload[:])
+}
+
+// GetModemStatusBits reports the last state of the CTS line forwarded by the
+// Server.
+func (t *RemoteTransport) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &serial.ModemStatusBits{CTS: t.cts, DSR: true}, nil
+}
+
+func (t *RemoteTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,147 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+)
+
+func newTestServer(t *testing.T) (*yarozetest.Console, string, func()) {
+	c := yarozetest.NewConsole()
+	s := NewServer(c)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return c, RemotePrefix + l.Addr().String(), func() {
+		l.Close()
+		s.Close()
+	}
+}
+
+func TestRemotePortLoad(t *testing.T) {
+	f, err := ecoff.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	c, addr, cleanup := newTestServer(t)
+	defer cleanup()
+
+	p, err := OpenPort(&PortConfig{DeviceName: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	if err := p.Load(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Go(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range f.Sections {
+		data, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.ReadMemory(s.VirtualAddress, len(data)); !bytes.Equal(got, data) {
+			t.Errorf("section %s was not uploaded correctly", s.Name)
+		}
+	}
+	if v := c.Register("epc"); v != f.Entry {
+		t.Errorf("expected register epc to be 0x%08X, received 0x%08X", f.Entry, v)
+	}
+	if !c.Running() {
+		t.Fatal("expected console to be running")
+	}
+}
+
+func TestRemotePortBusy(t *testing.T) {
+	_, addr, cleanup := newTestServer(t)
+	defer cleanup()
+
+	p, err := OpenPort(&PortConfig{DeviceName: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenPort(&PortConfig{DeviceName: addr})
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("expected console to be in use, received %v", err)
+	}
+	p.Close()
+
+	// The console is available again once the first client disconnects.
+	for i := 0; ; i++ {
+		p, err = OpenPort(&PortConfig{DeviceName: addr})
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal(err)
+		}
+	}
+	defer p.Close()
+	if _, err := p.Registers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerCloseTwice(t *testing.T) {
+	s := NewServer(yarozetest.NewConsole())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("expected closing again to succeed, received %v", err)
+	}
+}
+
+// writeRecorder records the size of each write to a Console.
+type writeRecorder struct {
+	*yarozetest.Console
+	mu     sync.Mutex
+	writes []int
+}
+
+func (r *writeRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	r.writes = append(r.writes, len(p))
+	r.mu.Unlock()
+	return r.Console.Write(p)
+}
+
+func TestServerWriteRuns(t *testing.T) {
+	r := &writeRecorder{Console: yarozetest.NewConsole()}
+	s := NewServer(r)
+	defer s.Close()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- s.recv(conn) }()
+
+	if err := writeFrame(client, frameData, []byte("dw 80100000 4\r")); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-errc; err != io.EOF {
+		t.Fatalf("expected EOF, received %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if expected := []int{8, 6}; !reflect.DeepEqual(r.writes, expected) {
+		t.Errorf("expected writes of %v bytes, received %v", expected, r.writes)
+	}
+}
@@ -2,13 +2,18 @@ package yaroze
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ChrisRx/psxsdk/pkg/format"
 	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
 	"github.com/pkg/errors"
 	"go.bug.st/serial"
 )
@@ -16,170 +21,416 @@ import (
 const (
 	ECOFF_PSX_SP uint64 = 0x801fff00
 	Prompt              = ">>"
+
+	// DefaultTimeout is how long a single exchange with the console may take
+	// before it is considered unresponsive.
+	DefaultTimeout = 5 * time.Second
+
+	// ACK is the response sent by the console when a block has been received
+	// successfully.
+	ACK = 0x59
+
+	// BlockSize is the size of each block of data sent during a binary
+	// transfer.
+	BlockSize = 2048
 )
 
+// ctsPollInterval is how often the CTS line is checked while waiting for the
+// console to be ready to receive data.
+const ctsPollInterval = 100 * time.Microsecond
+
 type PortConfig struct {
 	BaudRate   int
 	DeviceName string
 	Output     io.Writer
+
+	// Timeout is applied to every read from, or wait on, the console. If zero,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+
+	// Retries is the number of times a block rejected by the console, or not
+	// acknowledged in time, is retransmitted before the transfer fails.
+	Retries int
+
+	// RetryBackoff is the delay before the first retransmission of a block,
+	// doubling for each subsequent attempt.
+	RetryBackoff time.Duration
+
+	// Progress, if set, is called each time a block is acknowledged by the
+	// console during an upload.
+	Progress func(Progress)
+
+	// Transcript, if set, receives a record of all traffic with the console
+	// (see NewRecorder).
+	Transcript io.Writer
+}
+
+// A Transport is the connection used to communicate with the Net Yaroze
+// monitor. It is satisfied by serial.Port, but can be replaced to talk to a
+// console that isn't connected directly (or isn't real at all, see the
+// yarozetest package).
+type Transport interface {
+	io.ReadWriteCloser
+	SetRTS(rts bool) error
+	GetModemStatusBits() (*serial.ModemStatusBits, error)
 }
 
 type Port struct {
-	serial.Port
-	w io.Writer
+	Transport
+	w        io.Writer
+	baud     int
+	timeout  time.Duration
+	retries  int
+	backoff  time.Duration
+	progress func(Progress)
+
+	// Data read from the Transport is delivered on in by a dedicated goroutine
+	// so that reads can be abandoned when a deadline is exceeded. Anything
+	// received but not yet consumed is kept in buf.
+	in        chan []byte
+	inErr     error
+	buf       []byte
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
+// OpenPort opens the serial device described by cfg and returns a Port ready
+// to communicate with the Net Yaroze monitor. A device name of the form
+// tcp://host:port connects to a serial port shared by a Server instead, in
+// which case the baud rate is set by the Server. Otherwise a BaudRate of zero
+// detects the rate used by the monitor (see DetectBaudRate).
 func OpenPort(cfg *PortConfig) (*Port, error) {
-	port, err := serial.Open(cfg.DeviceName, &serial.Mode{BaudRate: cfg.BaudRate})
+	var port Transport
+	var err error
+	if strings.HasPrefix(cfg.DeviceName, RemotePrefix) {
+		port, err = DialTransport(cfg.DeviceName)
+	} else {
+		if cfg.BaudRate == 0 {
+			rate, err := DetectBaudRate(cfg.DeviceName)
+			if err != nil {
+				return nil, err
+			}
+			c := *cfg
+			c.BaudRate = rate
+			cfg = &c
+		}
+		port, err = openSerial(cfg.DeviceName, cfg.BaudRate)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return NewPort(port, cfg)
+}
+
+// NewPort returns a Port communicating over the provided Transport. The
+// BaudRate and DeviceName fields of cfg are ignored since the Transport is
+// already open. If an error is returned, t has been closed.
+func NewPort(t Transport, cfg *PortConfig) (*Port, error) {
+	w := cfg.Output
+	if w == nil {
+		w = ioutil.Discard
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if cfg.Transcript != nil {
+		t = NewRecorder(t, cfg.Transcript)
+	}
 	p := &Port{
-		Port: port,
-		w:    cfg.Output,
+		Transport: t,
+		w:         w,
+		baud:      cfg.BaudRate,
+		timeout:   timeout,
+		retries:   cfg.Retries,
+		backoff:   cfg.RetryBackoff,
+		progress:  cfg.Progress,
+		in:        make(chan []byte, 16),
+		done:      make(chan struct{}),
 	}
-	if err := p.Port.SetRTS(true); err != nil {
+	if err := p.Transport.SetRTS(true); err != nil {
+		p.Close()
+		return nil, err
+	}
+	go p.read()
+
+	// Wait for the prompt following the interrupt, which could otherwise be
+	// mistaken for the response to the first command when the console is
+	// slow to respond (or far away, see Server).
+	if err := p.Clear(context.Background()); err != nil {
+		p.Close()
 		return nil, err
 	}
-	if err := p.Clear(); err != nil {
+	if err := p.ReadUntil(context.Background(), Prompt); err != nil {
+		p.Close()
 		return nil, err
 	}
 	return p, nil
 }
 
-func (p *Port) Clear() error {
-	return p.WriteByte(0x03)
+// Close closes the underlying Transport.
+func (p *Port) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return p.Transport.Close()
+}
+
+// read delivers everything read from the Transport to the in channel until
+// the Transport returns an error or the Port is closed.
+func (p *Port) read() {
+	defer close(p.in)
+	for {
+		buf := make([]byte, 1024)
+		n, err := p.Transport.Read(buf)
+		if n > 0 {
+			select {
+			case p.in <- buf[:n]:
+			case <-p.done:
+				return
+			}
+		}
+		if err != nil {
+			p.inErr = err
+			return
+		}
+	}
+}
+
+// withTimeout returns a context bounded by the configured timeout for a single
+// exchange with the console.
+func (p *Port) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// fill waits for more data to arrive from the console and appends it to the
+// read buffer.
+func (p *Port) fill(ctx context.Context, op string) error {
+	select {
+	case data, ok := <-p.in:
+		if !ok {
+			if p.inErr != nil {
+				return p.inErr
+			}
+			return io.EOF
+		}
+		p.w.Write(data)
+		p.buf = append(p.buf, data...)
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx, op)
+	}
+}
+
+// discard drops any data received from the console that has not been
+// consumed yet, so that stale prompts aren't mistaken for fresh responses.
+func (p *Port) discard() {
+	p.buf = p.buf[:0]
+	for {
+		select {
+		case data, ok := <-p.in:
+			if !ok {
+				return
+			}
+			p.w.Write(data)
+		default:
+			return
+		}
+	}
+}
+
+func contextError(ctx context.Context, op string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Op: op}
+	}
+	return ctx.Err()
 }
 
-func (p *Port) ClearScreen() error {
-	return p.SendCommand("\rcls")
+// Clear interrupts whatever the monitor is doing and discards the current
+// input line.
+func (p *Port) Clear(ctx context.Context) error {
+	return p.SendByte(ctx, 0x03)
 }
 
-func (p *Port) Go() error {
-	if _, err := p.Port.Write([]byte("go\r")); err != nil {
+func (p *Port) ClearScreen(ctx context.Context) error {
+	if err := p.SendCommand(ctx, ""); err != nil {
 		return err
 	}
-	time.Sleep(2000 * time.Millisecond)
-	return nil
+	return p.SendCommand(ctx, CmdClearScreen)
 }
 
-func (p *Port) Bwr() error {
-	_, err := p.Port.Write([]byte("bwr\x0d"))
-	if err != nil {
+// Go starts execution of the uploaded program. It returns once the monitor
+// has echoed the command.
+func (p *Port) Go(ctx context.Context) error {
+	p.discard()
+	if err := p.Write(ctx, []byte(CmdGo+"\r")); err != nil {
 		return err
 	}
-	return p.ReadUntil("binary")
+	return p.ReadUntil(ctx, CmdGo)
 }
 
-func (p *Port) Write(data []byte) error {
+func (p *Port) Bwr(ctx context.Context) error {
+	p.discard()
+	if err := p.Write(ctx, []byte(CmdBinaryWrite+"\x0d")); err != nil {
+		return err
+	}
+	return p.ReadUntil(ctx, "binary")
+}
+
+// Write sends data to the console, one byte at a time, respecting hardware
+// flow control.
+func (p *Port) Write(ctx context.Context, data []byte) error {
 	for _, b := range data {
-		if err := p.WriteByte(b); err != nil {
+		if err := p.SendByte(ctx, b); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (p *Port) Handshake(addr uint32, size int32) error {
-	if err := p.WriteByte(0x01); err != nil {
+func (p *Port) Handshake(ctx context.Context, addr uint32, size int32) error {
+	if err := p.SendByte(ctx, 0x01); err != nil {
 		return err
 	}
 	data := make([]byte, 8)
 	binary.BigEndian.PutUint32(data[0:], addr)
 	binary.BigEndian.PutUint32(data[4:], uint32(size))
-	return p.Write(data)
+	return p.Write(ctx, data)
+}
+
+func (p *Port) SendCommand(ctx context.Context, command string) error {
+	_, err := p.Exec(ctx, command)
+	return err
 }
 
-func (p *Port) SendCommand(command string) error {
-	_, err := p.Port.Write([]byte(fmt.Sprintf("%s\r", command)))
+// Exec runs a monitor command and returns its response, without the echoed
+// command or the prompt that follows.
+func (p *Port) Exec(ctx context.Context, command string) (string, error) {
+	p.discard()
+	if err := p.Write(ctx, []byte(fmt.Sprintf("%s\r", command))); err != nil {
+		return "", err
+	}
+	resp, err := p.readUntil(ctx, Prompt)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if i := strings.IndexByte(resp, '\n'); i >= 0 && strings.TrimSpace(resp[:i]) == command {
+		resp = resp[i+1:]
 	}
-	return p.ReadUntil(Prompt)
+	return resp, nil
 }
 
-func (p *Port) ReadByte() (byte, error) {
-	var b bytes.Buffer
-	buf := make([]byte, 1024)
-	for {
-		status, err := p.Port.GetModemStatusBits()
-		if err != nil {
-			return 0, err
+// Recv returns the next output received from the console, including anything
+// already received but not yet consumed. Unlike other reads, no timeout is
+// applied other than that of ctx, which makes it suitable for streaming the
+// output of a running program.
+func (p *Port) Recv(ctx context.Context) ([]byte, error) {
+	for len(p.buf) == 0 {
+		if err := p.fill(ctx, "read"); err != nil {
+			return nil, err
 		}
-		if !status.CTS {
-			continue
-		}
-		n, err := p.Port.Read(buf)
-		time.Sleep(100 * time.Millisecond)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+	}
+	data := p.buf
+	p.buf = nil
+	return data, nil
+}
+
+// RecvByte returns the next byte sent by the console.
+func (p *Port) RecvByte(ctx context.Context) (byte, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	for len(p.buf) == 0 {
+		if err := p.fill(ctx, "read"); err != nil {
 			return 0, err
 		}
-		p.w.Write(buf[:n])
-		if n == 0 {
-			return 0, errors.New("oh no")
-		}
-		b.Write(buf[:n])
-		return buf[0], nil
 	}
-	return 0, errors.New("oh nos")
+	b := p.buf[0]
+	p.buf = p.buf[1:]
+	return b, nil
+}
+
+// ReadUntil consumes output from the console up to and including seq. If the
+// monitor prompt is received before seq a PromptError is returned.
+func (p *Port) ReadUntil(ctx context.Context, seq string) error {
+	_, err := p.readUntil(ctx, seq)
+	return err
 }
 
-func (p *Port) ReadUntil(seq string) error {
-	var b bytes.Buffer
-	buf := make([]byte, 1024)
+// readUntil is like ReadUntil, but also returns the output preceding seq.
+func (p *Port) readUntil(ctx context.Context, seq string) (string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 	for {
-		n, err := p.Port.Read(buf)
-		time.Sleep(100 * time.Millisecond)
-		if err != nil {
-			if err == io.EOF {
-				break
+		if i := bytes.Index(p.buf, []byte(seq)); i >= 0 {
+			received := string(p.buf[:i])
+			p.buf = p.buf[i+len(seq):]
+			return received, nil
+		}
+		if seq != Prompt {
+			if i := bytes.Index(p.buf, []byte(Prompt)); i >= 0 {
+				received := string(p.buf[:i])
+				p.buf = p.buf[i+len(Prompt):]
+				return "", &PromptError{Expected: seq, Received: received}
 			}
-			return err
 		}
-		p.w.Write(buf[:n])
-		b.Write(buf[:n])
-		//fmt.Printf("b.String() = %+v\n", b.String())
-
-		if strings.Contains(b.String(), seq) {
-			break
+		if err := p.fill(ctx, fmt.Sprintf("waiting for %q", seq)); err != nil {
+			return "", err
 		}
 	}
-	return nil
 }
 
-func (p *Port) WriteByte(b byte) error {
-	st := time.Now()
+// SendByte writes a single byte once the console signals it is clear to send.
+func (p *Port) SendByte(ctx context.Context, b byte) error {
+	if err := p.waitCTS(ctx); err != nil {
+		return err
+	}
+	_, err := p.Transport.Write([]byte{b})
+	return err
+}
+
+// waitCTS blocks until the CTS line is asserted by the console.
+func (p *Port) waitCTS(ctx context.Context) error {
+	var t *time.Timer
 	for {
-		status, err := p.Port.GetModemStatusBits()
+		status, err := p.Transport.GetModemStatusBits()
 		if err != nil {
 			return err
 		}
 		if status.CTS {
-			break
+			return nil
 		}
-		if time.Since(st) > 500*time.Millisecond {
-			return errors.New("WriteByte timeout")
+		if t == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = p.withTimeout(ctx)
+			defer cancel()
+			t = time.NewTimer(ctsPollInterval)
+			defer t.Stop()
+		} else {
+			t.Reset(ctsPollInterval)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return contextError(ctx, "waiting for CTS")
 		}
 	}
-	_, err := p.Port.Write([]byte{b})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
-func (p *Port) Load(f *ecoff.File) error {
-	time.Sleep(500 * time.Millisecond)
-	if err := p.SendCommand(fmt.Sprintf("sr epc %x", f.Entry)); err != nil {
+// setEntry sets the registers used when the uploaded program is started.
+func (p *Port) setEntry(ctx context.Context, pc, gp, sp uint32) error {
+	if err := p.SetRegister(ctx, "epc", pc); err != nil {
 		return err
 	}
-	if err := p.SendCommand(fmt.Sprintf("sr gp %x", f.GpValue)); err != nil {
+	if err := p.SetRegister(ctx, "gp", gp); err != nil {
 		return err
 	}
-	if err := p.SendCommand(fmt.Sprintf("sr sp %x", ECOFF_PSX_SP)); err != nil {
+	return p.SetRegister(ctx, "sp", sp)
+}
+
+// Load uploads each section of a Net Yaroze ECOFF executable to its virtual
+// address and sets the registers needed to start it.
+func (p *Port) Load(ctx context.Context, f *ecoff.File) error {
+	if err := p.setEntry(ctx, f.Entry, f.GpValue, uint32(ECOFF_PSX_SP)); err != nil {
 		return err
 	}
 	for _, s := range f.Sections {
@@ -190,65 +441,209 @@ func (p *Port) Load(f *ecoff.File) error {
 		if len(data) == 0 {
 			continue
 		}
-		if err := p.Bwr(); err != nil {
+		name := string(bytes.TrimRight(s.Name[:], "\x00"))
+		t := newTransfer(p.progress, name, s.VirtualAddress, len(data))
+		if err := p.upload(ctx, t, data, 0); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// LoadEXE uploads the text of a PSX-EXE executable to TextAddr and sets the
+// registers needed to start it from PC0. The stack pointer is taken from the
+// header, falling back to the same default used for ECOFF executables when
+// the header doesn't specify one.
+func (p *Port) LoadEXE(ctx context.Context, f *psx.File) error {
+	sp := f.StackAddr + f.StackSize
+	if f.StackAddr == 0 {
+		sp = uint32(ECOFF_PSX_SP)
+	}
+	if err := p.setEntry(ctx, f.PC0, f.GP0, sp); err != nil {
+		return err
+	}
+	data := f.Section("text").Data
+	if len(data) == 0 {
+		return errors.New("executable has no text to upload")
+	}
+	t := newTransfer(p.progress, "text", f.TextAddr, len(data))
+	return p.upload(ctx, t, data, 0)
+}
 
-		if err := p.Handshake(s.VirtualAddress, s.Size); err != nil {
+// LoadFile uploads the named ECOFF or PSX-EXE executable, detecting the format
+// from its magic number.
+func (p *Port) LoadFile(ctx context.Context, name string) error {
+	ft, err := format.DetectFile(name)
+	if err != nil {
+		return err
+	}
+	switch ft {
+	case format.ECOFF:
+		f, err := ecoff.Open(name)
+		if err != nil {
 			return err
 		}
+		defer f.Close()
+		return p.Load(ctx, f)
+	case format.PSXEXE:
+		f, err := psx.Open(name)
+		if err != nil {
+			return err
+		}
+		return p.LoadEXE(ctx, f)
+	default:
+		return errors.Errorf("cannot load %s executable", ft)
+	}
+}
+
+// Upload writes data to console memory at addr using the bwr command. If a
+// block cannot be delivered after exhausting all retries, the transfer is
+// restarted at that block instead of from the beginning, for as long as each
+// restart makes progress. A transfer that fails is ended, so the monitor is
+// left at its prompt.
+func (p *Port) Upload(ctx context.Context, addr uint32, data []byte) error {
+	return p.UploadFrom(ctx, addr, data, 0)
+}
+
+// UploadFrom is like Upload, but skips the blocks of data preceding block,
+// which allows resuming an interrupted transfer.
+func (p *Port) UploadFrom(ctx context.Context, addr uint32, data []byte, block int) error {
+	return p.upload(ctx, newTransfer(p.progress, "", addr, len(data)), data, block)
+}
 
-		// if this isn't here it will fail with WriteByte timeout
-		time.Sleep(100 * time.Millisecond)
-		if err := p.SendData(data, 2048); err != nil {
+func (p *Port) upload(ctx context.Context, t *transfer, data []byte, block int) error {
+	t.Sent = block * BlockSize
+	failed := -1
+	for {
+		offset := block * BlockSize
+		if offset >= len(data) {
+			return errors.Errorf("cannot resume from block %d, data is only %d bytes", block, len(data))
+		}
+		if err := p.Bwr(ctx); err != nil {
+			return err
+		}
+		if err := p.Handshake(ctx, t.Addr+uint32(offset), int32(len(data)-offset)); err != nil {
 			return err
 		}
+		t.first = block
+		err := p.sendData(ctx, t, data[offset:], BlockSize)
+		be, ok := err.(*BlockError)
+		if !ok {
+			return err
+		}
+		be.Block += block
+		if err := p.abort(ctx); err != nil {
+			return err
+		}
+		if be.Block == failed {
+			return be
+		}
+		failed = be.Block
+		block = failed
 	}
-	return nil
 }
 
-func (p *Port) SendData(data []byte, batch int) error {
-	for i := 0; i < len(data); i += batch {
+// abort ends a binary transfer that could not be completed and returns the
+// monitor to the command prompt.
+func (p *Port) abort(ctx context.Context) error {
+	if err := p.SendByte(ctx, 0x0d); err == nil {
+		if err := p.ReadUntil(ctx, "end binary"); err == nil {
+			return nil
+		}
+	}
+	if err := p.Clear(ctx); err != nil {
+		return err
+	}
+	return p.SendCommand(ctx, "")
+}
+
+// SendData sends data as blocks of batch bytes, padding the final block. Each
+// block rejected by the console is retransmitted up to the configured number
+// of retries, after which a BlockError is returned.
+func (p *Port) SendData(ctx context.Context, data []byte, batch int) error {
+	return p.sendData(ctx, newTransfer(p.progress, "", 0, len(data)), data, batch)
+}
+
+func (p *Port) sendData(ctx context.Context, t *transfer, data []byte, batch int) error {
+	for i, n := 0, 0; i < len(data); i, n = i+batch, n+1 {
 		j := i + batch
 		if j > len(data) {
 			j = len(data)
 		}
-
-		if err := p.WriteByte(0x02); err != nil {
-			return err
-		}
 		chunk := data[i:j]
-		if len(chunk)%2048 != 0 {
-			pad := make([]byte, 2048-len(chunk))
-			chunk = append(chunk, pad...)
+		if len(chunk)%BlockSize != 0 {
+			pad := make([]byte, BlockSize-len(chunk)%BlockSize)
+			chunk = append(chunk[:len(chunk):len(chunk)], pad...)
+		}
+		if err := p.sendBlock(ctx, t, n, chunk); err != nil {
+			return err
 		}
+		t.ack(n, j-i)
+	}
+	if err := p.SendByte(ctx, 0x0d); err != nil {
+		return err
+	}
+	return p.ReadUntil(ctx, "end binary")
+}
 
-		// if this isn't here it will fail and hang
-		time.Sleep(1 * time.Millisecond)
-		var sum uint8
-		for _, b := range chunk {
-			err := p.WriteByte(b)
-			if err != nil {
-				return errors.Wrap(err, "SendData")
+// sendBlock sends a single block, retransmitting it with exponential backoff
+// while the console rejects it or fails to respond.
+func (p *Port) sendBlock(ctx context.Context, t *transfer, n int, chunk []byte) error {
+	var err error
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			t.retry()
+			if err := sleep(ctx, p.backoff<<uint(attempt-1)); err != nil {
+				return err
 			}
-			sum += b
 		}
-		if err := p.WriteByte(sum); err != nil {
-			return err
-		}
-		resp, err := p.ReadByte()
-		if err != nil {
+		err = p.writeBlock(ctx, n, chunk)
+		switch errors.Cause(err).(type) {
+		case nil:
+			return nil
+		case *NAKError, *TimeoutError:
+			continue
+		default:
 			return err
 		}
-		if resp != 0x59 {
-			return errors.Errorf("end: %d", resp)
+	}
+	return &BlockError{Block: n, Err: err}
+}
+
+func (p *Port) writeBlock(ctx context.Context, n int, chunk []byte) error {
+	if err := p.SendByte(ctx, 0x02); err != nil {
+		return err
+	}
+	var sum uint8
+	for _, b := range chunk {
+		if err := p.SendByte(ctx, b); err != nil {
+			return errors.Wrap(err, "SendData")
 		}
+		sum += b
 	}
-	if err := p.WriteByte(0x0d); err != nil {
+	// anything other than the response to this block is noise, such as the
+	// remainder of the bwr banner or a late response to a previous attempt
+	p.discard()
+	if err := p.SendByte(ctx, sum); err != nil {
 		return err
 	}
-	if err := p.ReadUntil("end binary"); err != nil {
+	resp, err := p.RecvByte(ctx)
+	if err != nil {
 		return err
 	}
+	if resp != ACK {
+		return &NAKError{Block: n, Response: resp}
+	}
 	return nil
 }
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
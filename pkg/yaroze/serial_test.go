@@ -0,0 +1,327 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ChrisRx/psxsdk/pkg/format/ecoff"
+	"github.com/ChrisRx/psxsdk/pkg/format/psx"
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+)
+
+var _ Transport = (*yarozetest.Console)(nil)
+
+func newTestPort(t *testing.T) (*Port, *yarozetest.Console) {
+	c := yarozetest.NewConsole()
+	p, err := NewPort(c, &PortConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, c
+}
+
+func TestPortLoad(t *testing.T) {
+	f, err := ecoff.Open("../format/ecoff/testdata/main-ecoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p, c := newTestPort(t)
+	defer p.Close()
+	ctx := context.Background()
+
+	if err := p.ClearScreen(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Go(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	regs := map[string]uint32{
+		"epc": f.Entry,
+		"gp":  f.GpValue,
+		"sp":  uint32(ECOFF_PSX_SP),
+	}
+	for name, expected := range regs {
+		if v := c.Register(name); v != expected {
+			t.Errorf("expected register %s to be 0x%08X, received 0x%08X", name, expected, v)
+		}
+	}
+	for _, s := range f.Sections {
+		data, err := s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.ReadMemory(s.VirtualAddress, len(data)); !bytes.Equal(got, data) {
+			t.Errorf("section %s was not uploaded correctly", s.Name)
+		}
+	}
+	if !c.Running() {
+		t.Fatal("expected console to be running")
+	}
+}
+
+func TestPortSendDataChecksum(t *testing.T) {
+	p, c := newTestPort(t)
+	defer p.Close()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte{0xff}, 3000)
+	if err := p.Bwr(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Handshake(ctx, 0x80010000, int32(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendData(ctx, data, 2048); err != nil {
+		t.Fatal(err)
+	}
+	uploads := c.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("expected 1 upload, received %d", len(uploads))
+	}
+	if !bytes.Equal(uploads[0].Data, data) {
+		t.Fatal("uploaded data does not match")
+	}
+
+	// A bad checksum must be rejected by the console.
+	if err := p.Bwr(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Handshake(ctx, 0x80020000, 2048); err != nil {
+		t.Fatal(err)
+	}
+	block := append([]byte{0x02}, make([]byte, 2048)...)
+	if err := p.Write(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	p.discard()
+	if err := p.SendByte(ctx, 0x01); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.RecvByte(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != yarozetest.NAK {
+		t.Fatalf("expected NAK, received 0x%02X", resp)
+	}
+}
+
+func TestPortTimeout(t *testing.T) {
+	c := yarozetest.NewConsole()
+	p, err := NewPort(c, &PortConfig{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// The console has nothing more to say once the prompt has been consumed.
+	_, err = p.RecvByte(context.Background())
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error, received %v", err)
+	}
+}
+
+func TestPortUnexpectedPrompt(t *testing.T) {
+	p, _ := newTestPort(t)
+	defer p.Close()
+
+	// An unknown command returns straight to the prompt.
+	if err := p.Write(context.Background(), []byte("bogus\r")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.ReadUntil(context.Background(), "binary").(*PromptError); !ok {
+		t.Fatal("expected PromptError")
+	}
+}
+
+func TestPortUploadRetry(t *testing.T) {
+	c := yarozetest.NewConsole()
+	p, err := NewPort(c, &PortConfig{Retries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	data := make([]byte, 4*BlockSize)
+	for i := range data {
+		data[i] = byte(i / 7)
+	}
+
+	// Two rejections are covered by retransmitting the block.
+	c.Reject(0x80010800, 2)
+	if err := p.Upload(context.Background(), 0x80010000, data); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Uploads()); n != 1 {
+		t.Fatalf("expected 1 upload, received %d", n)
+	}
+
+	// Three rejections exhaust the retries, so the transfer is resumed from
+	// the failed block.
+	c.Reject(0x80021000, 3)
+	if err := p.Upload(context.Background(), 0x80020000, data); err != nil {
+		t.Fatal(err)
+	}
+	uploads := c.Uploads()
+	if len(uploads) != 3 {
+		t.Fatalf("expected 3 uploads, received %d", len(uploads))
+	}
+	if uploads[2].Addr != 0x80021000 {
+		t.Fatalf("expected transfer to resume at 0x80021000, received 0x%08X", uploads[2].Addr)
+	}
+	if got := c.ReadMemory(0x80020000, len(data)); !bytes.Equal(got, data) {
+		t.Fatal("uploaded data does not match")
+	}
+
+	// A block that is never accepted eventually fails the transfer.
+	c.Reject(0x80030000, 100)
+	err = p.Upload(context.Background(), 0x80030000, data)
+	if be, ok := err.(*BlockError); !ok || be.Block != 0 {
+		t.Fatalf("expected BlockError for block 0, received %v", err)
+	}
+	if err := p.SendCommand(context.Background(), CmdClearScreen); err != nil {
+		t.Fatalf("expected the monitor to be back at the prompt: %v", err)
+	}
+}
+
+func TestPortProgress(t *testing.T) {
+	var events []Progress
+	c := yarozetest.NewConsole()
+	p, err := NewPort(c, &PortConfig{
+		Retries: 1,
+		Progress: func(p Progress) {
+			events = append(events, p)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	c.Reject(0x80010800, 1)
+	data := make([]byte, 3*BlockSize-100)
+	if err := p.Upload(context.Background(), 0x80010000, data); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 progress events, received %d", len(events))
+	}
+	last := events[len(events)-1]
+	if !last.Done() || last.Sent != len(data) || last.Blocks != 3 || last.Block != 2 {
+		t.Fatalf("unexpected final progress: %+v", last)
+	}
+	if last.Retries != 1 {
+		t.Fatalf("expected 1 retry, received %d", last.Retries)
+	}
+}
+
+func TestPortLoadEXE(t *testing.T) {
+	f, err := psx.Open("../format/psx/testdata/psx.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, c := newTestPort(t)
+	defer p.Close()
+
+	if err := p.LoadEXE(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Register("epc"); v != f.PC0 {
+		t.Errorf("expected epc to be 0x%08X, received 0x%08X", f.PC0, v)
+	}
+	if v := c.Register("sp"); v != f.StackAddr+f.StackSize {
+		t.Errorf("expected sp to be 0x%08X, received 0x%08X", f.StackAddr+f.StackSize, v)
+	}
+	data := f.Section("text").Data
+	if got := c.ReadMemory(f.TextAddr, len(data)); !bytes.Equal(got, data) {
+		t.Fatal("text was not uploaded correctly")
+	}
+}
+
+func TestPortMonitorCommands(t *testing.T) {
+	p, c := newTestPort(t)
+	defer p.Close()
+	ctx := context.Background()
+
+	if err := p.SetRegister(ctx, "a0", 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	c.SetRegister("ra", 0x80010008)
+	regs, err := p.Registers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := regs.GPR(4); v != 0x1234 {
+		t.Errorf("expected a0 to be 0x1234, received 0x%X", v)
+	}
+	if v := regs.GPR(31); v != 0x80010008 {
+		t.Errorf("expected ra to be 0x80010008, received 0x%X", v)
+	}
+	if _, ok := regs["epc"]; !ok {
+		t.Error("expected epc to be reported")
+	}
+
+	data := make([]byte, 1500)
+	for i := range data {
+		data[i] = byte(i * 3)
+	}
+	if err := p.WriteMemory(ctx, 0x80010003, data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.ReadMemory(ctx, 0x80010003, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("memory read does not match memory written")
+	}
+
+	if err := p.FillMemory(ctx, 0x80020000, 16, 0xaa); err != nil {
+		t.Fatal(err)
+	}
+	got, err = p.ReadMemory(ctx, 0x80020000, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bytes.Repeat([]byte{0xaa}, 16)) {
+		t.Fatal("memory was not filled")
+	}
+}
+
+func TestPortWriteMemoryPreservesFollowing(t *testing.T) {
+	p, c := newTestPort(t)
+	defer p.Close()
+	ctx := context.Background()
+
+	following := make([]byte, 4*BlockSize)
+	for i := range following {
+		following[i] = byte(i*7 + 1)
+	}
+	c.WriteMemory(0x80030000, following)
+
+	if err := p.WriteMemory(ctx, 0x80030000, []byte{1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ReadMemory(0x80030000, 5); !bytes.Equal(got, []byte{1, 2, 3, 4, 5}) {
+		t.Fatalf("expected memory to be written, received % x", got)
+	}
+	if got := c.ReadMemory(0x80030005, len(following)-5); !bytes.Equal(got, following[5:]) {
+		t.Fatal("memory following the write was changed")
+	}
+
+	if err := p.FillMemory(ctx, 0x80031000-3, BlockSize+6, 0xaa); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ReadMemory(0x80031803, 16); !bytes.Equal(got, following[0x1803:0x1813]) {
+		t.Fatal("memory following the fill was changed")
+	}
+}
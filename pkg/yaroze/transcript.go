@@ -0,0 +1,381 @@
+package yaroze
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.bug.st/serial"
+)
+
+/*
+A transcript records the traffic with the console as text, one record per
+line, with the time in seconds since the start of the session:
+
+  0.000012 rts 1
+  0.000034 > 03                                               |.|
+  0.000512 < 0d 0a 3e 3e                                      |..>>|
+  0.000530 cts 1
+
+Data sent to the console is marked with > and data received from it with <,
+with at most 16 bytes per line. Consecutive writes are combined on the same
+line, since data is usually written a byte at a time. Changes to the RTS and
+CTS lines are recorded as rts and cts followed by the new state, and changes
+to the baud rate as baud followed by the new rate. Lines starting with # are
+comments.
+*/
+
+// transcriptWidth is the number of bytes written on each line of a
+// transcript.
+const transcriptWidth = 16
+
+// A Recorder is a Transport that writes a transcript of all traffic passing
+// through it.
+type Recorder struct {
+	Transport
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	cts   *bool
+	err   error
+
+	// Written data waiting to be recorded, and the time it was first
+	// written.
+	pending   []byte
+	pendingAt time.Duration
+}
+
+// NewRecorder returns a Recorder writing a transcript of the traffic over t
+// to w.
+func NewRecorder(t Transport, w io.Writer) *Recorder {
+	r := &Recorder{Transport: t, w: w, start: time.Now()}
+	fmt.Fprintf(w, "# yaroze transcript %s\n", r.start.Format(time.RFC3339))
+	return r
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.Transport.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		r.flush()
+		r.data(time.Since(r.start), '<', p[:n])
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write holds the lock while writing so that any response to the data can't
+// be recorded before the data itself.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, err := r.Transport.Write(p)
+	if n > 0 {
+		if len(r.pending) == 0 {
+			r.pendingAt = time.Since(r.start)
+		}
+		r.pending = append(r.pending, p[:n]...)
+		if len(r.pending) >= transcriptWidth {
+			full := len(r.pending) / transcriptWidth * transcriptWidth
+			r.data(r.pendingAt, '>', r.pending[:full])
+			r.pending = append(r.pending[:0], r.pending[full:]...)
+			r.pendingAt = time.Since(r.start)
+		}
+	}
+	return n, err
+}
+
+func (r *Recorder) SetRTS(rts bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.Transport.SetRTS(rts); err != nil {
+		return err
+	}
+	r.flush()
+	r.line(time.Since(r.start), "rts %d", btoi(rts))
+	return nil
+}
+
+// SetMode changes the baud rate of the underlying Transport, if it is a
+// ModeSetter.
+func (r *Recorder) SetMode(mode *serial.Mode) error {
+	ms, ok := r.Transport.(ModeSetter)
+	if !ok {
+		return errors.New("cannot change the baud rate of this connection")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := ms.SetMode(mode); err != nil {
+		return err
+	}
+	r.flush()
+	r.line(time.Since(r.start), "baud %d", mode.BaudRate)
+	return nil
+}
+
+// GetModemStatusBits records the state of the CTS line whenever it changes,
+// since it is polled far too often to record every call.
+func (r *Recorder) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	bits, err := r.Transport.GetModemStatusBits()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cts == nil || *r.cts != bits.CTS {
+		cts := bits.CTS
+		r.cts = &cts
+		r.flush()
+		r.line(time.Since(r.start), "cts %d", btoi(cts))
+	}
+	return bits, nil
+}
+
+// Close records any data still waiting to be written to the transcript and
+// closes the underlying Transport.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	r.flush()
+	r.mu.Unlock()
+	return r.Transport.Close()
+}
+
+// Err returns the first error encountered writing the transcript.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// flush records any pending written data, and must be called with r.mu held.
+func (r *Recorder) flush() {
+	if len(r.pending) > 0 {
+		r.data(r.pendingAt, '>', r.pending)
+		r.pending = r.pending[:0]
+	}
+}
+
+func (r *Recorder) data(at time.Duration, dir byte, p []byte) {
+	for len(p) > 0 {
+		n := len(p)
+		if n > transcriptWidth {
+			n = transcriptWidth
+		}
+		hex := make([]string, n)
+		ascii := make([]byte, n)
+		for i, b := range p[:n] {
+			hex[i] = fmt.Sprintf("%02x", b)
+			ascii[i] = '.'
+			if b >= 0x20 && b < 0x7f {
+				ascii[i] = b
+			}
+		}
+		r.line(at, "%c %-*s |%s|", dir, transcriptWidth*3-1, strings.Join(hex, " "), ascii)
+		p = p[n:]
+	}
+}
+
+// line writes a record to the transcript, and must be called with r.mu held.
+func (r *Recorder) line(at time.Duration, format string, args ...interface{}) {
+	if r.err != nil {
+		return
+	}
+	_, r.err = fmt.Fprintf(r.w, "%.6f %s\n", at.Seconds(), fmt.Sprintf(format, args...))
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// A Replay is a Transport that plays back a transcript, standing in for the
+// console that it was recorded from. Data received from the console is
+// delivered once everything sent before it in the transcript has been written,
+// and every byte written is compared against the transcript. Timestamps are
+// ignored, so a replay runs as fast as the Port allows.
+type Replay struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	sent    []byte
+	written int
+	recv    []replayEvent
+	cts     []replayEvent
+	buf     []byte
+	closed  bool
+	err     error
+}
+
+// A replayEvent is data received from the console, or a change of the CTS
+// line, which takes effect once the first after bytes of the data sent to the
+// console have been written.
+type replayEvent struct {
+	after int
+	data  []byte
+	cts   bool
+}
+
+// A ReplayError describes data written during a replay that doesn't match the
+// transcript.
+type ReplayError struct {
+	Offset   int
+	Expected []byte
+	Received []byte
+}
+
+func (e *ReplayError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("replay: unexpected write at offset %d: % x", e.Offset, e.Received)
+	}
+	return fmt.Sprintf("replay: write at offset %d does not match transcript: expected % x, received % x", e.Offset, e.Expected, e.Received)
+}
+
+// NewReplay reads a transcript from r and returns a Replay of it.
+func NewReplay(r io.Reader) (*Replay, error) {
+	rp := &Replay{}
+	rp.cond = sync.NewCond(&rp.mu)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, errors.Errorf("transcript:%d: invalid record %q", n, line)
+		}
+		switch fields[1] {
+		case ">", "<":
+			var data []byte
+			for _, f := range fields[2:] {
+				if strings.HasPrefix(f, "|") {
+					break
+				}
+				b, err := strconv.ParseUint(f, 16, 8)
+				if err != nil {
+					return nil, errors.Errorf("transcript:%d: invalid byte %q", n, f)
+				}
+				data = append(data, byte(b))
+			}
+			if fields[1] == ">" {
+				rp.sent = append(rp.sent, data...)
+			} else {
+				rp.recv = append(rp.recv, replayEvent{after: len(rp.sent), data: data})
+			}
+		case "cts":
+			rp.cts = append(rp.cts, replayEvent{after: len(rp.sent), cts: fields[2] == "1"})
+		case "rts", "baud":
+		default:
+			return nil, errors.Errorf("transcript:%d: unknown record %q", n, fields[1])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// Read returns data received from the console in the transcript, blocking
+// until the data sent before it has been written. Once the transcript is
+// exhausted Read blocks until the Replay is closed, like a console with
+// nothing more to say.
+func (rp *Replay) Read(p []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for len(rp.buf) == 0 {
+		if rp.closed {
+			return 0, io.EOF
+		}
+		if len(rp.recv) > 0 && rp.recv[0].after <= rp.written {
+			rp.buf = rp.recv[0].data
+			rp.recv = rp.recv[1:]
+			continue
+		}
+		rp.cond.Wait()
+	}
+	n := copy(p, rp.buf)
+	rp.buf = rp.buf[n:]
+	return n, nil
+}
+
+// Write compares p against the data sent to the console in the transcript.
+func (rp *Replay) Write(p []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if rp.err != nil {
+		return 0, rp.err
+	}
+	expected := rp.sent[rp.written:]
+	if len(expected) > len(p) {
+		expected = expected[:len(p)]
+	}
+	if !bytes.Equal(expected, p) {
+		rp.err = &ReplayError{
+			Offset:   rp.written,
+			Expected: expected,
+			Received: append([]byte(nil), p...),
+		}
+		return 0, rp.err
+	}
+	rp.written += len(p)
+	rp.cond.Broadcast()
+	return len(p), nil
+}
+
+func (rp *Replay) Close() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.closed = true
+	rp.cond.Broadcast()
+	return nil
+}
+
+// SetRTS is ignored, since the transcript only records what the console did.
+func (rp *Replay) SetRTS(rts bool) error {
+	return nil
+}
+
+// SetMode is ignored, since the transcript only records what the console did.
+func (rp *Replay) SetMode(mode *serial.Mode) error {
+	return nil
+}
+
+// GetModemStatusBits reports the CTS line as last recorded before the data
+// written so far, or as set if the transcript doesn't record it.
+func (rp *Replay) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	cts := true
+	for _, e := range rp.cts {
+		if e.after > rp.written {
+			break
+		}
+		cts = e.cts
+	}
+	return &serial.ModemStatusBits{CTS: cts, DSR: true}, nil
+}
+
+// Err returns the first mismatch found while writing, or an error if not all
+// of the data sent in the transcript has been written.
+func (rp *Replay) Err() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.err != nil {
+		return rp.err
+	}
+	if rp.written < len(rp.sent) {
+		return errors.Errorf("replay: only %d of %d bytes were written", rp.written, len(rp.sent))
+	}
+	return nil
+}
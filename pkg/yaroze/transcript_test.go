@@ -0,0 +1,117 @@
+package yaroze
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ChrisRx/psxsdk/pkg/yaroze/yarozetest"
+	"github.com/pkg/errors"
+)
+
+// session runs the same exchange with the console against any Transport.
+func session(t *testing.T, tr Transport, cfg *PortConfig) Registers {
+	p, err := NewPort(tr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("yaroze"), 500)
+	if err := p.Upload(ctx, 0x80010000, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetRegister(ctx, "pc", 0x80010000); err != nil {
+		t.Fatal(err)
+	}
+	regs, err := p.Registers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return regs
+}
+
+func TestTranscriptReplay(t *testing.T) {
+	var transcript bytes.Buffer
+	expected := session(t, yarozetest.NewConsole(), &PortConfig{Transcript: &transcript})
+
+	for _, s := range []string{"rts 1", "cts 1", "> 73 72 20 70 63", "|sr pc 80010000.|"} {
+		if !strings.Contains(transcript.String(), s) {
+			t.Errorf("expected transcript to contain %q", s)
+		}
+	}
+
+	rp, err := NewReplay(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	regs := session(t, rp, &PortConfig{})
+	if err := rp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if regs["pc"] != expected["pc"] {
+		t.Fatalf("expected pc to be 0x%08X, received 0x%08X", expected["pc"], regs["pc"])
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	var transcript bytes.Buffer
+	p, err := NewPort(yarozetest.NewConsole(), &PortConfig{Transcript: &transcript})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetRegister(context.Background(), "sp", 0x801fff00); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	rp, err := NewReplay(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewPort(rp, &PortConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.SetRegister(context.Background(), "sp", 0x801ffff0)
+	if _, ok := errors.Cause(err).(*ReplayError); !ok {
+		t.Fatalf("expected ReplayError, received %v", err)
+	}
+}
+
+// The NAK handling of uploads is covered by replaying a transcript, recorded
+// from a yarozetest.Console rejecting the first attempt at a block.
+func TestReplayUploadNAK(t *testing.T) {
+	f, err := os.Open("testdata/upload-nak.transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rp, err := NewReplay(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Progress
+	p, err := NewPort(rp, &PortConfig{
+		Retries:  1,
+		Progress: func(p Progress) { events = append(events, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Upload(context.Background(), 0x80090000, []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[len(events)-1].Retries != 1 {
+		t.Fatalf("expected the upload to be retried once, received %+v", events)
+	}
+}
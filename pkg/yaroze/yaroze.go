@@ -4,8 +4,7 @@
 package yaroze
 
 import (
-	"encoding/binary"
-
+	"github.com/ChrisRx/psxsdk/pkg/asm"
 	"github.com/ChrisRx/psxsdk/pkg/binutils"
 	"github.com/ChrisRx/psxsdk/pkg/format/psx"
 )
@@ -14,20 +13,31 @@ func Combine(f *psx.File) (*psx.File, error) {
 	return binutils.Combine(Libps, f)
 }
 
+// bootPatch starts a Net Yaroze program the way the boot disc does, by
+// initializing libps before jumping to the entry point of the program.
+const bootPatch = `
+	.equ	libps_init, 0x80010030
+	jal	libps_init
+	nop
+	j	start
+	nop
+`
+
+// PatchExecutable appends the boot patch to the text of f and makes it the
+// entry point, so that the executable can be started without the boot disc.
 func PatchExecutable(f *psx.File) error {
-	var yarozePatch = []uint32{
-		0x0c00400c,
-		0x0,
-		0x08000000 + (f.PC0&0x03ffffff)>>2,
-		0x0,
+	text := f.Section("text")
+	addr := f.PC0 + uint32(len(text.Data))
+	p, err := asm.Assemble(bootPatch, addr)
+	if err != nil {
+		return err
 	}
-	patchData := make([]byte, 4*len(yarozePatch))
-	for i, val := range yarozePatch {
-		binary.LittleEndian.PutUint32(patchData[4*i:], val)
+	if err := p.Link(map[string]uint32{"start": f.PC0}); err != nil {
+		return err
 	}
 
-	f.FileHeader.PC0 += uint32(len(f.Section("text").Data))
-	f.FileHeader.TextSize += uint32(len(patchData))
-	f.Section("text").Data = append(f.Section("text").Data, patchData...)
+	f.FileHeader.PC0 = addr
+	f.FileHeader.TextSize += uint32(len(p.Code))
+	text.Data = append(text.Data, p.Code...)
 	return nil
 }
@@ -0,0 +1,446 @@
+// Package yarozetest provides a fake Net Yaroze console for testing code that
+// communicates with the Net Yaroze monitor.
+package yarozetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.bug.st/serial"
+)
+
+const (
+	Prompt = ">>"
+
+	// MemorySize is the amount of main RAM available on the console.
+	MemorySize = 2 * 1024 * 1024
+
+	BlockSize = 2048
+
+	ACK = 0x59
+	NAK = 0x4e
+)
+
+// registerNames are the registers displayed by the dr command, in order.
+var registerNames = []string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+	"epc", "hi", "lo", "sr", "cause",
+}
+
+type state int
+
+const (
+	stateCommand state = iota
+	stateBinaryHeader
+	stateBinary
+	stateBlock
+	stateRunning
+)
+
+// An Upload records a single bwr transfer received by the Console.
+type Upload struct {
+	Addr uint32
+	Data []byte
+}
+
+// A Console emulates the serial monitor of a Net Yaroze console. It speaks the
+// same bwr/sr/go protocol as the real monitor, validates the checksum of every
+// block received and records everything uploaded to it. A Console satisfies
+// yaroze.Transport so it can be passed directly to yaroze.NewPort.
+type Console struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	out    bytes.Buffer
+	closed bool
+	rts    bool
+
+	// baud is the rate used by the monitor and hostBaud the rate set by
+	// SetMode. Either being zero matches any rate. The monitor switches to
+	// nextBaud once everything it has sent at the old rate has been read.
+	baud     int
+	hostBaud int
+	nextBaud int
+
+	registers map[string]uint32
+	memory    []byte
+	uploads   []*Upload
+	running   bool
+	rejects   map[uint32]int
+	input     []byte
+
+	state  state
+	line   []byte
+	header []byte
+	upload *Upload
+	block  []byte
+	blocks int
+}
+
+// NewConsole returns a Console sitting at the monitor prompt.
+func NewConsole() *Console {
+	c := &Console{
+		registers: make(map[string]uint32),
+		memory:    make([]byte, MemorySize),
+		rejects:   make(map[uint32]int),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Read reads output sent by the monitor, blocking until some is available. If
+// the baud rate doesn't match that of the monitor, every byte is garbled.
+func (c *Console) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.out.Len() == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	n, err := c.out.Read(p)
+	if c.mismatched() {
+		for i := range p[:n] {
+			p[i] = 0xff
+		}
+	}
+	if c.out.Len() == 0 && c.nextBaud != 0 {
+		c.baud = c.nextBaud
+		c.nextBaud = 0
+	}
+	return n, err
+}
+
+// Write sends data to the monitor, which is processed immediately. If the baud
+// rate doesn't match that of the monitor, the data is lost.
+func (c *Console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if !c.mismatched() {
+		for _, b := range p {
+			c.handle(b)
+		}
+	}
+	c.cond.Broadcast()
+	return len(p), nil
+}
+
+func (c *Console) mismatched() bool {
+	return c.baud != 0 && c.hostBaud != 0 && c.baud != c.hostBaud
+}
+
+func (c *Console) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Console) SetRTS(rts bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rts = rts
+	return nil
+}
+
+// SetMode sets the baud rate used to talk to the monitor.
+func (c *Console) SetMode(mode *serial.Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hostBaud = mode.BaudRate
+	return nil
+}
+
+// SetBaudRate sets the baud rate used by the monitor.
+func (c *Console) SetBaudRate(rate int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baud = rate
+}
+
+// BaudRate returns the baud rate used by the monitor.
+func (c *Console) BaudRate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.baud
+}
+
+// GetModemStatusBits reports the console as always being clear to send.
+func (c *Console) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{CTS: true, DSR: true}, nil
+}
+
+// Register returns the value of the named register as set by the sr command.
+func (c *Console) Register(name string) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registers[name]
+}
+
+// SetRegister sets the value of the named register, as if the console had
+// stopped with it.
+func (c *Console) SetRegister(name string, v uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registers[name] = v
+}
+
+// WriteMemory copies data into console memory starting at addr.
+func (c *Console) WriteMemory(addr uint32, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, v := range data {
+		c.memory[(addr+uint32(i))&(MemorySize-1)] = v
+	}
+}
+
+// ReadMemory returns a copy of n bytes of console memory starting at addr.
+func (c *Console) ReadMemory(addr uint32, n int) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.read(addr, n)
+}
+
+// Uploads returns every bwr transfer completed so far.
+func (c *Console) Uploads() []*Upload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Upload(nil), c.uploads...)
+}
+
+// Reject causes the next n blocks destined for addr to be rejected, as if
+// they had been corrupted in transit.
+func (c *Console) Reject(addr uint32, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejects[addr] += n
+}
+
+// Running reports whether a program has been started with the go command and
+// not interrupted since.
+func (c *Console) Running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// Print sends data as output from the running program, such as a file server
+// request.
+func (c *Console) Print(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.Write(data)
+	c.cond.Broadcast()
+}
+
+// Exception stops the running program as if it had raised an exception at
+// epc, such as by reaching a break instruction, and returns to the monitor
+// prompt. The Cause register is set to cause.
+func (c *Console) Exception(epc, cause uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registers["epc"] = epc
+	c.registers["cause"] = cause
+	c.running = false
+	c.state = stateCommand
+	c.out.WriteString("\r\n" + Prompt)
+	c.cond.Broadcast()
+}
+
+// Input returns and clears the data received by the running program.
+func (c *Console) Input() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data := c.input
+	c.input = nil
+	return data
+}
+
+func (c *Console) read(addr uint32, n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = c.memory[(addr+uint32(i))&(MemorySize-1)]
+	}
+	return data
+}
+
+func (c *Console) handle(b byte) {
+	switch c.state {
+	case stateCommand:
+		switch b {
+		case 0x03:
+			c.line = c.line[:0]
+			c.out.WriteString("\r\n" + Prompt)
+		case '\r':
+			c.out.WriteString("\r\n")
+			line := string(c.line)
+			c.line = c.line[:0]
+			c.exec(strings.Fields(line))
+		default:
+			c.out.WriteByte(b)
+			c.line = append(c.line, b)
+		}
+	case stateBinaryHeader:
+		if len(c.header) == 0 && b != 0x01 {
+			return
+		}
+		c.header = append(c.header, b)
+		if len(c.header) < 9 {
+			return
+		}
+		c.upload = &Upload{
+			Addr: binary.BigEndian.Uint32(c.header[1:]),
+			Data: make([]byte, 0, binary.BigEndian.Uint32(c.header[5:])),
+		}
+		c.header = c.header[:0]
+		c.blocks = 0
+		c.state = stateBinary
+	case stateBinary:
+		switch b {
+		case 0x02:
+			c.block = c.block[:0]
+			c.state = stateBlock
+		case 0x0d:
+			c.uploads = append(c.uploads, c.upload)
+			c.upload = nil
+			c.out.WriteString("end binary\r\n" + Prompt)
+			c.state = stateCommand
+		}
+	case stateBlock:
+		if len(c.block) < BlockSize {
+			c.block = append(c.block, b)
+			return
+		}
+		var sum uint8
+		for _, v := range c.block {
+			sum += v
+		}
+		addr := c.upload.Addr + uint32(c.blocks*BlockSize)
+		if sum != b || c.rejects[addr] > 0 {
+			if c.rejects[addr] > 0 {
+				c.rejects[addr]--
+			}
+			c.out.WriteByte(NAK)
+			c.state = stateBinary
+			return
+		}
+		// like the monitor, the whole block is written to memory, including
+		// the padding of the final block
+		for i, v := range c.block {
+			c.memory[(addr+uint32(i))&(MemorySize-1)] = v
+		}
+		n := cap(c.upload.Data) - len(c.upload.Data)
+		if n > BlockSize {
+			n = BlockSize
+		}
+		c.upload.Data = append(c.upload.Data, c.block[:n]...)
+		c.blocks++
+		c.out.WriteByte(ACK)
+		c.state = stateBinary
+	case stateRunning:
+		if b == 0x03 {
+			c.running = false
+			c.state = stateCommand
+			c.out.WriteString("\r\n" + Prompt)
+			return
+		}
+		c.input = append(c.input, b)
+	}
+}
+
+func (c *Console) exec(args []string) {
+	if len(args) == 0 {
+		c.out.WriteString(Prompt)
+		return
+	}
+	switch args[0] {
+	case "cls":
+	case "sr":
+		if len(args) != 3 {
+			c.out.WriteString("usage: sr <reg> <value>\r\n")
+			break
+		}
+		v, err := strconv.ParseUint(args[2], 16, 32)
+		if err != nil {
+			c.out.WriteString(fmt.Sprintf("invalid value %q\r\n", args[2]))
+			break
+		}
+		c.registers[args[1]] = uint32(v)
+	case "dr":
+		for i, name := range registerNames {
+			c.out.WriteString(fmt.Sprintf("%-5s=%08x", name, c.registers[name]))
+			if i%4 == 3 || i == len(registerNames)-1 {
+				c.out.WriteString("\r\n")
+			} else {
+				c.out.WriteString("  ")
+			}
+		}
+	case "dw":
+		if len(args) != 3 {
+			c.out.WriteString("usage: dw <addr> <count>\r\n")
+			break
+		}
+		addr, err := strconv.ParseUint(args[1], 16, 32)
+		if err != nil {
+			c.out.WriteString(fmt.Sprintf("invalid address %q\r\n", args[1]))
+			break
+		}
+		n, err := strconv.ParseUint(args[2], 16, 32)
+		if err != nil {
+			c.out.WriteString(fmt.Sprintf("invalid count %q\r\n", args[2]))
+			break
+		}
+		for i := uint32(0); i < uint32(n); i++ {
+			a := uint32(addr) + i*4
+			if i%4 == 0 {
+				c.out.WriteString(fmt.Sprintf("%08x:", a))
+			}
+			c.out.WriteString(fmt.Sprintf(" %08x", binary.LittleEndian.Uint32(c.read(a, 4))))
+			if i%4 == 3 || i == uint32(n)-1 {
+				c.out.WriteString("\r\n")
+			}
+		}
+	case "bwr":
+		c.out.WriteString("binary mode\r\n")
+		c.state = stateBinaryHeader
+		return
+	case "baud":
+		rate := 0
+		if len(args) == 2 {
+			rate, _ = strconv.Atoi(args[1])
+		}
+		if !validBaudRate(rate) {
+			c.out.WriteString("usage: baud <9600|19200|38400|57600|115200>\r\n")
+			break
+		}
+		c.nextBaud = rate
+		return
+	case "go":
+		c.running = true
+		c.state = stateRunning
+		return
+	default:
+		c.out.WriteString(fmt.Sprintf("%s: unknown command\r\n", args[0]))
+	}
+	c.out.WriteString(Prompt)
+}
+
+func validBaudRate(rate int) bool {
+	switch rate {
+	case 9600, 19200, 38400, 57600, 115200:
+		return true
+	}
+	return false
+}